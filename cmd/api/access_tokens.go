@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// personal access tokens are told apart from JWTs by this prefix
+const personalAccessTokenPrefix = "gat_"
+
+// scopes a personal access token can be given, mount() names the one every route needs
+const (
+	scopePostsRead      = "posts:read"
+	scopePostsWrite     = "posts:write"
+	scopeCommentsRead   = "comments:read"
+	scopeCommentsWrite  = "comments:write"
+	scopeUsersRead      = "users:read"
+	scopeFollowersWrite = "followers:write"
+	scopeFeedRead       = "feed:read"
+)
+
+type CreatePersonalAccessTokenPayload struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,max=20,unique,dive,oneof=posts:read posts:write comments:read comments:write users:read followers:write feed:read"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+type PersonalAccessTokenWithToken struct {
+	*store.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// CreatePersonalAccessToken godoc
+//
+//	@Summary		Create a personal access token
+//	@Description	Creates a token for scripts limited to the given scopes, the token is only shown in this response
+//	@Tags			tokens
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreatePersonalAccessTokenPayload	true	"Name, scopes and expiry"
+//	@Success		201		{object}	PersonalAccessTokenWithToken
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens [post]
+func (app *application) createPersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	var payload CreatePersonalAccessTokenPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	plainToken := personalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	accessToken := &store.PersonalAccessToken{
+		UserID: user.ID,
+		Name:   payload.Name,
+		Token:  hashToken(plainToken),
+		Scopes: payload.Scopes,
+	}
+	if payload.ExpiresInDays > 0 {
+		expiresAt := time.Now().Add(time.Hour * 24 * time.Duration(payload.ExpiresInDays))
+		accessToken.ExpiresAt = &expiresAt
+	}
+
+	if err := app.store.PersonalAccessTokens.Create(r.Context(), accessToken); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := PersonalAccessTokenWithToken{
+		PersonalAccessToken: accessToken,
+		Token:               plainToken,
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, response); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListPersonalAccessTokens godoc
+//
+//	@Summary		List personal access tokens
+//	@Description	Lists the personal access tokens of the current user with their last use
+//	@Tags			tokens
+//	@Produce		json
+//	@Success		200	{array}		store.PersonalAccessToken
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens [get]
+func (app *application) listPersonalAccessTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	tokens, err := app.store.PersonalAccessTokens.ListByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RevokePersonalAccessToken godoc
+//
+//	@Summary		Revoke a personal access token
+//	@Description	Deletes a personal access token of the current user
+//	@Tags			tokens
+//	@Produce		json
+//	@Param			tokenId	path		int		true	"Token ID"
+//	@Success		204		{string}	string	"Token revoked"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/tokens/{tokenId} [delete]
+func (app *application) revokePersonalAccessTokenHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.PersonalAccessTokens.Delete(r.Context(), user.ID, tokenID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// RevokeUserSessions godoc
+//
+//	@Summary		Revoke all sessions of a user
+//	@Description	Rejects every token issued to the user so far, revokes their refresh tokens and deletes their personal access tokens
+//	@Tags			admin
+//	@Produce		json
+//	@Param			userId	path		int		true	"User ID"
+//	@Success		204		{string}	string	"Sessions revoked"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/users/{userId}/sessions [delete]
+func (app *application) revokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.Users.InvalidateTokens(r.Context(), userID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListUnactivatedUsers godoc
+//
+//	@Summary		List unactivated users
+//	@Description	Lists accounts older than the configured age that were never activated
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.User
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/users/unactivated [get]
+func (app *application) listUnactivatedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := app.store.Users.ListUnactivated(r.Context(), app.config.mail.unactivatedMaxAge)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, users); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// PurgeUnactivatedUsers godoc
+//
+//	@Summary		Purge unactivated users
+//	@Description	Deletes accounts older than the configured age that were never activated
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	map[string]int64
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/users/unactivated [delete]
+func (app *application) purgeUnactivatedUsersHandler(w http.ResponseWriter, r *http.Request) {
+	deleted, err := app.store.Users.PurgeUnactivated(r.Context(), app.config.mail.unactivatedMaxAge)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, map[string]int64{"deleted": deleted}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListExpiredInvitations godoc
+//
+//	@Summary		List expired invitations
+//	@Description	Lists activation invitations that expired before being used
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.Invitation
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/invitations/expired [get]
+func (app *application) listExpiredInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	invitations, err := app.store.Users.ListExpiredInvitations(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, invitations); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// PurgeExpiredInvitations godoc
+//
+//	@Summary		Purge expired invitations
+//	@Description	Deletes activation invitations that expired before being used
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	map[string]int64
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/invitations/expired [delete]
+func (app *application) purgeExpiredInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	deleted, err := app.store.Users.PurgeExpiredInvitations(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, map[string]int64{"deleted": deleted}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListLockouts godoc
+//
+//	@Summary		List login lockouts
+//	@Description	Lists the accounts and addresses that are locked out of logging in right now
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.LoginAttempt
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/lockouts [get]
+func (app *application) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := app.store.LoginAttempts.ListLocked(r.Context(), time.Now())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, lockouts); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ClearLockout godoc
+//
+//	@Summary		Clear a login lockout
+//	@Description	Forgets the failed logins of an account (account:<email>), an admin (admin:<username>) or an address (ip:<address>)
+//	@Tags			admin
+//	@Produce		json
+//	@Param			key	path		string	true	"Lockout key"
+//	@Success		204	{string}	string	"Lockout cleared"
+//	@Failure		401	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/lockouts/{key} [delete]
+func (app *application) clearLockoutHandler(w http.ResponseWriter, r *http.Request) {
+	// emails arrive percent encoded when the client escapes the @
+	key, err := url.PathUnescape(chi.URLParam(r, "key"))
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.LoginAttempts.Delete(r.Context(), key); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
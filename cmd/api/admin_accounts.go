@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/hasher"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// the credentials the template ships with, they only work outside production
+const (
+	defaultAdminUsername = "admin"
+	defaultAdminPassword = "admin"
+	defaultTokenSecret   = "example"
+)
+
+var errInvalidAdminCredentials = errors.New("invalid credentials")
+
+// an unknown username or email is verified against this hash so it takes as
+// long to reject as a wrong password
+var dummyPasswordHash = sync.OnceValues(func() ([]byte, error) {
+	return hasher.Default.Hash("not the password of any account")
+})
+
+func (app *application) authenticateAdmin(ctx context.Context, username, password string) (*store.Admin, error) {
+	admin, err := app.store.Admins.GetByUsername(ctx, username)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+		hash, err := dummyPasswordHash()
+		if err != nil {
+			return nil, err
+		}
+		hasher.Default.Verify(password, hash)
+		return nil, errInvalidAdminCredentials
+	}
+
+	if err := admin.Password.Compare(password); err != nil {
+		return nil, errInvalidAdminCredentials
+	}
+	return admin, nil
+}
+
+// stores who made an admin request once it has been answered
+func (app *application) recordAdminAudit(r *http.Request, admin *store.Admin, status int) {
+	// handlers that write nothing answer with 200
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	entry := &store.AdminAuditEntry{
+		AdminID:  &admin.ID,
+		Username: admin.Username,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		IP:       clientIP(r),
+		Status:   status,
+	}
+	// the request context may already be cancelled once the response is written
+	if err := app.store.Admins.RecordAudit(context.WithoutCancel(r.Context()), entry); err != nil {
+		app.logger.Errorw("recording admin audit entry failed", "admin", admin.Username, "path", entry.Path, "error", err.Error())
+	}
+}
+
+// creates the first admin account from AUTH_BASIC_USER and AUTH_BASIC_PASSWORD
+// when there is none. In production the server refuses to start while the
+// default password is configured or still opens an account
+func (app *application) bootstrapAdmin(ctx context.Context) error {
+	basic := app.config.auth.basic
+	isProdEnv := app.config.isProduction()
+
+	if isProdEnv && basic.password == defaultAdminPassword {
+		return errors.New("refusing to start in production with the default admin password, set AUTH_BASIC_PASSWORD")
+	}
+
+	count, err := app.store.Admins.Count(ctx)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		if basic.username == "" || basic.password == "" {
+			app.logger.Warn("there is no admin account, set AUTH_BASIC_USER and AUTH_BASIC_PASSWORD to create one")
+			return nil
+		}
+
+		admin := &store.Admin{Username: basic.username}
+		if err := admin.Password.Set(basic.password); err != nil {
+			return err
+		}
+		if err := app.store.Admins.Create(ctx, admin); err != nil {
+			return err
+		}
+		app.logger.Infow("created the first admin account", "username", admin.Username)
+		return nil
+	}
+
+	if isProdEnv {
+		_, err := app.authenticateAdmin(ctx, defaultAdminUsername, defaultAdminPassword)
+		switch {
+		case err == nil:
+			return errors.New("refusing to start in production while an admin account has the default password")
+		case !errors.Is(err, errInvalidAdminCredentials):
+			return err
+		}
+	}
+	return nil
+}
+
+func getAuthAdminFromCtx(r *http.Request) *store.Admin {
+	admin, _ := r.Context().Value(authAdminCtx).(*store.Admin)
+	return admin
+}
+
+type CreateAdminPayload struct {
+	Username string `json:"username" validate:"required,max=100"`
+	Password string `json:"password" validate:"required,min=12,max=256"`
+}
+
+// ListAdmins godoc
+//
+//	@Summary		List admin accounts
+//	@Description	Lists the operator accounts that can use the admin endpoints
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.Admin
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/accounts [get]
+func (app *application) listAdminsHandler(w http.ResponseWriter, r *http.Request) {
+	admins, err := app.store.Admins.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, admins); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateAdmin godoc
+//
+//	@Summary		Create an admin account
+//	@Description	Adds an operator account for the admin endpoints
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateAdminPayload	true	"Username and password"
+//	@Success		201		{object}	store.Admin
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/accounts [post]
+func (app *application) createAdminHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateAdminPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	admin := &store.Admin{Username: payload.Username}
+	if err := admin.Password.Set(payload.Password); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Admins.Create(r.Context(), admin); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateAdmin):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, admin); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteAdmin godoc
+//
+//	@Summary		Delete an admin account
+//	@Description	Removes an operator account, the account used for the request can't be removed
+//	@Tags			admin
+//	@Produce		json
+//	@Param			adminId	path		int		true	"Admin ID"
+//	@Success		204		{string}	string	"Admin deleted"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/accounts/{adminId} [delete]
+func (app *application) deleteAdminHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, err := strconv.ParseInt(chi.URLParam(r, "adminId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// removing the own account could leave nobody to get back in
+	if admin := getAuthAdminFromCtx(r); admin.ID == adminID {
+		app.forbiddenResponse(w, r, fmt.Errorf("you can not delete your own admin account"))
+		return
+	}
+
+	if err := app.store.Admins.Delete(r.Context(), adminID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListAdminAudit godoc
+//
+//	@Summary		List the admin audit log
+//	@Description	Lists the requests made with admin accounts, newest first by default
+//	@Tags			admin
+//	@Produce		json
+//	@Param			limit	query		int		false	"Limit"
+//	@Param			offset	query		int		false	"Offset"
+//	@Param			sort	query		string	false	"Sort"
+//	@Success		200		{array}		store.AdminAuditEntry
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/audit [get]
+func (app *application) listAdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	fq := store.PaginatedFeedQuery{
+		Limit:  20,
+		Offset: 0,
+		Sort:   "desc",
+	}
+
+	fq, err := fq.Parse(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(fq); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	entries, err := app.store.Admins.ListAudit(r.Context(), fq)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, entries); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/harshvse/go-api/docs"
 	"github.com/harshvse/go-api/internal/auth"
+	"github.com/harshvse/go-api/internal/emailpolicy"
+	"github.com/harshvse/go-api/internal/lockout"
 	"github.com/harshvse/go-api/internal/mailer"
+	"github.com/harshvse/go-api/internal/oidc"
+	"github.com/harshvse/go-api/internal/passwordpolicy"
+	"github.com/harshvse/go-api/internal/ratelimiter"
 	"github.com/harshvse/go-api/internal/store"
+	"github.com/harshvse/go-api/internal/webauthn"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
 	"go.uber.org/zap"
 )
@@ -21,26 +29,83 @@ type application struct {
 	logger        *zap.SugaredLogger
 	mailer        mailer.Client
 	authenticator auth.Authenticator
+	// for the endpoints that send emails
+	rateLimiter ratelimiter.Limiter
+	// for the logins that store a challenge before the user is known, a shared
+	// address has to fit many people signing in
+	loginRateLimiter ratelimiter.Limiter
+	totp             *auth.TOTP
+	webauthn         *webauthn.RelyingParty
+	oidcProviders    map[string]*oidc.Provider
+	loginGuard       *lockout.Guard
+	passwordPolicy   *passwordpolicy.Policy
+	emailPolicy      *emailpolicy.Policy
 }
 
 type config struct {
-	addr        string
-	auth        authConfig
-	db          dbConfig
-	mail        mailConfig
-	env         string
-	version     string
-	frontendURL string
+	addr             string
+	auth             authConfig
+	db               dbConfig
+	mail             mailConfig
+	rateLimiter      rateLimiterConfig
+	loginRateLimiter rateLimiterConfig
+	webauthn         webauthnConfig
+	lockout          lockoutConfig
+	oidc             oidcConfig
+	registration     registrationConfig
+	password         passwordConfig
+	env              string
+	version          string
+	frontendURL      string
+}
+
+// ENVIRONMENT is written in any case, the template itself uses DEVELOPMENT
+func (c config) isProduction() bool {
+	return strings.EqualFold(c.env, "production")
 }
 
 type authConfig struct {
 	basic basicConfig
+	token tokenConfig
+	// bearer, cookie or both
+	mode   string
+	cookie cookieConfig
+	// signed requests of internal services
+	service serviceAuthConfig
+	// how long after the password or 2fa was last checked sensitive routes stay open
+	reauthMaxAge time.Duration
 }
+
+// seeds the first admin account, later accounts are managed through the api
 type basicConfig struct {
 	username string
 	password string
 }
 
+type tokenConfig struct {
+	secret     string
+	keys       string
+	keyGrace   time.Duration
+	exp        time.Duration
+	refreshExp time.Duration
+	// lifetime of the token between the password and the 2fa step of a login
+	twoFactorExp time.Duration
+	iss          string
+	// how often expired entries of the revocation list are removed
+	pruneInterval time.Duration
+}
+
+type serviceAuthConfig struct {
+	// how far the timestamp of a signed request may be off from our clock
+	clockSkew time.Duration
+}
+
+type cookieConfig struct {
+	// empty keeps the cookies on the host of the api
+	domain   string
+	sameSite http.SameSite
+}
+
 type dbConfig struct {
 	addr         string
 	maxOpenConns int
@@ -49,10 +114,65 @@ type dbConfig struct {
 }
 
 type mailConfig struct {
-	exp        time.Duration
-	fromEmail  string
-	mailTrap   mailTrap
-	maxRetries int
+	exp              time.Duration
+	passwordResetExp time.Duration
+	magicLinkExp     time.Duration
+	emailChangeExp   time.Duration
+	// accounts that were never activated are purged after this age
+	unactivatedMaxAge time.Duration
+	fromEmail         string
+	mailTrap          mailTrap
+	maxRetries        int
+}
+
+type rateLimiterConfig struct {
+	requestsPerTimeFrame int
+	timeFrame            time.Duration
+}
+
+type webauthnConfig struct {
+	rpID    string
+	rpName  string
+	origin  string
+	timeout time.Duration
+}
+
+type lockoutConfig struct {
+	// failed logins are counted for the email and for the address they come from
+	account lockout.Policy
+	ip      lockout.Policy
+	// basic auth of the admin api, every request sends the credentials so
+	// parallel requests of a signed in admin must fit in the free failures
+	admin lockout.Policy
+}
+
+type oidcConfig struct {
+	providers []oidc.Config
+	// how long a started login or link can take at the provider
+	stateExp time.Duration
+}
+
+type registrationConfig struct {
+	// open, invite-only or closed
+	mode string
+	// the most uses a user can give one of their invite codes
+	userInviteMaxUses int
+	// how many codes a user can have that can still be used
+	userInviteMaxActive int
+	// the longest a code of a user stays valid, whole days
+	userInviteExp time.Duration
+	// file replacing the bundled disposable domain list, empty keeps the
+	// bundled one
+	disposableDomainsFile string
+}
+
+type passwordConfig struct {
+	minLength int
+	// the lowest accepted strength score from 0 to 4
+	minScore int
+	// directory of the breached password corpus split by hash prefix, empty
+	// skips the check
+	breachedCorpus string
 }
 
 type mailTrap struct {
@@ -70,7 +190,26 @@ func (app *application) mount() http.Handler {
 
 	r.Use(middleware.Timeout(60 * time.Second))
 
+	r.Get("/.well-known/jwks.json", app.jwksHandler)
+
+	// user provisioning from identity providers, SCIM has its own versioning
+	r.Route("/scim/v2", func(r chi.Router) {
+		r.Get("/ServiceProviderConfig", app.scimServiceProviderConfigHandler)
+		r.Get("/ResourceTypes", app.scimResourceTypesHandler)
+		r.Get("/Schemas", app.scimSchemasHandler)
+		r.Route("/Users", func(r chi.Router) {
+			r.Use(app.ProvisioningTokenMiddleware)
+			r.Get("/", app.scimListUsersHandler)
+			r.Post("/", app.scimCreateUserHandler)
+			r.Get("/{userId}", app.scimGetUserHandler)
+			r.Patch("/{userId}", app.scimPatchUserHandler)
+			r.Delete("/{userId}", app.scimDeleteUserHandler)
+		})
+	})
+
 	r.Route("/v1", func(r chi.Router) {
+		r.Use(app.CSRFMiddleware)
+
 		r.With(app.BasicAuthMiddleware()).
 			Get("/health", app.healthCheckHandler)
 
@@ -79,36 +218,152 @@ func (app *application) mount() http.Handler {
 
 		r.Route("/authentication", func(r chi.Router) {
 			r.Post("/user", app.registerUserHandler)
+			r.Post("/token", app.createTokenHandler)
+			r.Post("/token/2fa", app.createTwoFactorTokenHandler)
+			r.Post("/refresh", app.refreshTokenHandler)
+			r.Get("/csrf", app.getCSRFTokenHandler)
+			r.Post("/passkey/begin", app.beginPasskeyLoginHandler)
+			r.Post("/passkey/finish", app.finishPasskeyLoginHandler)
+			r.With(app.AuthTokenMiddleware, app.SessionOnlyMiddleware).Post("/logout", app.logoutHandler)
+			r.With(app.AuthTokenMiddleware, app.SessionOnlyMiddleware).Post("/reauth", app.reauthenticateHandler)
+			r.Post("/password/forgot", app.forgotPasswordHandler)
+			r.Post("/password/reset", app.resetPasswordHandler)
+			r.Post("/activation/resend", app.resendActivationHandler)
+			r.Post("/magic-link", app.requestMagicLinkHandler)
+			r.Post("/magic-link/exchange", app.exchangeMagicLinkHandler)
+			r.Get("/oidc/{provider}", app.startOIDCLoginHandler)
+			r.Post("/oidc/{provider}/callback", app.oidcLoginCallbackHandler)
+		})
+
+		// admin
+		r.Route("/admin", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(app.BasicAuthMiddleware())
+				r.Delete("/users/{userId}/sessions", app.revokeUserSessionsHandler)
+				r.Get("/users/unactivated", app.listUnactivatedUsersHandler)
+				r.Delete("/users/unactivated", app.purgeUnactivatedUsersHandler)
+				r.Get("/invitations/expired", app.listExpiredInvitationsHandler)
+				r.Delete("/invitations/expired", app.purgeExpiredInvitationsHandler)
+				r.Get("/lockouts", app.listLockoutsHandler)
+				r.Delete("/lockouts/{key}", app.clearLockoutHandler)
+				r.Get("/accounts", app.listAdminsHandler)
+				r.Post("/accounts", app.createAdminHandler)
+				r.Delete("/accounts/{adminId}", app.deleteAdminHandler)
+				r.Get("/audit", app.listAdminAuditHandler)
+				r.Get("/invites", app.listAllInviteCodesHandler)
+				r.Post("/invites", app.createAdminInviteCodeHandler)
+				r.Delete("/invites/{inviteId}", app.deleteAnyInviteCodeHandler)
+				r.Get("/users/{userId}/referrals", app.getReferralReportHandler)
+				r.Get("/service-clients", app.listServiceClientsHandler)
+				r.Post("/service-clients", app.createServiceClientHandler)
+				r.Delete("/service-clients/{clientId}", app.revokeServiceClientHandler)
+				r.Get("/provisioning-tokens", app.listProvisioningTokensHandler)
+				r.Post("/provisioning-tokens", app.createProvisioningTokenHandler)
+				r.Delete("/provisioning-tokens/{tokenId}", app.revokeProvisioningTokenHandler)
+				r.Get("/email-domains", app.listEmailDomainRulesHandler)
+				r.Post("/email-domains", app.createEmailDomainRuleHandler)
+				r.Delete("/email-domains/{ruleId}", app.deleteEmailDomainRuleHandler)
+				r.Post("/email-domains/disposable/reload", app.reloadDisposableDomainsHandler)
+				// the only way to grant roles before any user can manage them
+				r.Put("/operator/users/{userId}/role", app.grantRoleAsOperatorHandler)
+				r.Delete("/operator/users/{userId}/role", app.revokeRoleAsOperatorHandler)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.SessionOnlyMiddleware)
+				r.Use(app.RequirePermission(permissionRolesManage))
+				r.Get("/roles", app.listRolesHandler)
+				r.Put("/users/{userId}/role", app.grantRoleHandler)
+				r.Delete("/users/{userId}/role", app.revokeRoleHandler)
+			})
+		})
+
+		// housekeeping for batch jobs signing their requests
+		r.Route("/service", func(r chi.Router) {
+			r.Use(app.ServiceAuthMiddleware)
+			r.Delete("/users/unactivated", app.purgeUnactivatedUsersHandler)
+			r.Delete("/invitations/expired", app.purgeExpiredInvitationsHandler)
 		})
 
 		// posts
 		r.Route("/posts", func(r chi.Router) {
-			r.Post("/create", app.createNewPostHandler)
+			r.Use(app.AuthTokenMiddleware)
+			r.With(app.RequireScope(scopePostsWrite)).Post("/create", app.createNewPostHandler)
 			r.Route("/{postId}", func(r chi.Router) {
 				r.Use(app.postContextMiddleware)
-				r.Get("/", app.getPostHandler)
-				r.Delete("/", app.deletePostHandler)
-				r.Patch("/", app.updatePostHandler)
+				r.With(app.RequireScope(scopePostsRead)).Get("/", app.getPostHandler)
+				r.With(app.RequireScope(scopePostsWrite), app.RequirePostOwnership(permissionPostsDeleteAny)).Delete("/", app.deletePostHandler)
+				r.With(app.RequireScope(scopePostsWrite), app.RequirePostOwnership("")).Patch("/", app.updatePostHandler)
 			})
 		})
 
 		// comments
 		r.Route("/comments", func(r chi.Router) {
-			r.Post("/create", app.createCommentHandler)
-			r.Get("/{postId}", app.getCommentByPostIDHandler)
+			r.Use(app.AuthTokenMiddleware)
+			r.With(app.RequireScope(scopeCommentsWrite)).Post("/create", app.createCommentHandler)
+			r.With(app.RequireScope(scopeCommentsRead)).Get("/{postId}", app.getCommentByPostIDHandler)
+			r.With(app.RequireScope(scopeCommentsWrite)).Delete("/{commentId}", app.deleteCommentHandler)
 		})
 
 		// users
 		r.Route("/users", func(r chi.Router) {
 			r.Put("/activate/{token}", app.activateUserHandler)
+			r.Put("/email/confirm/{token}", app.confirmEmailChangeHandler)
+			r.Route("/me", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.SessionOnlyMiddleware)
+				r.With(app.RequireRecentAuth(app.config.auth.reauthMaxAge)).Delete("/", app.deleteAccountHandler)
+				r.With(app.RequireRecentAuth(app.config.auth.reauthMaxAge)).Post("/email", app.changeEmailHandler)
+				r.Put("/password", app.changePasswordHandler)
+				r.Route("/2fa", func(r chi.Router) {
+					r.Post("/enroll", app.enrollTwoFactorHandler)
+					r.Post("/confirm", app.confirmTwoFactorHandler)
+					r.With(app.RequireRecentAuth(app.config.auth.reauthMaxAge)).Post("/disable", app.disableTwoFactorHandler)
+					r.With(app.RequireRecentAuth(app.config.auth.reauthMaxAge)).Post("/recovery-codes", app.regenerateRecoveryCodesHandler)
+				})
+				r.Route("/passkeys", func(r chi.Router) {
+					r.Get("/", app.listPasskeysHandler)
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequireRecentAuth(app.config.auth.reauthMaxAge))
+						r.Post("/register/begin", app.beginPasskeyRegistrationHandler)
+						r.Post("/register/finish", app.finishPasskeyRegistrationHandler)
+					})
+					r.Delete("/{passkeyId}", app.deletePasskeyHandler)
+				})
+				r.Route("/tokens", func(r chi.Router) {
+					r.Get("/", app.listPersonalAccessTokensHandler)
+					r.With(app.RequireRecentAuth(app.config.auth.reauthMaxAge)).Post("/", app.createPersonalAccessTokenHandler)
+					r.Delete("/{tokenId}", app.revokePersonalAccessTokenHandler)
+				})
+				r.Route("/invites", func(r chi.Router) {
+					r.Get("/", app.listUserInviteCodesHandler)
+					r.Post("/", app.createUserInviteCodeHandler)
+					r.Delete("/{inviteId}", app.deleteUserInviteCodeHandler)
+				})
+				r.Route("/sessions", func(r chi.Router) {
+					r.Get("/", app.listSessionsHandler)
+					r.Delete("/{sessionId}", app.revokeSessionHandler)
+				})
+				r.Route("/identities", func(r chi.Router) {
+					r.Get("/", app.listIdentitiesHandler)
+					r.Delete("/{identityId}", app.deleteIdentityHandler)
+					r.Group(func(r chi.Router) {
+						r.Use(app.RequireRecentAuth(app.config.auth.reauthMaxAge))
+						r.Post("/{provider}", app.startOIDCLinkHandler)
+						r.Post("/{provider}/callback", app.oidcLinkCallbackHandler)
+					})
+				})
+			})
 			r.Route("/{userId}", func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
 				r.Use(app.userContextMiddleware)
-				r.Get("/", app.getUserByIDHandler)
-				r.Put("/follow", app.followUserHandler)
-				r.Put("/unfollow", app.unFollowUserHandler)
+				r.With(app.RequireScope(scopeUsersRead)).Get("/", app.getUserByIDHandler)
+				r.With(app.RequireScope(scopeFollowersWrite)).Put("/follow", app.followUserHandler)
+				r.With(app.RequireScope(scopeFollowersWrite)).Put("/unfollow", app.unFollowUserHandler)
 			})
 			r.Group(func(r chi.Router) {
-				r.Get("/feed", app.getUserFeedHandler)
+				r.Use(app.AuthTokenMiddleware)
+				r.With(app.RequireScope(scopeFeedRead)).Get("/feed", app.getUserFeedHandler)
 			})
 		})
 	})
@@ -129,6 +384,8 @@ func (app *application) run(mux http.Handler) error {
 		IdleTimeout:  time.Minute * 2,
 	}
 
+	go app.pruneExpiredRecords(context.Background(), app.config.auth.token.pruneInterval)
+
 	app.logger.Infow("Server started", "addr", app.config.addr, "env", app.config.env)
 
 	return srv.ListenAndServe()
@@ -1,12 +1,16 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/harshvse/go-api/internal/hasher"
 	"github.com/harshvse/go-api/internal/mailer"
 	"github.com/harshvse/go-api/internal/store"
 )
@@ -14,7 +18,9 @@ import (
 type RegisterUserPayload struct {
 	Username string `json:"username" validate:"required,max=100"`
 	Email    string `json:"email" validate:"required,email,max=255"`
-	Password string `json:"password" validate:"required,min=3,max=72"`
+	Password string `json:"password" validate:"required,max=256"`
+	// required while registration is invite only
+	InviteCode string `json:"invite_code" validate:"max=64"`
 }
 type UserWithToken struct {
 	*store.User
@@ -24,13 +30,14 @@ type UserWithToken struct {
 // RegisterUser godoc
 //
 //	@Summary		Register a new user
-//	@Description	Register a user with username, email and password
+//	@Description	Register a user with username, email and password. Rejected addresses are answered with a code: email_domain_denied, email_domain_not_allowed, email_disposable or email_duplicate
 //	@Tags			authentication
 //	@Accept			json
 //	@Produce		json
 //	@Param			payload	body		RegisterUserPayload	true	"User Credentials"
 //	@Success		201		{object}	UserWithToken		"User Registered"
 //	@Failure		400		{object}	error
+//	@Failure		403		{object}	error
 //	@Failure		500		{object}	error
 //	@Security		ApiKeyAuth
 //	@Router			/authentication/user [post]
@@ -47,11 +54,24 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if err := app.registrationAllowed(payload.InviteCode); err != nil {
+		app.forbiddenResponse(w, r, err)
+		return
+	}
+
+	if !app.checkEmailPolicy(w, r, payload.Email) {
+		return
+	}
+
 	user := &store.User{
 		Username: payload.Username,
 		Email:    payload.Email,
 	}
 
+	if !app.checkPasswordPolicy(w, r, "password", payload.Password, user) {
+		return
+	}
+
 	// Hash the password
 	if err := user.Password.Set(payload.Password); err != nil {
 		app.internalServerError(w, r, err)
@@ -63,17 +83,21 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	// Generate User token
 	plainToken := uuid.New().String()
 
-	hash := sha256.Sum256([]byte(plainToken))
-	hashToken := hex.EncodeToString(hash[:])
+	var inviteCode string
+	if payload.InviteCode != "" {
+		inviteCode = hashInviteCode(payload.InviteCode)
+	}
 
 	// store the user
-	err := app.store.Users.CreateAndInviteUser(ctx, user, hashToken, app.config.mail.exp)
+	err := app.store.Users.CreateAndInviteUser(ctx, user, hashToken(plainToken), app.config.mail.exp, inviteCode)
 	if err != nil {
 		switch err {
 		case store.ErrDuplicateEmail:
 			app.badRequestError(w, r, err)
 		case store.ErrDuplicateUsername:
 			app.badRequestError(w, r, err)
+		case store.ErrInvalidInviteCode:
+			app.badRequestError(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
 		}
@@ -118,9 +142,87 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+type ResendActivationPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ResendActivation godoc
+//
+//	@Summary		Resend the activation email
+//	@Description	Replaces the invitation of an account that was never activated and emails a new link
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ResendActivationPayload	true	"Account Email"
+//	@Success		202		{string}	string					"Activation email requested"
+//	@Failure		400		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/activation/resend [post]
+func (app *application) resendActivationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResendActivationPayload
+
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// limit both the address and the caller so nobody can flood an inbox
+	for _, key := range []string{"activation:ip:" + clientIP(r), "activation:email:" + strings.ToLower(payload.Email)} {
+		if allow, retryAfter := app.rateLimiter.Allow(key); !allow {
+			app.rateLimitExceededResponse(w, r, retryAfter)
+			return
+		}
+	}
+
+	// active and unknown accounts get the same answer, and as fast, as
+	// everybody else because the invitation is replaced after answering
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
+		app.resendActivation(ctx, payload.Email)
+	})
+
+	app.writeAccepted(w, r)
+}
+
+func (app *application) resendActivation(ctx context.Context, email string) {
+	user, err := app.store.Users.GetInactiveByEmail(ctx, email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		app.logger.Infow("activation email requested for an unknown or active account")
+		return
+	case err != nil:
+		app.logger.Errorw("looking up the account for a new activation email failed", "error", err.Error())
+		return
+	}
+
+	plainToken := uuid.New().String()
+	if err := app.store.Users.ReplaceInvitation(ctx, user.ID, hashToken(plainToken), app.config.mail.exp); err != nil {
+		app.logger.Errorw("replacing the invitation failed", "user_id", user.ID, "error", err.Error())
+		return
+	}
+
+	vars := struct {
+		Username      string
+		ActivationUrl string
+	}{
+		Username:      user.Username,
+		ActivationUrl: fmt.Sprintf("%s/confirm/%s", app.config.addr, plainToken),
+	}
+
+	if err := app.sendEmail(mailer.UserWelcomeTemplate, user.Username, user.Email, vars); err != nil {
+		app.logger.Errorw("resending the activation email failed", "user_id", user.ID, "error", err.Error())
+	}
+}
+
 type CreateUserTokenPayload struct {
 	Email    string `json:"email" validate:"required,email,max=255"`
-	Password string `json:"password" validate:"required,min=3,max=72"`
+	Password string `json:"password" validate:"required,min=3,max=256"`
 }
 
 // CreateTokenHandler godoc
@@ -131,9 +233,10 @@ type CreateUserTokenPayload struct {
 //	@Accept			json
 //	@Produce		json
 //	@Param			payload	body		CreateUserTokenPayload	true	"User Credentials"
-//	@Success		201		{string}	string					"Token"
+//	@Success		201		{object}	TokenPair				"Tokens"
 //	@Failure		400		{object}	error
 //	@Failure		401		{object}	error
+//	@Failure		429		{object}	error
 //	@Failure		500		{object}	error
 //	@Router			/authentication/token [post]
 func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Request) {
@@ -150,22 +253,279 @@ func (app *application) createTokenHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// slow down and lock out whoever keeps guessing
+	if !app.startLoginAttempt(w, r, payload.Email) {
+		return
+	}
+
 	// fetch the user if he exists from the credentials
 	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
 	if err != nil {
 		switch err {
 		case store.ErrNotFound:
-			//TODO make this not found but don't return to frontend
+			// don't tell the client whether the email exists, not even by
+			// answering faster than for a wrong password
+			hash, err := dummyPasswordHash()
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			hasher.Default.Verify(payload.Password, hash)
+
+			app.recordLoginFailure(r, payload.Email, nil)
+			app.unauthorizedError(w, r, store.ErrNotFound)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := user.Password.Compare(payload.Password); err != nil {
+		app.recordLoginFailure(r, payload.Email, user)
+		app.unauthorizedError(w, r, err)
+		return
+	}
+
+	// upgrade hashes of older algorithms now that we know the password
+	if user.Password.NeedsRehash() {
+		if err := app.store.Users.RehashPassword(r.Context(), user, payload.Password); err != nil {
+			app.logger.Errorw("rehashing the password failed", "user_id", user.ID, "error", err.Error())
+		}
+	}
+
+	twoFactorEnabled, err := app.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// the account keeps its count until the second factor is right, otherwise
+	// every login with the password would buy a fresh set of code guesses
+	if twoFactorEnabled {
+		app.forgiveLoginAttempt(r)
+		app.respondWithTwoFactorChallenge(w, r, user)
+		return
+	}
+
+	app.resetLoginFailures(r, payload.Email)
+	app.respondWithNewTokens(w, r, user)
+}
+
+// browser clients send the refresh token as cookie and no body
+type RefreshTokenPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"max=255"`
+}
+
+// RefreshTokenHandler godoc
+//
+//	@Summary		Refreshes the tokens
+//	@Description	Exchange a refresh token from the body or the refresh_token cookie for a new access token and a new refresh token
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		RefreshTokenPayload	false	"Refresh Token"
+//	@Success		201		{object}	TokenPair			"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/refresh [post]
+func (app *application) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RefreshTokenPayload
+
+	// the body is optional
+	if err := readJson(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	plainToken := payload.RefreshToken
+	if !app.bearerEnabled() {
+		plainToken = ""
+	}
+	fromCookie := false
+	if plainToken == "" && app.cookiesEnabled() {
+		if cookie, err := r.Cookie(refreshTokenCookie); err == nil {
+			plainToken = cookie.Value
+			fromCookie = true
+		}
+	}
+	if plainToken == "" {
+		app.badRequestError(w, r, fmt.Errorf("refresh token is required"))
+		return
+	}
+
+	ctx := r.Context()
+
+	oldToken, err := app.store.RefreshTokens.GetByToken(ctx, hashToken(plainToken))
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// a used or revoked token means it leaked, kill every token of the family
+	if oldToken.UsedAt != nil || oldToken.RevokedAt != nil {
+		app.revokeRefreshTokenFamily(w, r, oldToken.FamilyID)
+		return
+	}
+
+	if time.Now().After(oldToken.Expiry) {
+		app.unauthorizedError(w, r, fmt.Errorf("refresh token expired"))
+		return
+	}
+
+	// the family is the session, it may have been signed out from another device
+	session, err := app.store.Sessions.GetByID(ctx, oldToken.FamilyID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !session.IsActive() {
+		app.unauthorizedError(w, r, fmt.Errorf("session %s has ended", session.ID))
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, oldToken.UserID)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
 			app.unauthorizedError(w, r, err)
 		default:
 			app.internalServerError(w, r, err)
 		}
 		return
 	}
-	// generate the token -> add claims
 
-	// send it to the client
-	if err := app.jsonResponse(w, http.StatusCreated, nil); err != nil {
+	tokens, refreshToken, err := app.issueTokens(user, session)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.RefreshTokens.Rotate(ctx, oldToken.ID, refreshToken); err != nil {
+		switch err {
+		case store.ErrTokenReused:
+			// another request rotated the same token first
+			app.revokeRefreshTokenFamily(w, r, oldToken.FamilyID)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.Sessions.Extend(ctx, session.ID, refreshToken.Expiry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Sessions.Touch(ctx, session.ID, clientIP(r), r.UserAgent()); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.writeTokens(w, r, tokens, fromCookie || app.wantsCookies(r))
+}
+
+func (app *application) revokeRefreshTokenFamily(w http.ResponseWriter, r *http.Request, familyID string) {
+	app.logger.Warnw("refresh token reuse detected", "family_id", familyID)
+
+	if err := app.store.RefreshTokens.RevokeFamily(r.Context(), familyID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.unauthorizedError(w, r, store.ErrTokenReused)
+}
+
+type LogoutPayload struct {
+	RefreshToken string `json:"refresh_token" validate:"max=255"`
+}
+
+// Logout godoc
+//
+//	@Summary		Logs out the current token
+//	@Description	Revokes the access token used for the request and ends its session
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		LogoutPayload	false	"Refresh Token"
+//	@Success		204		{string}	string			"Logged out"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/authentication/logout [post]
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+	claims := getAuthClaimsFromCtx(r)
+
+	// the body is optional
+	var payload LogoutPayload
+	if err := readJson(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	expiry, err := claims.GetExpirationTime()
+	if err != nil {
+		app.unauthorizedError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	jti, _ := claims["jti"].(string)
+	if err := app.store.RevokedTokens.Revoke(ctx, jti, user.ID, expiry.Time); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// ends the session so its refresh tokens stop working as well
+	sessionID, _ := claims["sid"].(string)
+	if err := app.store.Sessions.Revoke(ctx, user.ID, sessionID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if payload.RefreshToken != "" {
+		refreshToken, err := app.store.RefreshTokens.GetByToken(ctx, hashToken(payload.RefreshToken))
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			// nothing to revoke
+		case err != nil:
+			app.internalServerError(w, r, err)
+			return
+		case refreshToken.UserID == user.ID:
+			if err := app.store.RefreshTokens.RevokeFamily(ctx, refreshToken.FamilyID); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+		}
+	}
+
+	if app.cookiesEnabled() {
+		app.clearAuthCookies(w)
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
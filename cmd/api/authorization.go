@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// makes sure the authenticated user owns the resource, or holds the permission
+// that lets them act on everyone's. An empty permission means only the owner.
+// Writes the 403 and returns false when the user may not
+func (app *application) authorizeOwner(w http.ResponseWriter, r *http.Request, resource string, ownerID int64, permission string) bool {
+	user := getAuthUserFromCtx(r)
+	if user.ID == ownerID {
+		return true
+	}
+	if permission != "" && user.Role.HasPermission(permission) {
+		return true
+	}
+
+	app.forbiddenResponse(w, r, fmt.Errorf("the %s belongs to another user", resource))
+	return false
+}
+
+// lets only the author of the post in the context through, or users with the
+// permission. Has to run after postContextMiddleware
+func (app *application) RequirePostOwnership(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			post := getPostFromCtx(r)
+			if !app.authorizeOwner(w, r, "post", post.UserID, permission) {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
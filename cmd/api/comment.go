@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -9,10 +10,8 @@ import (
 )
 
 type CommentPayload struct {
-	// UNSAFE make sure to only get userId through JWT in the furutre
-	PostID  int64  `json:"post_id"`
-	UserID  int64  `json:"user_id"`
-	Content string `json:"content"`
+	PostID  int64  `json:"post_id" validate:"required"`
+	Content string `json:"content" validate:"required,max=1000"`
 }
 
 func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Request) {
@@ -22,10 +21,16 @@ func (app *application) createCommentHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if err := Validate.Struct(commentPayload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
 	ctx := r.Context()
+	user := getAuthUserFromCtx(r)
 	comment := &store.Comment{
 		PostID:  int64(commentPayload.PostID),
-		UserID:  int64(commentPayload.UserID),
+		UserID:  user.ID,
 		Content: commentPayload.Content,
 	}
 	if err := app.store.Comments.Create(ctx, comment); err != nil {
@@ -58,3 +63,44 @@ func (app *application) getCommentByPostIDHandler(w http.ResponseWriter, r *http
 		return
 	}
 }
+
+func (app *application) deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	commentId, err := strconv.ParseInt(chi.URLParam(r, "commentId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	comment, err := app.store.Comments.GetByID(ctx, commentId)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// moderators can remove what everybody wrote
+	if !app.authorizeOwner(w, r, "comment", comment.UserID, permissionCommentsDeleteAny) {
+		return
+	}
+
+	if err := app.store.Comments.Delete(ctx, commentId); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, "Delete Successful"); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+}
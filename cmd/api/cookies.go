@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// how clients send their access token, chosen per deployment with AUTH_MODE
+const (
+	authModeBearer = "bearer"
+	authModeCookie = "cookie"
+	authModeBoth   = "both"
+)
+
+const (
+	accessTokenCookie  = "access_token"
+	refreshTokenCookie = "refresh_token"
+	csrfTokenCookie    = "csrf_token"
+	csrfTokenHeader    = "X-CSRF-Token"
+	// with AUTH_MODE=both browser clients ask for cookies with this header
+	authModeHeader = "X-Auth-Mode"
+)
+
+// the refresh cookie is only sent to the endpoints that need it
+const refreshTokenCookiePath = "/v1/authentication"
+
+var errCSRFTokenMismatch = errors.New("missing or invalid csrf token")
+
+func (app *application) bearerEnabled() bool {
+	return app.config.auth.mode != authModeCookie
+}
+
+func (app *application) cookiesEnabled() bool {
+	return app.config.auth.mode != authModeBearer
+}
+
+// whether the tokens of a login are set as cookies instead of sent in the body
+func (app *application) wantsCookies(r *http.Request) bool {
+	switch app.config.auth.mode {
+	case authModeCookie:
+		return true
+	case authModeBoth:
+		return strings.EqualFold(r.Header.Get(authModeHeader), authModeCookie)
+	default:
+		return false
+	}
+}
+
+// returns the access token of the request and whether it came from the cookie.
+// Personal access tokens are always accepted in the header since scripts
+// can't use cookies
+func (app *application) readAccessToken(r *http.Request) (string, bool, error) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return "", false, fmt.Errorf("invalid Authorization header found")
+		}
+		if !app.bearerEnabled() && !strings.HasPrefix(parts[1], personalAccessTokenPrefix) {
+			return "", false, fmt.Errorf("bearer tokens are disabled, use the session cookie")
+		}
+		return parts[1], false, nil
+	}
+
+	if app.cookiesEnabled() {
+		if cookie, err := r.Cookie(accessTokenCookie); err == nil && cookie.Value != "" {
+			return cookie.Value, true, nil
+		}
+	}
+	return "", false, fmt.Errorf("no Authorization header found")
+}
+
+func (app *application) newCookie(name, value, path string, maxAge time.Duration, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   app.config.auth.cookie.domain,
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: httpOnly,
+		Secure:   true,
+		SameSite: app.config.auth.cookie.sameSite,
+	}
+}
+
+// moves the tokens out of the response body into HttpOnly cookies and adds
+// the csrf token the client has to echo in the X-CSRF-Token header
+func (app *application) setAuthCookies(w http.ResponseWriter, tokens *TokenPair) error {
+	csrfToken, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	tokenConfig := app.config.auth.token
+	http.SetCookie(w, app.newCookie(accessTokenCookie, tokens.Token, "/", tokenConfig.exp, true))
+	http.SetCookie(w, app.newCookie(refreshTokenCookie, tokens.RefreshToken, refreshTokenCookiePath, tokenConfig.refreshExp, true))
+	// readable by scripts of the frontend when it is served from the same origin
+	http.SetCookie(w, app.newCookie(csrfTokenCookie, csrfToken, "/", tokenConfig.refreshExp, false))
+
+	tokens.Token = ""
+	tokens.RefreshToken = ""
+	tokens.CSRFToken = csrfToken
+	return nil
+}
+
+func (app *application) clearAuthCookies(w http.ResponseWriter) {
+	http.SetCookie(w, app.newCookie(accessTokenCookie, "", "/", -time.Second, true))
+	http.SetCookie(w, app.newCookie(refreshTokenCookie, "", refreshTokenCookiePath, -time.Second, true))
+	http.SetCookie(w, app.newCookie(csrfTokenCookie, "", "/", -time.Second, false))
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CSRFMiddleware makes unsafe requests that carry the auth cookies send the
+// csrf cookie again in the X-CSRF-Token header. A cross site form can make the
+// browser send the cookies but it can't read them to set the header
+func (app *application) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.cookiesEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !hasCookie(r, accessTokenCookie) && !hasCookie(r, refreshTokenCookie) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(csrfTokenCookie)
+		header := r.Header.Get(csrfTokenHeader)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			app.forbiddenResponse(w, r, errCSRFTokenMismatch)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func hasCookie(r *http.Request, name string) bool {
+	cookie, err := r.Cookie(name)
+	return err == nil && cookie.Value != ""
+}
+
+type CSRFTokenResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
+
+// GetCSRFToken godoc
+//
+//	@Summary		Get the csrf token
+//	@Description	Returns the csrf token of the cookie session so a frontend on another origin can send it after a reload, a new one is issued when there is none
+//	@Tags			authentication
+//	@Produce		json
+//	@Success		200	{object}	CSRFTokenResponse
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/authentication/csrf [get]
+func (app *application) getCSRFTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.cookiesEnabled() {
+		app.notFoundError(w, r, fmt.Errorf("cookie authentication is disabled"))
+		return
+	}
+
+	var csrfToken string
+	if cookie, err := r.Cookie(csrfTokenCookie); err == nil && cookie.Value != "" {
+		csrfToken = cookie.Value
+	} else {
+		csrfToken, err = newCSRFToken()
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		http.SetCookie(w, app.newCookie(csrfTokenCookie, csrfToken, "/", app.config.auth.token.refreshExp, false))
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, CSRFTokenResponse{CSRFToken: csrfToken}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/emailpolicy"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// the code of addresses that are another spelling of the email of an existing user
+const emailDuplicateCode = "email_duplicate"
+
+// checks the email address of a new account against the domain rules, the
+// disposable domains and the addresses of existing users. On false the
+// response has been written
+func (app *application) checkEmailPolicy(w http.ResponseWriter, r *http.Request, email string) bool {
+	return app.checkChangedEmailPolicy(w, r, email, "")
+}
+
+// like checkEmailPolicy for an account changing its address from currentEmail,
+// another spelling of the current address isn't a duplicate
+func (app *application) checkChangedEmailPolicy(w http.ResponseWriter, r *http.Request, email, currentEmail string) bool {
+	violation, err := app.emailPolicyViolation(r.Context(), email, currentEmail)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return false
+	}
+	if violation != nil {
+		app.emailRejectedResponse(w, r, violation.Code, violation)
+		return false
+	}
+	return true
+}
+
+// returns why the email address can't be used, nil when it can. currentEmail
+// is the address of the account that changes it, empty for new accounts
+func (app *application) emailPolicyViolation(ctx context.Context, email, currentEmail string) (*emailpolicy.Violation, error) {
+	domainRules, err := app.store.EmailDomainRules.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]emailpolicy.Rule, 0, len(domainRules))
+	for _, rule := range domainRules {
+		rules = append(rules, emailpolicy.Rule{Domain: strings.ToLower(rule.Domain), Action: rule.Action})
+	}
+
+	if violation := app.emailPolicy.Check(email, rules); violation != nil {
+		return violation, nil
+	}
+
+	normalized := emailpolicy.Normalize(email)
+	if currentEmail != "" && normalized == emailpolicy.Normalize(currentEmail) {
+		return nil, nil
+	}
+
+	exists, err := app.store.Users.ExistsByNormalizedEmail(ctx, normalized)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return &emailpolicy.Violation{Code: emailDuplicateCode, Message: store.ErrDuplicateEmail.Error()}, nil
+	}
+	return nil, nil
+}
+
+type CreateEmailDomainRulePayload struct {
+	Domain string `json:"domain" validate:"required,max=253,hostname_rfc1123"`
+	Action string `json:"action" validate:"required,oneof=allow deny"`
+	Note   string `json:"note" validate:"max=255"`
+}
+
+// ListEmailDomainRules godoc
+//
+//	@Summary		List email domain rules
+//	@Description	Lists the domains registration is allowed or denied for, once any domain is allowed only allowed domains can register
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.EmailDomainRule
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/email-domains [get]
+func (app *application) listEmailDomainRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.store.EmailDomainRules.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, rules); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateEmailDomainRule godoc
+//
+//	@Summary		Create an email domain rule
+//	@Description	Allows or denies registering with addresses at the domain and its subdomains, the rule of the most specific domain wins
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateEmailDomainRulePayload	true	"Domain, allow or deny and a note"
+//	@Success		201		{object}	store.EmailDomainRule
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/email-domains [post]
+func (app *application) createEmailDomainRuleHandler(w http.ResponseWriter, r *http.Request) {
+	admin := getAuthAdminFromCtx(r)
+
+	var payload CreateEmailDomainRulePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+	payload.Domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(payload.Domain)), ".")
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	rule := &store.EmailDomainRule{
+		Domain:           payload.Domain,
+		Action:           payload.Action,
+		Note:             payload.Note,
+		CreatedByAdminID: &admin.ID,
+	}
+	if err := app.store.EmailDomainRules.Create(r.Context(), rule); err != nil {
+		switch {
+		case errors.Is(err, store.ErrDuplicateDomainRule):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, rule); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteEmailDomainRule godoc
+//
+//	@Summary		Delete an email domain rule
+//	@Description	Removes the rule, the domain falls back to the rule of its parent domain
+//	@Tags			admin
+//	@Produce		json
+//	@Param			ruleId	path		int		true	"Email Domain Rule ID"
+//	@Success		204		{string}	string	"Rule deleted"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/email-domains/{ruleId} [delete]
+func (app *application) deleteEmailDomainRuleHandler(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := strconv.ParseInt(chi.URLParam(r, "ruleId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.EmailDomainRules.Delete(r.Context(), ruleID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ReloadDisposableDomains godoc
+//
+//	@Summary		Reload the disposable domains
+//	@Description	Replaces the disposable domain list with the contents of REGISTRATION_DISPOSABLE_DOMAINS_FILE, for picking up a refreshed copy without a restart
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	map[string]int
+//	@Failure		400	{object}	error
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/email-domains/disposable/reload [post]
+func (app *application) reloadDisposableDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	path := app.config.registration.disposableDomainsFile
+	if path == "" {
+		app.badRequestError(w, r, fmt.Errorf("no disposable domains file is configured, the bundled list is in use"))
+		return
+	}
+
+	count, err := app.emailPolicy.Disposable.Load(path)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.logger.Infow("disposable domains reloaded", "file", path, "domains", count)
+
+	if err := app.jsonResponse(w, http.StatusOK, map[string]int{"domains": count}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/harshvse/go-api/internal/passwordpolicy"
 )
 
 func (app *application) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
@@ -16,6 +20,40 @@ func (app *application) badRequestError(w http.ResponseWriter, r *http.Request,
 	writeJsonError(w, http.StatusBadRequest, err.Error())
 }
 
+// answers with 400 and the reasons every rejected field of the payload failed for
+func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, fields map[string][]string) {
+	app.logger.Warnw("failed validation", "method", r.Method, "path", r.URL.Path, "fields", fields)
+
+	type envelop struct {
+		Error  string              `json:"error"`
+		Fields map[string][]string `json:"fields"`
+	}
+	writeJson(w, http.StatusBadRequest, &envelop{Error: "validation failed", Fields: fields})
+}
+
+// answers like failedValidationResponse for the password in field and adds the
+// codes of the violations, so clients can tell them apart without the messages
+func (app *application) passwordRejectedResponse(w http.ResponseWriter, r *http.Request, field string, violations []passwordpolicy.Violation) {
+	reasons := make([]string, 0, len(violations))
+	codes := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		reasons = append(reasons, violation.Message)
+		codes = append(codes, violation.Code)
+	}
+	app.logger.Warnw("password rejected", "method", r.Method, "path", r.URL.Path, "field", field, "codes", codes)
+
+	type envelop struct {
+		Error  string              `json:"error"`
+		Fields map[string][]string `json:"fields"`
+		Codes  map[string][]string `json:"codes"`
+	}
+	writeJson(w, http.StatusBadRequest, &envelop{
+		Error:  "validation failed",
+		Fields: map[string][]string{field: reasons},
+		Codes:  map[string][]string{field: codes},
+	})
+}
+
 func (app *application) notFoundError(w http.ResponseWriter, r *http.Request, err error) {
 	app.logger.Errorw("not found", "method", r.Method, "path", r.URL.Path, "error", err.Error())
 
@@ -35,3 +73,54 @@ func (app *application) unauthorizedBasicError(w http.ResponseWriter, r *http.Re
 
 	writeJsonError(w, http.StatusUnauthorized, "you are not authorized!!")
 }
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("forbidden", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeJsonError(w, http.StatusForbidden, err.Error())
+}
+
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logger.Warnw("conflict", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+
+	writeJsonError(w, http.StatusConflict, err.Error())
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	app.logger.Warnw("rate limit exceeded", "method", r.Method, "path", r.URL.Path)
+
+	w.Header().Set("Retry-After", fmt.Sprintf("%.f", retryAfter.Seconds()))
+
+	writeJsonError(w, http.StatusTooManyRequests, "rate limit exceeded, retry after: "+retryAfter.String())
+}
+
+// answers with 401 and a code the frontend can tell apart from an expired
+// session, it has to send the user through /authentication/reauth and retry
+func (app *application) reauthenticationRequiredResponse(w http.ResponseWriter, r *http.Request, maxAge time.Duration) {
+	app.logger.Warnw("reauthentication required", "method", r.Method, "path", r.URL.Path)
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_user_authentication", max_age=%.f`, maxAge.Seconds()))
+
+	type envelop struct {
+		Error  string `json:"error"`
+		Code   string `json:"code"`
+		MaxAge int64  `json:"max_age"`
+	}
+	writeJson(w, http.StatusUnauthorized, &envelop{
+		Error:  "please confirm your password to continue",
+		Code:   "reauthentication_required",
+		MaxAge: int64(maxAge.Seconds()),
+	})
+}
+
+// answers with 400 and a code telling the frontend why the email address
+// can't be used
+func (app *application) emailRejectedResponse(w http.ResponseWriter, r *http.Request, code string, err error) {
+	app.logger.Warnw("email rejected", "method", r.Method, "path", r.URL.Path, "code", code, "error", err.Error())
+
+	type envelop struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	writeJson(w, http.StatusBadRequest, &envelop{Error: err.Error(), Code: code})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshvse/go-api/internal/emailpolicy"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// in memory stand-ins for the stores the handler tests touch. They embed the
+// postgres stores so a method a test didn't expect panics on the nil db
+
+type fakeUserStore struct {
+	*store.UserStore
+
+	sync.Mutex
+	users      map[int64]*store.User
+	identities *fakeIdentityStore
+}
+
+func newFakeUserStore(identities *fakeIdentityStore) *fakeUserStore {
+	return &fakeUserStore{users: make(map[int64]*store.User), identities: identities}
+}
+
+// adds a user the way registration and activation would have left it
+func (s *fakeUserStore) add(user *store.User) *store.User {
+	s.Lock()
+	defer s.Unlock()
+	user.ID = int64(len(s.users) + 1)
+	s.users[user.ID] = user
+	return user
+}
+
+func (s *fakeUserStore) find(match func(*store.User) bool) (*store.User, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, user := range s.users {
+		if match(user) {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *fakeUserStore) GetByID(ctx context.Context, id int64) (*store.User, error) {
+	return s.find(func(u *store.User) bool { return u.ID == id })
+}
+
+func (s *fakeUserStore) GetByEmail(ctx context.Context, email string) (*store.User, error) {
+	return s.find(func(u *store.User) bool { return u.IsActive && strings.EqualFold(u.Email, email) })
+}
+
+func (s *fakeUserStore) GetInactiveByEmail(ctx context.Context, email string) (*store.User, error) {
+	return s.find(func(u *store.User) bool {
+		return !u.IsActive && u.DeactivatedAt == nil && strings.EqualFold(u.Email, email)
+	})
+}
+
+func (s *fakeUserStore) ExistsByNormalizedEmail(ctx context.Context, normalized string) (bool, error) {
+	_, err := s.find(func(u *store.User) bool { return emailpolicy.Normalize(u.Email) == normalized })
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *fakeUserStore) CreateWithIdentity(ctx context.Context, user *store.User, identity *store.Identity) error {
+	if _, err := s.find(func(u *store.User) bool { return strings.EqualFold(u.Username, user.Username) }); err == nil {
+		return store.ErrDuplicateUsername
+	}
+	if _, err := s.find(func(u *store.User) bool { return strings.EqualFold(u.Email, user.Email) }); err == nil {
+		return store.ErrDuplicateEmail
+	}
+
+	user.IsActive = true
+	created := *user
+	s.add(&created)
+	user.ID = created.ID
+
+	identity.UserID = user.ID
+	return s.identities.Create(ctx, identity)
+}
+
+func (s *fakeUserStore) LinkIdentityAndActivate(ctx context.Context, user *store.User, identity *store.Identity) error {
+	s.Lock()
+	s.users[user.ID].IsActive = true
+	s.Unlock()
+	user.IsActive = true
+
+	identity.UserID = user.ID
+	return s.identities.Create(ctx, identity)
+}
+
+type fakeIdentityStore struct {
+	*store.IdentityStore
+
+	sync.Mutex
+	states     map[string]store.OAuthState
+	identities []*store.Identity
+}
+
+func newFakeIdentityStore() *fakeIdentityStore {
+	return &fakeIdentityStore{states: make(map[string]store.OAuthState)}
+}
+
+func (s *fakeIdentityStore) CreateState(ctx context.Context, state string, oauthState *store.OAuthState, exp time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+	s.states[state] = *oauthState
+	return nil
+}
+
+func (s *fakeIdentityStore) ConsumeState(ctx context.Context, state, provider string) (*store.OAuthState, error) {
+	s.Lock()
+	defer s.Unlock()
+	oauthState, ok := s.states[state]
+	if !ok || oauthState.Provider != provider {
+		return nil, store.ErrNotFound
+	}
+	delete(s.states, state)
+	return &oauthState, nil
+}
+
+func (s *fakeIdentityStore) GetBySubject(ctx context.Context, provider, subject string) (*store.Identity, error) {
+	s.Lock()
+	defer s.Unlock()
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return identity, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *fakeIdentityStore) Create(ctx context.Context, identity *store.Identity) error {
+	if _, err := s.GetBySubject(ctx, identity.Provider, identity.Subject); err == nil {
+		return store.ErrConflict
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	identity.ID = int64(len(s.identities) + 1)
+	s.identities = append(s.identities, identity)
+	return nil
+}
+
+type fakeEmailDomainRuleStore struct {
+	*store.EmailDomainRuleStore
+	rules []*store.EmailDomainRule
+}
+
+func (s *fakeEmailDomainRuleStore) List(ctx context.Context) ([]*store.EmailDomainRule, error) {
+	return s.rules, nil
+}
+
+type fakeSessionStore struct {
+	*store.SessionStore
+}
+
+func (s *fakeSessionStore) Create(ctx context.Context, session *store.Session, refreshToken *store.RefreshToken) error {
+	return nil
+}
+
+type fakeServiceClientStore struct {
+	*store.ServiceClientStore
+
+	sync.Mutex
+	clients []*store.ServiceClient
+	nonces  map[string]time.Time
+}
+
+func (s *fakeServiceClientStore) GetByKeyID(ctx context.Context, keyID string) (*store.ServiceClient, error) {
+	for _, client := range s.clients {
+		if client.KeyID == keyID && client.RevokedAt == nil {
+			return client, nil
+		}
+	}
+	return nil, store.ErrNotFound
+}
+
+func (s *fakeServiceClientStore) UseNonce(ctx context.Context, clientID int64, nonce string, expiry time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+
+	key := fmt.Sprintf("%d:%s", clientID, nonce)
+	if _, ok := s.nonces[key]; ok {
+		return store.ErrNonceReused
+	}
+	s.nonces[key] = expiry
+	return nil
+}
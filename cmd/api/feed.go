@@ -17,15 +17,19 @@ func (app *application) getUserFeedHandler(w http.ResponseWriter, r *http.Reques
 	fq, err := fq.Parse(r)
 	if err != nil {
 		app.badRequestError(w, r, err)
+		return
 	}
 
 	if err := Validate.Struct(fq); err != nil {
 		app.badRequestError(w, r, err)
+		return
 	}
 	ctx := r.Context()
-	feed, err := app.store.Posts.GetUserFeed(ctx, int64(3), fq)
+	user := getAuthUserFromCtx(r)
+	feed, err := app.store.Posts.GetUserFeed(ctx, user.ID, fq)
 	if err != nil {
 		app.internalServerError(w, r, err)
+		return
 	}
 	if err := app.jsonResponse(w, http.StatusOK, feed); err != nil {
 		app.internalServerError(w, r, err)
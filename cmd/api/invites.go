@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// who can create an account, chosen per deployment with REGISTRATION_MODE
+const (
+	registrationModeOpen       = "open"
+	registrationModeInviteOnly = "invite-only"
+	registrationModeClosed     = "closed"
+)
+
+// codes are upper case base32 so they can be read out and typed in
+func newInviteCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+}
+
+// codes are compared by hash, so users typing them in lower case still match
+func hashInviteCode(code string) string {
+	return hashToken(strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// checks that the registration mode lets a new account be created, inviteCode
+// is what the new user sent
+func (app *application) registrationAllowed(inviteCode string) error {
+	switch app.config.registration.mode {
+	case registrationModeClosed:
+		return errors.New("registration is closed")
+	case registrationModeInviteOnly:
+		if inviteCode == "" {
+			return errors.New("registration requires an invite code")
+		}
+	}
+	return nil
+}
+
+type CreateInviteCodePayload struct {
+	MaxUses       int `json:"max_uses" validate:"omitempty,min=1,max=10000"`
+	ExpiresInDays int `json:"expires_in_days" validate:"omitempty,min=1,max=365"`
+}
+
+type InviteCodeWithCode struct {
+	*store.InviteCode
+	Code string `json:"code"`
+}
+
+// stores a new code with create and sends it, the code is only shown in this response
+func (app *application) createInviteCode(w http.ResponseWriter, r *http.Request, invite *store.InviteCode, payload CreateInviteCodePayload, create func(context.Context, *store.InviteCode) error) {
+	plainCode, err := newInviteCode()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	invite.Code = hashInviteCode(plainCode)
+	invite.MaxUses = max(payload.MaxUses, 1)
+	if payload.ExpiresInDays > 0 {
+		expiry := time.Now().Add(time.Hour * 24 * time.Duration(payload.ExpiresInDays))
+		invite.Expiry = &expiry
+	}
+
+	if err := create(r.Context(), invite); err != nil {
+		switch {
+		case errors.Is(err, store.ErrTooManyInviteCodes):
+			app.conflictResponse(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	response := InviteCodeWithCode{
+		InviteCode: invite,
+		Code:       plainCode,
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, response); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateInviteCode godoc
+//
+//	@Summary		Create an invite code
+//	@Description	Creates a code others can register with. The server limits the uses and lifetime of a code and how many usable codes a user can have
+//	@Tags			invites
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateInviteCodePayload	true	"Uses and expiry"
+//	@Success		201		{object}	InviteCodeWithCode
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/invites [post]
+func (app *application) createUserInviteCodeHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	var payload CreateInviteCodePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if limit := app.config.registration.userInviteMaxUses; payload.MaxUses > limit {
+		app.badRequestError(w, r, fmt.Errorf("an invite code can be used at most %d times", limit))
+		return
+	}
+
+	// codes of users always expire, by default after the longest time allowed
+	maxDays := max(int(app.config.registration.userInviteExp/(time.Hour*24)), 1)
+	if payload.ExpiresInDays > maxDays {
+		app.badRequestError(w, r, fmt.Errorf("an invite code can be valid for at most %d days", maxDays))
+		return
+	}
+	if payload.ExpiresInDays == 0 {
+		payload.ExpiresInDays = maxDays
+	}
+
+	invite := &store.InviteCode{CreatedByUserID: &user.ID}
+	app.createInviteCode(w, r, invite, payload, func(ctx context.Context, invite *store.InviteCode) error {
+		return app.store.InviteCodes.CreateForUser(ctx, invite, app.config.registration.userInviteMaxActive)
+	})
+}
+
+// ListInviteCodes godoc
+//
+//	@Summary		List invite codes
+//	@Description	Lists the invite codes of the current user with their uses
+//	@Tags			invites
+//	@Produce		json
+//	@Success		200	{array}		store.InviteCode
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/invites [get]
+func (app *application) listUserInviteCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	codes, err := app.store.InviteCodes.ListByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, codes); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteInviteCode godoc
+//
+//	@Summary		Delete an invite code
+//	@Description	Deletes an invite code of the current user, people who already registered with it keep their referral
+//	@Tags			invites
+//	@Produce		json
+//	@Param			inviteId	path		int		true	"Invite code ID"
+//	@Success		204			{string}	string	"Invite code deleted"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/invites/{inviteId} [delete]
+func (app *application) deleteUserInviteCodeHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	inviteID, err := strconv.ParseInt(chi.URLParam(r, "inviteId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	app.deleteInviteCode(w, r, app.store.InviteCodes.DeleteForUser(r.Context(), user.ID, inviteID))
+}
+
+func (app *application) deleteInviteCode(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateAdminInviteCode godoc
+//
+//	@Summary		Create an invite code as admin
+//	@Description	Creates an invite code that is not tied to a user, people registering with it have no referrer
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateInviteCodePayload	true	"Uses and expiry"
+//	@Success		201		{object}	InviteCodeWithCode
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/invites [post]
+func (app *application) createAdminInviteCodeHandler(w http.ResponseWriter, r *http.Request) {
+	admin := getAuthAdminFromCtx(r)
+
+	var payload CreateInviteCodePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	invite := &store.InviteCode{CreatedByAdminID: &admin.ID}
+	app.createInviteCode(w, r, invite, payload, app.store.InviteCodes.Create)
+}
+
+// ListAllInviteCodes godoc
+//
+//	@Summary		List all invite codes
+//	@Description	Lists the invite codes of every user and admin with their uses
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.InviteCode
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/invites [get]
+func (app *application) listAllInviteCodesHandler(w http.ResponseWriter, r *http.Request) {
+	codes, err := app.store.InviteCodes.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, codes); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteAnyInviteCode godoc
+//
+//	@Summary		Delete any invite code
+//	@Description	Deletes an invite code of any user or admin
+//	@Tags			admin
+//	@Produce		json
+//	@Param			inviteId	path		int		true	"Invite code ID"
+//	@Success		204			{string}	string	"Invite code deleted"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/invites/{inviteId} [delete]
+func (app *application) deleteAnyInviteCodeHandler(w http.ResponseWriter, r *http.Request) {
+	inviteID, err := strconv.ParseInt(chi.URLParam(r, "inviteId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	app.deleteInviteCode(w, r, app.store.InviteCodes.Delete(r.Context(), inviteID))
+}
+
+type ReferralReport struct {
+	// the user first, then who referred them and so on
+	Chain []*store.Referral `json:"chain"`
+	// everyone the user brought in directly or through others
+	Referrals []*store.Referral `json:"referrals"`
+}
+
+// GetReferralReport godoc
+//
+//	@Summary		Report the referrals of a user
+//	@Description	Returns the chain of users that led to the user and everyone the user referred
+//	@Tags			admin
+//	@Produce		json
+//	@Param			userId	path		int	true	"User ID"
+//	@Success		200		{object}	ReferralReport
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/users/{userId}/referrals [get]
+func (app *application) getReferralReportHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	chain, err := app.store.InviteCodes.ReferralChain(ctx, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if len(chain) == 0 {
+		app.notFoundError(w, r, store.ErrNotFound)
+		return
+	}
+
+	referrals, err := app.store.InviteCodes.Referrals(ctx, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	report := ReferralReport{Chain: chain, Referrals: referrals}
+	if err := app.jsonResponse(w, http.StatusOK, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
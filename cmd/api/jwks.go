@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+)
+
+// JWKS godoc
+//
+//	@Summary		Public token signing keys
+//	@Description	Publishes the public keys used to sign access tokens as a JSON Web Key Set
+//	@Tags			authentication
+//	@Produce		json
+//	@Success		200	{object}	auth.JSONWebKeySet
+//	@Router			/.well-known/jwks.json [get]
+func (app *application) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	// verifiers cache the set, keys are published before they start signing
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if err := writeJson(w, http.StatusOK, app.authenticator.JWKS()); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/harshvse/go-api/internal/lockout"
+	"github.com/harshvse/go-api/internal/mailer"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+func loginAccountKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func loginIPKey(r *http.Request) string {
+	return "ip:" + clientIP(r)
+}
+
+func adminLoginKey(username string) string {
+	return "admin:" + strings.ToLower(username)
+}
+
+// counts a login attempt for the account and the address before the
+// credentials are checked. Answers with 429 and returns false while either has
+// to wait because of earlier failures, the attempt isn't counted then
+func (app *application) startLoginAttempt(w http.ResponseWriter, r *http.Request, email string) bool {
+	return app.startAttempt(w, r, loginAccountKey(email), app.config.lockout.account)
+}
+
+// like startLoginAttempt for the basic auth of the admin api, keyed on the username
+func (app *application) startAdminLoginAttempt(w http.ResponseWriter, r *http.Request, username string) bool {
+	return app.startAttempt(w, r, adminLoginKey(username), app.config.lockout.admin)
+}
+
+func (app *application) startAttempt(w http.ResponseWriter, r *http.Request, key string, policy lockout.Policy) bool {
+	ctx := r.Context()
+
+	ipWait, err := app.loginGuard.Attempt(ctx, loginIPKey(r), app.config.lockout.ip)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return false
+	}
+	if ipWait > 0 {
+		app.rateLimitExceededResponse(w, r, ipWait)
+		return false
+	}
+
+	keyWait, keyErr := app.loginGuard.Attempt(ctx, key, policy)
+	if keyErr == nil && keyWait == 0 {
+		return true
+	}
+
+	// the address didn't get to try either
+	if err := app.loginGuard.Forgive(ctx, loginIPKey(r)); err != nil {
+		app.logger.Errorw("taking back the login attempt of an address failed", "error", err.Error())
+	}
+	if keyErr != nil {
+		app.internalServerError(w, r, keyErr)
+		return false
+	}
+	app.rateLimitExceededResponse(w, r, keyWait)
+	return false
+}
+
+// marks the attempt of the account and the address as failed, user is nil when
+// nobody has the email. The owner is told when their account gets locked
+func (app *application) recordLoginFailure(r *http.Request, email string, user *store.User) {
+	ctx := r.Context()
+
+	if _, err := app.loginGuard.Fail(ctx, loginIPKey(r), app.config.lockout.ip); err != nil {
+		app.logger.Errorw("recording the failed login of an address failed", "error", err.Error())
+	}
+
+	locked, err := app.loginGuard.Fail(ctx, loginAccountKey(email), app.config.lockout.account)
+	if err != nil {
+		app.logger.Errorw("recording the failed login of an account failed", "error", err.Error())
+		return
+	}
+	if !locked || user == nil {
+		return
+	}
+
+	app.logger.Warnw("account locked after failed logins", "user_id", user.ID)
+
+	vars := struct {
+		Username          string
+		Expiry            string
+		ForgotPasswordUrl string
+	}{
+		Username:          user.Username,
+		Expiry:            app.config.lockout.account.LockoutDuration.String(),
+		ForgotPasswordUrl: fmt.Sprintf("%s/forgot-password", app.config.frontendURL),
+	}
+	if err := app.sendEmail(mailer.AccountLockedTemplate, user.Username, user.Email, vars); err != nil {
+		app.logger.Errorw("sending the account locked email failed", "user_id", user.ID, "error", err.Error())
+	}
+}
+
+// forgets the failures of the account after a successful login, the address
+// only gets the attempt back
+func (app *application) resetLoginFailures(r *http.Request, email string) {
+	app.resetFailures(r, loginAccountKey(email))
+}
+
+// marks the attempt of the admin and the address as failed
+func (app *application) recordAdminLoginFailure(r *http.Request, username string) {
+	ctx := r.Context()
+
+	if _, err := app.loginGuard.Fail(ctx, loginIPKey(r), app.config.lockout.ip); err != nil {
+		app.logger.Errorw("recording the failed login of an address failed", "error", err.Error())
+	}
+
+	locked, err := app.loginGuard.Fail(ctx, adminLoginKey(username), app.config.lockout.admin)
+	if err != nil {
+		app.logger.Errorw("recording the failed login of an admin failed", "error", err.Error())
+		return
+	}
+	if locked {
+		app.logger.Warnw("admin locked after failed logins", "admin", username)
+	}
+}
+
+func (app *application) resetAdminLoginFailures(r *http.Request, username string) {
+	app.resetFailures(r, adminLoginKey(username))
+}
+
+func (app *application) resetFailures(r *http.Request, key string) {
+	if err := app.loginGuard.Reset(r.Context(), key); err != nil {
+		app.logger.Errorw("resetting failed logins failed", "error", err.Error())
+	}
+	app.forgiveLoginAttempt(r)
+}
+
+// gives the address its attempt back but keeps the count of the account, for a
+// password that was right when the login still needs the second factor
+func (app *application) forgiveLoginAttempt(r *http.Request) {
+	if err := app.loginGuard.Forgive(r.Context(), loginIPKey(r)); err != nil {
+		app.logger.Errorw("taking back the login attempt of an address failed", "error", err.Error())
+	}
+}
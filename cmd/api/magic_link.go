@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/harshvse/go-api/internal/mailer"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+type MagicLinkPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+type ExchangeMagicLinkPayload struct {
+	Token string `json:"token" validate:"required,max=2048"`
+}
+
+// RequestMagicLink godoc
+//
+//	@Summary		Request a login link
+//	@Description	Emails a single use login link if an active account exists for the address
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		MagicLinkPayload	true	"Account Email"
+//	@Success		202		{string}	string				"Link requested"
+//	@Failure		400		{object}	error
+//	@Failure		429		{object}	error
+//	@Router			/authentication/magic-link [post]
+func (app *application) requestMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MagicLinkPayload
+
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// limit both the address and the caller so nobody can flood an inbox
+	for _, key := range []string{"magic-link:ip:" + clientIP(r), "magic-link:email:" + strings.ToLower(payload.Email)} {
+		if allow, retryAfter := app.rateLimiter.Allow(key); !allow {
+			app.rateLimitExceededResponse(w, r, retryAfter)
+			return
+		}
+	}
+
+	// the link is created and sent after answering, the response is the same
+	// and takes as long whether the account exists or not so it can't be used
+	// to find out which emails are registered
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
+		app.sendMagicLink(ctx, payload.Email)
+	})
+
+	app.writeAccepted(w, r)
+}
+
+func (app *application) sendMagicLink(ctx context.Context, email string) {
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		app.logger.Infow("magic link requested for unknown email")
+		return
+	case err != nil:
+		app.logger.Errorw("looking up the account for a magic link failed", "error", err.Error())
+		return
+	}
+
+	jti := uuid.New().String()
+	if err := app.store.Users.CreateMagicLink(ctx, user.ID, jti, app.config.mail.magicLinkExp); err != nil {
+		app.logger.Errorw("creating the magic link failed", "user_id", user.ID, "error", err.Error())
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":       strconv.FormatInt(user.ID, 10),
+		"jti":       jti,
+		"token_use": magicLinkTokenUse,
+		"exp":       now.Add(app.config.mail.magicLinkExp).Unix(),
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"iss":       app.config.auth.token.iss,
+		"aud":       app.config.auth.token.iss,
+	}
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		app.logger.Errorw("signing the magic link failed", "user_id", user.ID, "error", err.Error())
+		return
+	}
+
+	vars := struct {
+		Username string
+		LoginUrl string
+		Expiry   string
+	}{
+		Username: user.Username,
+		LoginUrl: fmt.Sprintf("%s/magic-link/%s", app.config.frontendURL, token),
+		Expiry:   app.config.mail.magicLinkExp.String(),
+	}
+
+	if err := app.sendEmail(mailer.MagicLinkTemplate, user.Username, user.Email, vars); err != nil {
+		app.logger.Errorw("sending the magic link email failed", "user_id", user.ID, "error", err.Error())
+	}
+}
+
+// ExchangeMagicLink godoc
+//
+//	@Summary		Log in with a login link
+//	@Description	Exchanges the token of an emailed login link for the same tokens as the password login, every link works once
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ExchangeMagicLinkPayload	true	"Link token"
+//	@Success		200		{object}	TwoFactorChallenge			"Second factor required"
+//	@Success		201		{object}	TokenPair					"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/magic-link/exchange [post]
+func (app *application) exchangeMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ExchangeMagicLinkPayload
+
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	jwtToken, err := app.authenticator.VerifyToken(payload.Token)
+	if err != nil {
+		app.unauthorizedError(w, r, err)
+		return
+	}
+
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok {
+		app.unauthorizedError(w, r, fmt.Errorf("invalid token claims"))
+		return
+	}
+
+	if tokenUse, _ := claims["token_use"].(string); tokenUse != magicLinkTokenUse {
+		app.unauthorizedError(w, r, fmt.Errorf("token is not a magic link token"))
+		return
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil {
+		app.unauthorizedError(w, r, err)
+		return
+	}
+	userID, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		app.unauthorizedError(w, r, err)
+		return
+	}
+
+	// the signature proves we issued the link, the stored jti that it is unused
+	ctx := r.Context()
+	jti, _ := claims["jti"].(string)
+	if err := app.store.Users.ConsumeMagicLink(ctx, userID, jti); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, fmt.Errorf("magic link was already used or replaced"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// links sent before the sessions were revoked die with them
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		app.unauthorizedError(w, r, fmt.Errorf("token has no valid iat"))
+		return
+	}
+	if user.TokensValidAfter != nil && issuedAt.Time.Before(user.TokensValidAfter.Truncate(time.Second)) {
+		app.unauthorizedError(w, r, fmt.Errorf("magic link was issued before the sessions of user %d were revoked", user.ID))
+		return
+	}
+
+	app.respondWithLogin(w, r, user)
+}
@@ -0,0 +1,31 @@
+package main
+
+import "fmt"
+
+// runs fn in its own goroutine so slow work like sending email doesn't hold
+// up the response, panics are logged instead of taking down the server
+func (app *application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Errorw("background task panicked", "error", fmt.Sprint(err))
+			}
+		}()
+		fn()
+	}()
+}
+
+// sends a templated email, retrying up to the configured number of times
+func (app *application) sendEmail(templateFile, username, email string, data any) error {
+	isProdEnv := app.config.env == "production"
+
+	var err error
+	for i := 0; i < app.config.mail.maxRetries; i++ {
+		_, err = app.mailer.Send(templateFile, username, email, data, !isProdEnv)
+		if err == nil {
+			return nil
+		}
+		app.logger.Warnw("sending email failed", "template", templateFile, "attempt", i+1, "error", err.Error())
+	}
+	return err
+}
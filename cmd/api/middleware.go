@@ -1,12 +1,152 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harshvse/go-api/internal/store"
 )
 
+type authKey string
+
+const (
+	authClaimsCtx      authKey = "authClaims"
+	authAccessTokenCtx authKey = "authAccessToken"
+	authAdminCtx       authKey = "authAdmin"
+	// the service client of a signed request
+	authServiceClientCtx authKey = "authServiceClient"
+	// the identity provider calling the scim endpoints
+	authProvisioningTokenCtx authKey = "authProvisioningToken"
+)
+
+func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// read the auth header or the cookie
+		token, fromCookie, err := app.readAccessToken(r)
+		if err != nil {
+			app.unauthorizedError(w, r, err)
+			return
+		}
+
+		if !fromCookie && strings.HasPrefix(token, personalAccessTokenPrefix) {
+			app.authenticatePersonalAccessToken(w, r, next, token)
+			return
+		}
+
+		// verify the signature and the registered claims
+		jwtToken, err := app.authenticator.VerifyToken(token)
+		if err != nil {
+			app.unauthorizedError(w, r, err)
+			return
+		}
+
+		claims, ok := jwtToken.Claims.(jwt.MapClaims)
+		if !ok {
+			app.unauthorizedError(w, r, fmt.Errorf("invalid token claims"))
+			return
+		}
+
+		subject, err := claims.GetSubject()
+		if err != nil {
+			app.unauthorizedError(w, r, err)
+			return
+		}
+
+		userID, err := strconv.ParseInt(subject, 10, 64)
+		if err != nil {
+			app.unauthorizedError(w, r, err)
+			return
+		}
+
+		if tokenUse, _ := claims["token_use"].(string); tokenUse != accessTokenUse {
+			app.unauthorizedError(w, r, fmt.Errorf("token is not an access token"))
+			return
+		}
+
+		jti, _ := claims["jti"].(string)
+		if jti == "" {
+			app.unauthorizedError(w, r, fmt.Errorf("token has no jti"))
+			return
+		}
+
+		issuedAt, err := claims.GetIssuedAt()
+		if err != nil || issuedAt == nil {
+			app.unauthorizedError(w, r, fmt.Errorf("token has no valid iat"))
+			return
+		}
+
+		sessionID, _ := claims["sid"].(string)
+		if sessionID == "" {
+			app.unauthorizedError(w, r, fmt.Errorf("token has no sid"))
+			return
+		}
+
+		// reject tokens that were logged out
+		ctx := r.Context()
+		revoked, err := app.store.RevokedTokens.IsRevoked(ctx, jti)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if revoked {
+			app.unauthorizedError(w, r, fmt.Errorf("token %s has been revoked", jti))
+			return
+		}
+
+		// load the user the token was issued for
+		user, err := app.store.Users.GetByID(ctx, userID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedError(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		// iat only has second precision so compare whole seconds
+		if user.TokensValidAfter != nil && issuedAt.Time.Before(user.TokensValidAfter.Truncate(time.Second)) {
+			app.unauthorizedError(w, r, fmt.Errorf("token was issued before the sessions of user %d were revoked", user.ID))
+			return
+		}
+
+		// reject tokens of sessions that were signed out
+		session, err := app.store.Sessions.GetByID(ctx, sessionID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedError(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+		if session.UserID != user.ID || !session.IsActive() {
+			app.unauthorizedError(w, r, fmt.Errorf("session %s has ended", sessionID))
+			return
+		}
+
+		if err := app.store.Sessions.Touch(ctx, sessionID, clientIP(r), r.UserAgent()); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		ctx = context.WithValue(ctx, authUserCtx, user)
+		ctx = context.WithValue(ctx, authClaimsCtx, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -29,19 +169,134 @@ func (app *application) BasicAuthMiddleware() func(http.Handler) http.Handler {
 				return
 			}
 
-			username := app.config.auth.basic.username
-			password := app.config.auth.basic.password
-
 			credentials := strings.SplitN(string(decoded), ":", 2)
-
-			if len(credentials) != 2 || credentials[0] != username || credentials[1] != password {
+			if len(credentials) != 2 {
 				app.unauthorizedBasicError(w, r, fmt.Errorf("invalid credentials"))
 				return
 			}
 
+			// hashing the password is expensive, throttle guessing before doing it
+			if !app.startAdminLoginAttempt(w, r, credentials[0]) {
+				return
+			}
+
 			// check the credentials against what we have stored
+			admin, err := app.authenticateAdmin(r.Context(), credentials[0], credentials[1])
+			if err != nil {
+				switch {
+				case errors.Is(err, errInvalidAdminCredentials):
+					app.recordAdminLoginFailure(r, credentials[0])
+					app.unauthorizedBasicError(w, r, err)
+				default:
+					app.internalServerError(w, r, err)
+				}
+				return
+			}
+			app.resetAdminLoginFailures(r, credentials[0])
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			ctx := context.WithValue(r.Context(), authAdminCtx, admin)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			app.recordAdminAudit(r, admin, ww.Status())
+		})
+	}
+}
+
+func (app *application) authenticatePersonalAccessToken(w http.ResponseWriter, r *http.Request, next http.Handler, plainToken string) {
+	ctx := r.Context()
+	accessToken, err := app.store.PersonalAccessTokens.GetByToken(ctx, hashToken(plainToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, fmt.Errorf("unknown or expired personal access token"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, accessToken.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.store.PersonalAccessTokens.RecordUse(ctx, accessToken.ID, clientIP(r)); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
 
+	ctx = context.WithValue(ctx, authUserCtx, user)
+	ctx = context.WithValue(ctx, authAccessTokenCtx, accessToken)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// lets personal access tokens through only when they have the scope, sessions
+// of the user itself can do everything. Has to run after AuthTokenMiddleware
+func (app *application) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			accessToken := getAuthAccessTokenFromCtx(r)
+			if accessToken != nil && !accessToken.HasScope(scope) {
+				app.forbiddenResponse(w, r, fmt.Errorf("the token is missing the %s scope", scope))
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// lets the request through when the role of the user grants the permission.
+// Has to run after AuthTokenMiddleware
+func (app *application) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getAuthUserFromCtx(r)
+			if !user.Role.HasPermission(permission) {
+				app.forbiddenResponse(w, r, fmt.Errorf("the %s permission is required", permission))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// keeps personal access tokens away from the account itself, managing
+// credentials needs a login. Has to run after AuthTokenMiddleware
+func (app *application) SessionOnlyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if getAuthAccessTokenFromCtx(r) != nil {
+			app.forbiddenResponse(w, r, fmt.Errorf("personal access tokens can not be used here"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// returns the personal access token the request was made with, nil for sessions
+func getAuthAccessTokenFromCtx(r *http.Request) *store.PersonalAccessToken {
+	accessToken, _ := r.Context().Value(authAccessTokenCtx).(*store.PersonalAccessToken)
+	return accessToken
+}
+
+// returns the address of the client. middleware.RealIP has already put the
+// forwarded address into RemoteAddr, without a proxy it still carries the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// returns the claims of the verified access token, set by AuthTokenMiddleware
+func getAuthClaimsFromCtx(r *http.Request) jwt.MapClaims {
+	claims, _ := r.Context().Value(authClaimsCtx).(jwt.MapClaims)
+	return claims
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/oidc"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+type AuthorizationURL struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+type OIDCCallbackPayload struct {
+	Code  string `json:"code" validate:"required,max=2048"`
+	State string `json:"state" validate:"required,max=255"`
+}
+
+// StartOIDCLogin godoc
+//
+//	@Summary		Start a login with an external provider
+//	@Description	Returns the url of the provider the user has to be sent to
+//	@Tags			authentication
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Success		200			{object}	AuthorizationURL
+//	@Failure		404			{object}	error
+//	@Failure		429			{object}	error
+//	@Failure		500			{object}	error
+//	@Router			/authentication/oidc/{provider} [get]
+func (app *application) startOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	// every call stores a state, anonymous callers must not be able to flood the table
+	if allow, retryAfter := app.loginRateLimiter.Allow("oidc-login:ip:" + clientIP(r)); !allow {
+		app.rateLimitExceededResponse(w, r, retryAfter)
+		return
+	}
+
+	app.startOIDCFlow(w, r, 0)
+}
+
+// OIDCLoginCallback godoc
+//
+//	@Summary		Finish a login with an external provider
+//	@Description	Exchanges the code the provider redirected back with. A known identity logs its user in, a verified email
+//	@Description	is linked to the account that has it and otherwise a new account is created
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			provider	path		string				true	"Provider name"
+//	@Param			payload		body		OIDCCallbackPayload	true	"Code and state"
+//	@Success		200			{object}	TwoFactorChallenge	"Second factor required"
+//	@Success		201			{object}	TokenPair			"Tokens"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Router			/authentication/oidc/{provider}/callback [post]
+func (app *application) oidcLoginCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, idToken, ok := app.finishOIDCFlow(w, r, 0)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	identity, err := app.store.Identities.GetBySubject(ctx, provider.Name(), idToken.Subject)
+	switch {
+	case err == nil:
+		user, err := app.store.Users.GetByID(ctx, identity.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedError(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+		app.respondWithLogin(w, r, user)
+		return
+	case !errors.Is(err, store.ErrNotFound):
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// without an address the provider vouches for we can neither match nor create an account
+	if idToken.Email == "" || !idToken.EmailVerified {
+		app.badRequestError(w, r, fmt.Errorf("the %s account has no verified email", provider.Name()))
+		return
+	}
+
+	identity = &store.Identity{
+		Provider: provider.Name(),
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+	}
+
+	user, err := app.findUserForIdentity(ctx, idToken.Email)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if user != nil {
+		// an unactivated account could have been registered by anyone, the
+		// random password locks them out now that the owner has shown up
+		if !user.IsActive {
+			if err := setRandomPassword(user); err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+		}
+
+		if err := app.store.Users.LinkIdentityAndActivate(ctx, user, identity); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		app.respondWithLogin(w, r, user)
+		return
+	}
+
+	// the callback can't carry an invite code, so only open registration
+	// creates accounts for new identities
+	if err := app.registrationAllowed(""); err != nil {
+		app.forbiddenResponse(w, r, err)
+		return
+	}
+
+	// the provider vouches for the address, not for its domain
+	if !app.checkEmailPolicy(w, r, idToken.Email) {
+		return
+	}
+
+	user, err = app.createUserForIdentity(ctx, idToken, identity)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.respondWithNewTokens(w, r, user)
+}
+
+// StartOIDCLink godoc
+//
+//	@Summary		Start linking an external provider
+//	@Description	Returns the url of the provider the user has to be sent to
+//	@Tags			users
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Success		200			{object}	AuthorizationURL
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/identities/{provider} [post]
+func (app *application) startOIDCLinkHandler(w http.ResponseWriter, r *http.Request) {
+	app.startOIDCFlow(w, r, getAuthUserFromCtx(r).ID)
+}
+
+// OIDCLinkCallback godoc
+//
+//	@Summary		Finish linking an external provider
+//	@Description	Exchanges the code the provider redirected back with and links the identity to the current user
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			provider	path		string				true	"Provider name"
+//	@Param			payload		body		OIDCCallbackPayload	true	"Code and state"
+//	@Success		201			{object}	store.Identity
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		409			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/identities/{provider}/callback [post]
+func (app *application) oidcLinkCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	provider, idToken, ok := app.finishOIDCFlow(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	identity := &store.Identity{
+		UserID:   user.ID,
+		Provider: provider.Name(),
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+	}
+	if err := app.store.Identities.Create(r.Context(), identity); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, fmt.Errorf("the %s account is already linked", provider.Name()))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, identity); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListIdentities godoc
+//
+//	@Summary		List linked providers
+//	@Description	Lists the external accounts linked to the current user
+//	@Tags			users
+//	@Produce		json
+//	@Success		200	{array}		store.Identity
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/identities [get]
+func (app *application) listIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	identities, err := app.store.Identities.ListByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, identities); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeleteIdentity godoc
+//
+//	@Summary		Unlink a provider
+//	@Description	Removes a linked external account of the current user
+//	@Tags			users
+//	@Produce		json
+//	@Param			identityId	path		int		true	"Identity ID"
+//	@Success		204			{string}	string	"Identity unlinked"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/identities/{identityId} [delete]
+func (app *application) deleteIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	identityID, err := strconv.ParseInt(chi.URLParam(r, "identityId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.Identities.Delete(r.Context(), user.ID, identityID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// remembers state, nonce and PKCE verifier and answers with the provider url.
+// A userID other than 0 marks the flow as linking to that user
+func (app *application) startOIDCFlow(w http.ResponseWriter, r *http.Request, userID int64) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundError(w, r, fmt.Errorf("unknown provider %q", chi.URLParam(r, "provider")))
+		return
+	}
+
+	var values [2]string
+	for i := range values {
+		value, err := oidc.NewState()
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		values[i] = value
+	}
+	state, nonce := values[0], values[1]
+
+	codeVerifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	oauthState := &store.OAuthState{
+		Provider:     provider.Name(),
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		UserID:       userID,
+	}
+	if err := app.store.Identities.CreateState(ctx, hashToken(state), oauthState, app.config.oidc.stateExp); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	authorizationURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, AuthorizationURL{AuthorizationURL: authorizationURL}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// consumes the state and exchanges the code. The state has to belong to the
+// same kind of flow, otherwise a link started by someone else could be finished
+// by a victim. Writes the error response and returns false when it fails
+func (app *application) finishOIDCFlow(w http.ResponseWriter, r *http.Request, userID int64) (*oidc.Provider, *oidc.IDToken, bool) {
+	provider, ok := app.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		app.notFoundError(w, r, fmt.Errorf("unknown provider %q", chi.URLParam(r, "provider")))
+		return nil, nil, false
+	}
+
+	var payload OIDCCallbackPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return nil, nil, false
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return nil, nil, false
+	}
+
+	ctx := r.Context()
+	oauthState, err := app.store.Identities.ConsumeState(ctx, hashToken(payload.State), provider.Name())
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, fmt.Errorf("unknown or expired oauth state"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	if oauthState.UserID != userID {
+		app.unauthorizedError(w, r, fmt.Errorf("oauth state was issued for another flow"))
+		return nil, nil, false
+	}
+
+	idToken, err := provider.Exchange(ctx, payload.Code, oauthState.CodeVerifier, oauthState.Nonce)
+	if err != nil {
+		app.unauthorizedError(w, r, err)
+		return nil, nil, false
+	}
+	return provider, idToken, true
+}
+
+// returns the active or unactivated account registered with the email, nil when there is none
+func (app *application) findUserForIdentity(ctx context.Context, email string) (*store.User, error) {
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	switch {
+	case err == nil:
+		user.IsActive = true
+		return user, nil
+	case !errors.Is(err, store.ErrNotFound):
+		return nil, err
+	}
+
+	user, err = app.store.Users.GetInactiveByEmail(ctx, email)
+	switch {
+	case err == nil:
+		return user, nil
+	case errors.Is(err, store.ErrNotFound):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+var usernameCleaner = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// creates an account for a new identity, the username is taken from the
+// provider and gets a random suffix while it is taken
+func (app *application) createUserForIdentity(ctx context.Context, idToken *oidc.IDToken, identity *store.Identity) (*store.User, error) {
+	base := idToken.Username
+	if base == "" {
+		base, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	base = usernameCleaner.ReplaceAllString(base, "")
+	if len(base) > 90 {
+		base = base[:90]
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	username := base
+	for attempt := 0; attempt < 5; attempt++ {
+		user := &store.User{
+			Username: username,
+			Email:    idToken.Email,
+		}
+		// the account can only be used through the provider until a password reset
+		if err := setRandomPassword(user); err != nil {
+			return nil, err
+		}
+
+		err := app.store.Users.CreateWithIdentity(ctx, user, identity)
+		if !errors.Is(err, store.ErrDuplicateUsername) {
+			return user, err
+		}
+
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		username = fmt.Sprintf("%s%x", base, suffix)
+	}
+	return nil, fmt.Errorf("could not find a free username for %q", base)
+}
+
+func setRandomPassword(user *store.User) error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	return user.Password.Set(base64.RawURLEncoding.EncodeToString(secret))
+}
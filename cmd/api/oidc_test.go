@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harshvse/go-api/internal/auth"
+	"github.com/harshvse/go-api/internal/emailpolicy"
+	"github.com/harshvse/go-api/internal/oidc"
+	"github.com/harshvse/go-api/internal/oidc/oidctest"
+	"github.com/harshvse/go-api/internal/ratelimiter"
+	"github.com/harshvse/go-api/internal/store"
+	"go.uber.org/zap"
+)
+
+type oidcTest struct {
+	app         *application
+	issuer      *oidctest.Issuer
+	users       *fakeUserStore
+	identities  *fakeIdentityStore
+	domainRules *fakeEmailDomainRuleStore
+	router      http.Handler
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("client-id", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+
+	identities := newFakeIdentityStore()
+	users := newFakeUserStore(identities)
+	domainRules := &fakeEmailDomainRuleStore{}
+
+	var cfg config
+	cfg.auth.mode = authModeBearer
+	cfg.auth.token = tokenConfig{exp: time.Hour, refreshExp: time.Hour, iss: "test"}
+	cfg.oidc.stateExp = time.Minute * 10
+	cfg.registration.mode = registrationModeOpen
+
+	app := &application{
+		config: cfg,
+		store: store.Storage{
+			Users:            users,
+			Identities:       identities,
+			TwoFactor:        &fakeTwoFactorStore{lastUsedStep: make(map[int64]int64)},
+			Sessions:         &fakeSessionStore{},
+			EmailDomainRules: domainRules,
+		},
+		emailPolicy:      &emailpolicy.Policy{Disposable: emailpolicy.NewDisposableList()},
+		logger:           zap.NewNop().Sugar(),
+		authenticator:    auth.NewJWTAuthenticator("secret", "test", "test"),
+		loginRateLimiter: ratelimiter.NewFixedWindowLimiter(100, time.Minute),
+		oidcProviders: map[string]*oidc.Provider{
+			"test": oidc.NewProvider(oidc.Config{
+				Name:         "test",
+				Issuer:       issuer.URL,
+				ClientID:     issuer.ClientID,
+				ClientSecret: issuer.ClientSecret,
+				RedirectURL:  "http://localhost:3000/oidc/callback",
+			}, nil),
+		},
+	}
+
+	r := chi.NewRouter()
+	r.Get("/oidc/{provider}", app.startOIDCLoginHandler)
+	r.Post("/oidc/{provider}/callback", app.oidcLoginCallbackHandler)
+	r.Group(func(r chi.Router) {
+		// stands in for AuthTokenMiddleware, the user id comes from a header
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user := &store.User{}
+				json.Unmarshal([]byte(r.Header.Get("X-Test-User")), user)
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authUserCtx, user)))
+			})
+		})
+		r.Post("/link/{provider}", app.startOIDCLinkHandler)
+		r.Post("/link/{provider}/callback", app.oidcLinkCallbackHandler)
+	})
+
+	return &oidcTest{app: app, issuer: issuer, users: users, identities: identities, domainRules: domainRules, router: r}
+}
+
+func (o *oidcTest) do(t *testing.T, method, path string, user *store.User, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	r := httptest.NewRequest(method, path, &buf)
+	if user != nil {
+		encoded, _ := json.Marshal(user)
+		r.Header.Set("X-Test-User", string(encoded))
+	}
+	w := httptest.NewRecorder()
+	o.router.ServeHTTP(w, r)
+	return w
+}
+
+// starts a flow, lets the issuer log the user in with the claims and returns
+// the code and state the provider redirects back with
+func (o *oidcTest) authorize(t *testing.T, startPath string, user *store.User, claims jwt.MapClaims) OIDCCallbackPayload {
+	t.Helper()
+	method := http.MethodGet
+	if user != nil {
+		method = http.MethodPost
+	}
+	w := o.do(t, method, startPath, user, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("start %s = %d %s", startPath, w.Code, w.Body)
+	}
+
+	var response struct {
+		Data AuthorizationURL `json:"data"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	code, state, err := o.issuer.Authorize(response.Data.AuthorizationURL, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return OIDCCallbackPayload{Code: code, State: state}
+}
+
+func (o *oidcTest) login(t *testing.T, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	t.Helper()
+	payload := o.authorize(t, "/oidc/test", nil, claims)
+	return o.do(t, http.MethodPost, "/oidc/test/callback", nil, payload)
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	o := newOIDCTest(t)
+
+	claims := o.issuer.Claims("subject-1", "jane@example.com")
+	claims["preferred_username"] = "jane doe"
+	if w := o.login(t, claims); w.Code != http.StatusCreated {
+		t.Fatalf("first login = %d %s", w.Code, w.Body)
+	}
+
+	user, err := o.users.GetByEmail(context.Background(), "jane@example.com")
+	if err != nil {
+		t.Fatalf("no account created: %v", err)
+	}
+	if user.Username != "janedoe" {
+		t.Errorf("username = %q, want %q", user.Username, "janedoe")
+	}
+
+	// the identity logs the same account in from now on
+	if w := o.login(t, o.issuer.Claims("subject-1", "jane@example.com")); w.Code != http.StatusCreated {
+		t.Fatalf("second login = %d %s", w.Code, w.Body)
+	}
+	if len(o.users.users) != 1 || len(o.identities.identities) != 1 {
+		t.Fatalf("%d users and %d identities, want 1 each", len(o.users.users), len(o.identities.identities))
+	}
+}
+
+func TestOIDCLoginLinksAccountWithVerifiedEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	existing := o.users.add(&store.User{Username: "jane", Email: "jane@example.com", IsActive: true})
+	unactivated := o.users.add(&store.User{Username: "john", Email: "john@example.com"})
+
+	if w := o.login(t, o.issuer.Claims("subject-1", "jane@example.com")); w.Code != http.StatusCreated {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	if identity, err := o.identities.GetBySubject(context.Background(), "test", "subject-1"); err != nil || identity.UserID != existing.ID {
+		t.Fatalf("identity = %+v, %v, want it linked to user %d", identity, err, existing.ID)
+	}
+
+	// the provider verified the address so the unactivated account is activated
+	if w := o.login(t, o.issuer.Claims("subject-2", "john@example.com")); w.Code != http.StatusCreated {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	if !o.users.users[unactivated.ID].IsActive {
+		t.Fatal("unactivated account was not activated")
+	}
+	if len(o.users.users) != 2 {
+		t.Fatalf("%d users, want no new account", len(o.users.users))
+	}
+}
+
+func TestOIDCLoginRejectsUnverifiedEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	o.users.add(&store.User{Username: "jane", Email: "jane@example.com", IsActive: true})
+
+	claims := o.issuer.Claims("subject-1", "jane@example.com")
+	claims["email_verified"] = false
+	if w := o.login(t, claims); w.Code != http.StatusBadRequest {
+		t.Fatalf("login = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if len(o.identities.identities) != 0 {
+		t.Fatal("identity linked without a verified email")
+	}
+}
+
+func TestOIDCLoginAppliesEmailPolicy(t *testing.T) {
+	o := newOIDCTest(t)
+	o.domainRules.rules = []*store.EmailDomainRule{{Domain: "blocked.example", Action: "deny"}}
+	o.users.add(&store.User{Username: "jane", Email: "jane.doe@gmail.com", IsActive: true})
+
+	tests := []struct {
+		email string
+		code  string
+	}{
+		{"john@blocked.example", emailpolicy.CodeDenied},
+		// another spelling of an existing inbox doesn't get a second account
+		{"janedoe+sso@gmail.com", emailDuplicateCode},
+	}
+
+	for i, tt := range tests {
+		w := o.login(t, o.issuer.Claims(fmt.Sprintf("subject-%d", i), tt.email))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("login of %s = %d, want %d", tt.email, w.Code, http.StatusBadRequest)
+		}
+
+		var response struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != tt.code {
+			t.Errorf("login of %s rejected with %q, want %q", tt.email, response.Code, tt.code)
+		}
+	}
+
+	if len(o.users.users) != 1 {
+		t.Fatalf("%d users, want no new account", len(o.users.users))
+	}
+}
+
+func TestOIDCLoginRejectsBadIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(jwt.MapClaims)
+	}{
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{"other nonce", func(c jwt.MapClaims) { c["nonce"] = "other" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			claims := o.issuer.Claims("subject-1", "jane@example.com")
+			tt.change(claims)
+
+			if w := o.login(t, claims); w.Code != http.StatusUnauthorized {
+				t.Fatalf("login = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+			if len(o.users.users) != 0 {
+				t.Fatal("account created from a rejected token")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackValidatesState(t *testing.T) {
+	o := newOIDCTest(t)
+	payload := o.authorize(t, "/oidc/test", nil, o.issuer.Claims("subject-1", "jane@example.com"))
+
+	unknown := payload
+	unknown.State = "made-up"
+	if w := o.do(t, http.MethodPost, "/oidc/test/callback", nil, unknown); w.Code != http.StatusUnauthorized {
+		t.Fatalf("unknown state = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if w := o.do(t, http.MethodPost, "/oidc/test/callback", nil, payload); w.Code != http.StatusCreated {
+		t.Fatalf("callback = %d %s", w.Code, w.Body)
+	}
+	// every state finishes one flow
+	if w := o.do(t, http.MethodPost, "/oidc/test/callback", nil, payload); w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed state = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOIDCLinkStateCantFinishLogin(t *testing.T) {
+	o := newOIDCTest(t)
+	attacker := o.users.add(&store.User{Username: "mallory", Email: "mallory@example.com", IsActive: true})
+
+	// a link started by one user must not log a victim into that account
+	payload := o.authorize(t, "/link/test", attacker, o.issuer.Claims("subject-1", "jane@example.com"))
+	if w := o.do(t, http.MethodPost, "/oidc/test/callback", nil, payload); w.Code != http.StatusUnauthorized {
+		t.Fatalf("login with a link state = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// and a login state must not finish a link
+	payload = o.authorize(t, "/oidc/test", nil, o.issuer.Claims("subject-1", "jane@example.com"))
+	if w := o.do(t, http.MethodPost, "/link/test/callback", attacker, payload); w.Code != http.StatusUnauthorized {
+		t.Fatalf("link with a login state = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if len(o.identities.identities) != 0 {
+		t.Fatal("identity linked")
+	}
+}
+
+func TestOIDCLinkToCurrentUser(t *testing.T) {
+	o := newOIDCTest(t)
+	user := o.users.add(&store.User{Username: "jane", Email: "jane@example.com", IsActive: true})
+
+	payload := o.authorize(t, "/link/test", user, o.issuer.Claims("subject-1", "other@example.com"))
+	if w := o.do(t, http.MethodPost, "/link/test/callback", user, payload); w.Code != http.StatusCreated {
+		t.Fatalf("link = %d %s", w.Code, w.Body)
+	}
+	if identity, err := o.identities.GetBySubject(context.Background(), "test", "subject-1"); err != nil || identity.UserID != user.ID {
+		t.Fatalf("identity = %+v, %v, want it linked to user %d", identity, err, user.ID)
+	}
+
+	// the same provider account can't be linked twice
+	other := o.users.add(&store.User{Username: "john", Email: "john@example.com", IsActive: true})
+	payload = o.authorize(t, "/link/test", other, o.issuer.Claims("subject-1", "other@example.com"))
+	if w := o.do(t, http.MethodPost, "/link/test/callback", other, payload); w.Code != http.StatusConflict {
+		t.Fatalf("second link = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
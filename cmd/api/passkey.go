@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/store"
+	"github.com/harshvse/go-api/internal/webauthn"
+)
+
+const (
+	passkeyRegistrationCeremony = "registration"
+	passkeyLoginCeremony        = "login"
+)
+
+// the JSON form of a PublicKeyCredential with every binary value as base64url
+type RegistrationCredential struct {
+	ID       string `json:"id" validate:"required"`
+	RawID    string `json:"rawId" validate:"required"`
+	Type     string `json:"type" validate:"required,eq=public-key"`
+	Response struct {
+		ClientDataJSON    string   `json:"clientDataJSON" validate:"required"`
+		AttestationObject string   `json:"attestationObject" validate:"required"`
+		Transports        []string `json:"transports" validate:"max=10,dive,max=20"`
+	} `json:"response"`
+}
+
+type RegisterPasskeyPayload struct {
+	Name       string                 `json:"name" validate:"max=100"`
+	Credential RegistrationCredential `json:"credential"`
+}
+
+type AssertionCredential struct {
+	ID       string `json:"id" validate:"required"`
+	RawID    string `json:"rawId" validate:"required"`
+	Type     string `json:"type" validate:"required,eq=public-key"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON" validate:"required"`
+		AuthenticatorData string `json:"authenticatorData" validate:"required"`
+		Signature         string `json:"signature" validate:"required"`
+		UserHandle        string `json:"userHandle" validate:"required"`
+	} `json:"response"`
+}
+
+// BeginPasskeyRegistration godoc
+//
+//	@Summary		Start a passkey registration
+//	@Description	Returns the options for navigator.credentials.create()
+//	@Tags			passkeys
+//	@Produce		json
+//	@Success		200	{object}	webauthn.CreationOptions
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/passkeys/register/begin [post]
+func (app *application) beginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+	ctx := r.Context()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Passkeys.CreateChallenge(ctx, challenge, user.ID, passkeyRegistrationCeremony, app.config.webauthn.timeout); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// stop the browser from registering a second passkey on the same authenticator
+	passkeys, err := app.store.Passkeys.ListByUserID(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	exclude := make([]webauthn.CredentialDescriptor, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		exclude = append(exclude, webauthn.CredentialDescriptor{
+			Type:       "public-key",
+			ID:         base64.RawURLEncoding.EncodeToString(passkey.CredentialID),
+			Transports: passkey.Transports,
+		})
+	}
+
+	options := app.webauthn.CreationOptions(challenge, passkeyUserHandle(user.ID), user.Email, user.Username, exclude, app.config.webauthn.timeout)
+	if err := app.jsonResponse(w, http.StatusOK, options); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// FinishPasskeyRegistration godoc
+//
+//	@Summary		Finish a passkey registration
+//	@Description	Verifies the new credential from navigator.credentials.create() and stores it
+//	@Tags			passkeys
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		RegisterPasskeyPayload	true	"Credential"
+//	@Success		201		{object}	store.Passkey
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/passkeys/register/finish [post]
+func (app *application) finishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	var payload RegisterPasskeyPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	clientDataJSON, err := webauthn.DecodeBase64URL(payload.Credential.Response.ClientDataJSON)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+	attestationObject, err := webauthn.DecodeBase64URL(payload.Credential.Response.AttestationObject)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	challenge, ok := app.consumePasskeyChallenge(w, r, clientDataJSON, passkeyRegistrationCeremony, user.ID)
+	if !ok {
+		return
+	}
+
+	credential, err := app.webauthn.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	passkey := &store.Passkey{
+		CredentialID: credential.ID,
+		UserID:       user.ID,
+		PublicKey:    credential.PublicKey,
+		Algorithm:    credential.Algorithm,
+		AAGUID:       credential.AAGUID,
+		SignCount:    credential.SignCount,
+		Transports:   payload.Credential.Response.Transports,
+		Name:         payload.Name,
+	}
+	if err := app.store.Passkeys.Create(ctx, passkey); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, fmt.Errorf("the passkey is already registered"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, passkey); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ListPasskeys godoc
+//
+//	@Summary		List passkeys
+//	@Description	Lists the passkeys registered for the current user
+//	@Tags			passkeys
+//	@Produce		json
+//	@Success		200	{array}		store.Passkey
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/passkeys [get]
+func (app *application) listPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	passkeys, err := app.store.Passkeys.ListByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, passkeys); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DeletePasskey godoc
+//
+//	@Summary		Delete a passkey
+//	@Description	Removes a passkey of the current user
+//	@Tags			passkeys
+//	@Produce		json
+//	@Param			passkeyId	path		int		true	"Passkey ID"
+//	@Success		204			{string}	string	"Passkey deleted"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/passkeys/{passkeyId} [delete]
+func (app *application) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	passkeyID, err := strconv.ParseInt(chi.URLParam(r, "passkeyId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.Passkeys.Delete(r.Context(), user.ID, passkeyID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// BeginPasskeyLogin godoc
+//
+//	@Summary		Start a passkey login
+//	@Description	Returns the options for navigator.credentials.get()
+//	@Tags			authentication
+//	@Produce		json
+//	@Success		200	{object}	webauthn.RequestOptions
+//	@Failure		429	{object}	error
+//	@Failure		500	{object}	error
+//	@Router			/authentication/passkey/begin [post]
+func (app *application) beginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	// every call stores a challenge, anonymous callers must not be able to flood the table
+	if allow, retryAfter := app.loginRateLimiter.Allow("passkey-login:ip:" + clientIP(r)); !allow {
+		app.rateLimitExceededResponse(w, r, retryAfter)
+		return
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Passkeys.CreateChallenge(r.Context(), challenge, 0, passkeyLoginCeremony, app.config.webauthn.timeout); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	options := app.webauthn.RequestOptions(challenge, app.config.webauthn.timeout)
+	if err := app.jsonResponse(w, http.StatusOK, options); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// FinishPasskeyLogin godoc
+//
+//	@Summary		Finish a passkey login
+//	@Description	Verifies the assertion from navigator.credentials.get() and issues the same tokens as the password login
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		AssertionCredential	true	"Assertion"
+//	@Success		201		{object}	TokenPair			"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/passkey/finish [post]
+func (app *application) finishPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var payload AssertionCredential
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	var decoded [5][]byte
+	for i, value := range []string{
+		payload.RawID,
+		payload.Response.ClientDataJSON,
+		payload.Response.AuthenticatorData,
+		payload.Response.Signature,
+		payload.Response.UserHandle,
+	} {
+		bytes, err := webauthn.DecodeBase64URL(value)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+		decoded[i] = bytes
+	}
+	credentialID, clientDataJSON, authenticatorData, signature, userHandle := decoded[0], decoded[1], decoded[2], decoded[3], decoded[4]
+
+	ctx := r.Context()
+	challenge, ok := app.consumePasskeyChallenge(w, r, clientDataJSON, passkeyLoginCeremony, 0)
+	if !ok {
+		return
+	}
+
+	passkey, err := app.store.Passkeys.GetByCredentialID(ctx, credentialID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// the authenticator tells us who it thinks it is logging in
+	if string(userHandle) != string(passkeyUserHandle(passkey.UserID)) {
+		app.unauthorizedError(w, r, fmt.Errorf("user handle does not match the passkey owner"))
+		return
+	}
+
+	credential := &webauthn.Credential{
+		ID:        passkey.CredentialID,
+		PublicKey: passkey.PublicKey,
+		Algorithm: passkey.Algorithm,
+		SignCount: passkey.SignCount,
+	}
+	signCount, err := app.webauthn.VerifyAssertion(challenge, credential, clientDataJSON, authenticatorData, signature)
+	if err != nil {
+		app.unauthorizedError(w, r, err)
+		return
+	}
+
+	if err := app.store.Passkeys.UpdateSignCount(ctx, passkey.ID, signCount); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, passkey.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	app.respondWithNewTokens(w, r, user)
+}
+
+// takes the challenge out of clientDataJSON and makes sure we issued it for this
+// ceremony and user. Writes the error response and returns false when not
+func (app *application) consumePasskeyChallenge(w http.ResponseWriter, r *http.Request, clientDataJSON []byte, ceremony string, userID int64) (string, bool) {
+	challenge, err := webauthn.ChallengeFromClientData(clientDataJSON)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return "", false
+	}
+
+	challengeUserID, err := app.store.Passkeys.ConsumeChallenge(r.Context(), challenge, ceremony)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, fmt.Errorf("unknown or expired passkey challenge"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return "", false
+	}
+
+	if challengeUserID != userID {
+		app.unauthorizedError(w, r, fmt.Errorf("passkey challenge was issued for another user"))
+		return "", false
+	}
+	return challenge, true
+}
+
+// the user handle identifies the account inside the passkey, it must not contain
+// personal information so it is just the user id
+func passkeyUserHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/harshvse/go-api/internal/mailer"
+	"github.com/harshvse/go-api/internal/passwordpolicy"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+type ForgotPasswordPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ForgotPassword godoc
+//
+//	@Summary		Request a password reset
+//	@Description	Emails a password reset link if an active account exists for the address
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ForgotPasswordPayload	true	"Account Email"
+//	@Success		202		{string}	string					"Reset requested"
+//	@Failure		400		{object}	error
+//	@Failure		429		{object}	error
+//	@Router			/authentication/password/forgot [post]
+func (app *application) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ForgotPasswordPayload
+
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// limit both the address and the caller so nobody can flood an inbox
+	for _, key := range []string{"password-reset:ip:" + clientIP(r), "password-reset:email:" + strings.ToLower(payload.Email)} {
+		if allow, retryAfter := app.rateLimiter.Allow(key); !allow {
+			app.rateLimitExceededResponse(w, r, retryAfter)
+			return
+		}
+	}
+
+	// the reset is looked up and sent after answering, the response is the same
+	// and takes as long whether the account exists or not so it can't be used
+	// to find out which emails are registered
+	ctx := context.WithoutCancel(r.Context())
+	app.background(func() {
+		app.sendPasswordReset(ctx, payload.Email)
+	})
+
+	app.writeAccepted(w, r)
+}
+
+func (app *application) sendPasswordReset(ctx context.Context, email string) {
+	user, err := app.store.Users.GetByEmail(ctx, email)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		app.logger.Infow("password reset requested for unknown email")
+		return
+	case err != nil:
+		app.logger.Errorw("looking up the account for a password reset failed", "error", err.Error())
+		return
+	}
+
+	plainToken := uuid.New().String()
+	if err := app.store.Users.CreatePasswordReset(ctx, user.ID, hashToken(plainToken), app.config.mail.passwordResetExp); err != nil {
+		app.logger.Errorw("creating the password reset failed", "user_id", user.ID, "error", err.Error())
+		return
+	}
+
+	vars := struct {
+		Username string
+		ResetUrl string
+		Expiry   string
+	}{
+		Username: user.Username,
+		ResetUrl: fmt.Sprintf("%s/reset-password/%s", app.config.frontendURL, plainToken),
+		Expiry:   app.config.mail.passwordResetExp.String(),
+	}
+
+	if err := app.sendEmail(mailer.PasswordResetTemplate, user.Username, user.Email, vars); err != nil {
+		app.logger.Errorw("sending the password reset email failed", "user_id", user.ID, "error", err.Error())
+	}
+}
+
+type ResetPasswordPayload struct {
+	Token    string `json:"token" validate:"required,max=255"`
+	Password string `json:"password" validate:"required,max=256"`
+}
+
+// ResetPassword godoc
+//
+//	@Summary		Reset a password
+//	@Description	Sets a new password using the token from the password reset email
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ResetPasswordPayload	true	"Reset Token and New Password"
+//	@Success		200		{string}	string					"Password changed"
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/password/reset [post]
+func (app *application) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordPayload
+
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	user, err := app.store.Users.GetByPasswordResetToken(r.Context(), payload.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if !app.checkPasswordPolicy(w, r, "password", payload.Password, user) {
+		return
+	}
+
+	if err := user.Password.Set(payload.Password); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Users.ResetPassword(r.Context(), payload.Token, user); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type ChangePasswordPayload struct {
+	CurrentPassword string `json:"current_password" validate:"required,max=256"`
+	NewPassword     string `json:"new_password" validate:"required,max=256"`
+}
+
+// ChangePassword godoc
+//
+//	@Summary		Change the password
+//	@Description	Sets a new password after checking the current one, every other session and all personal access tokens are signed out
+//	@Tags			users
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangePasswordPayload	true	"Current and new password"
+//	@Success		200		{string}	string					"Password changed"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/password [put]
+func (app *application) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	authUser := getAuthUserFromCtx(r)
+
+	var payload ChangePasswordPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// guessing the current password is throttled like logins
+	if !app.startLoginAttempt(w, r, authUser.Email) {
+		return
+	}
+
+	// the user in the context is loaded without the password hash
+	user, err := app.store.Users.GetByEmail(r.Context(), authUser.Email)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := user.Password.Compare(payload.CurrentPassword); err != nil {
+		app.recordLoginFailure(r, user.Email, user)
+		app.failedValidationResponse(w, r, map[string][]string{
+			"current_password": {"is not correct"},
+		})
+		return
+	}
+	app.resetLoginFailures(r, user.Email)
+
+	if !app.checkPasswordPolicy(w, r, "new_password", payload.NewPassword, user) {
+		return
+	}
+
+	if err := user.Password.Set(payload.NewPassword); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	sessionID, _ := getAuthClaimsFromCtx(r)["sid"].(string)
+	if err := app.store.Users.ChangePassword(r.Context(), user, sessionID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// answers with the reasons and their codes and returns false when the password
+// doesn't meet the policy, field names the payload field the password was sent in
+func (app *application) checkPasswordPolicy(w http.ResponseWriter, r *http.Request, field, password string, user *store.User) bool {
+	violations, err := app.passwordPolicy.Check(password, passwordpolicy.UserInfo{
+		Username: user.Username,
+		Email:    user.Email,
+	})
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	app.passwordRejectedResponse(w, r, field, violations)
+	return false
+}
+
+func (app *application) writeAccepted(w http.ResponseWriter, r *http.Request) {
+	if err := app.jsonResponse(w, http.StatusAccepted, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/harshvse/go-api/internal/passwordpolicy"
+	"github.com/harshvse/go-api/internal/store"
+	"go.uber.org/zap"
+)
+
+func TestCheckPasswordPolicyAnswersWithCodes(t *testing.T) {
+	app := &application{
+		logger:         zap.NewNop().Sugar(),
+		passwordPolicy: &passwordpolicy.Policy{MinLength: 10, MinScore: 3},
+	}
+	user := &store.User{Username: "janedoe", Email: "jane@example.com"}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	if app.checkPasswordPolicy(w, r, "new_password", "janedoe1", user) {
+		t.Fatal("weak password accepted")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var response struct {
+		Fields map[string][]string `json:"fields"`
+		Codes  map[string][]string `json:"codes"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{passwordpolicy.CodeTooShort, passwordpolicy.CodeContainsUserInfo, passwordpolicy.CodeTooWeak}
+	if codes := response.Codes["new_password"]; !slices.Equal(codes, want) {
+		t.Fatalf("codes = %v, want %v", codes, want)
+	}
+	if len(response.Fields["new_password"]) != len(want) {
+		t.Fatalf("messages = %v, want one per code", response.Fields["new_password"])
+	}
+
+	w = httptest.NewRecorder()
+	if !app.checkPasswordPolicy(w, r, "new_password", "x7#Kq9!vLm2$Rt", user) {
+		t.Fatalf("strong password rejected: %s", w.Body)
+	}
+}
@@ -34,15 +34,13 @@ func (app *application) createNewPostHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	ctx := r.Context()
-
-	// TODO: Change to real userid
-	userId := 1
+	user := getAuthUserFromCtx(r)
 
 	post := &store.Post{
 		Title:   postPayload.Title,
 		Content: postPayload.Content,
 		Tags:    postPayload.Tags,
-		UserID:  int64(userId),
+		UserID:  user.ID,
 	}
 
 	if err := app.store.Posts.Create(ctx, post); err != nil {
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// provisioning tokens are told apart from other credentials by this prefix
+const provisioningTokenPrefix = "scim_"
+
+// lets the identity provider in with a bearer provisioning token, failures
+// are answered in the SCIM error format
+func (app *application) ProvisioningTokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plainToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !strings.HasPrefix(plainToken, provisioningTokenPrefix) {
+			app.scimUnauthorized(w, r, fmt.Errorf("no provisioning token found"))
+			return
+		}
+
+		ctx := r.Context()
+		token, err := app.store.ProvisioningTokens.GetByToken(ctx, hashToken(plainToken))
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.scimUnauthorized(w, r, fmt.Errorf("unknown or revoked provisioning token"))
+			default:
+				app.scimError(w, r, http.StatusInternalServerError, "", err)
+			}
+			return
+		}
+
+		if err := app.store.ProvisioningTokens.RecordUse(ctx, token.ID); err != nil {
+			app.scimError(w, r, http.StatusInternalServerError, "", err)
+			return
+		}
+
+		ctx = context.WithValue(ctx, authProvisioningTokenCtx, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// returns the token the identity provider authenticated with, set by
+// ProvisioningTokenMiddleware
+func getProvisioningTokenFromCtx(r *http.Request) *store.ProvisioningToken {
+	token, _ := r.Context().Value(authProvisioningTokenCtx).(*store.ProvisioningToken)
+	return token
+}
+
+type CreateProvisioningTokenPayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type ProvisioningTokenWithToken struct {
+	*store.ProvisioningToken
+	Token string `json:"token"`
+}
+
+// ListProvisioningTokens godoc
+//
+//	@Summary		List provisioning tokens
+//	@Description	Lists the tokens identity providers use for the SCIM endpoints, revoked ones included
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.ProvisioningToken
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/provisioning-tokens [get]
+func (app *application) listProvisioningTokensHandler(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.store.ProvisioningTokens.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateProvisioningToken godoc
+//
+//	@Summary		Create a provisioning token
+//	@Description	Creates a bearer token for the SCIM client of an identity provider, the token is only shown in this response
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateProvisioningTokenPayload	true	"Name of the identity provider"
+//	@Success		201		{object}	ProvisioningTokenWithToken
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/provisioning-tokens [post]
+func (app *application) createProvisioningTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateProvisioningTokenPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	plainToken := provisioningTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	token := &store.ProvisioningToken{
+		Name:  payload.Name,
+		Token: hashToken(plainToken),
+	}
+	if err := app.store.ProvisioningTokens.Create(r.Context(), token); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := ProvisioningTokenWithToken{
+		ProvisioningToken: token,
+		Token:             plainToken,
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, response); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RevokeProvisioningToken godoc
+//
+//	@Summary		Revoke a provisioning token
+//	@Description	Stops accepting the token on the SCIM endpoints
+//	@Tags			admin
+//	@Produce		json
+//	@Param			tokenId	path		int		true	"Provisioning Token ID"
+//	@Success		204		{string}	string	"Token revoked"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/provisioning-tokens/{tokenId} [delete]
+func (app *application) revokeProvisioningTokenHandler(w http.ResponseWriter, r *http.Request) {
+	tokenID, err := strconv.ParseInt(chi.URLParam(r, "tokenId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.ProvisioningTokens.Revoke(r.Context(), tokenID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// either the password or a second factor confirms the user again
+type ReauthenticatePayload struct {
+	Password     string `json:"password" validate:"required_without_all=Code RecoveryCode,max=256"`
+	Code         string `json:"code" validate:"omitempty,numeric,max=10"`
+	RecoveryCode string `json:"recovery_code" validate:"omitempty,max=32"`
+}
+
+// Reauthenticate godoc
+//
+//	@Summary		Confirm the login again
+//	@Description	Checks the password or a 2FA code and returns an access token with a fresh auth_time, sensitive routes answer with the code reauthentication_required until this is done
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ReauthenticatePayload	true	"Password, TOTP or Recovery Code"
+//	@Success		201		{object}	TokenPair				"Access Token"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/authentication/reauth [post]
+func (app *application) reauthenticateHandler(w http.ResponseWriter, r *http.Request) {
+	authUser := getAuthUserFromCtx(r)
+
+	var payload ReauthenticatePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// a stolen session must not be a way around the login throttling
+	if !app.startLoginAttempt(w, r, authUser.Email) {
+		return
+	}
+
+	ctx := r.Context()
+	if payload.Password != "" {
+		// the user in the context is loaded without the password hash
+		user, err := app.store.Users.GetByEmail(ctx, authUser.Email)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+
+		if err := user.Password.Compare(payload.Password); err != nil {
+			app.recordLoginFailure(r, user.Email, user)
+			app.unauthorizedError(w, r, err)
+			return
+		}
+	} else {
+		twoFactor, err := app.store.TwoFactor.GetByUserID(ctx, authUser.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.badRequestError(w, r, fmt.Errorf("two factor authentication is not enabled"))
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		if !twoFactor.Enabled {
+			app.badRequestError(w, r, fmt.Errorf("two factor authentication is not enabled"))
+			return
+		}
+
+		if err := app.verifySecondFactor(ctx, twoFactor, payload.Code, payload.RecoveryCode); err != nil {
+			if errors.Is(err, errInvalidTwoFactorCode) {
+				app.recordLoginFailure(r, authUser.Email, authUser)
+			}
+			app.twoFactorError(w, r, err)
+			return
+		}
+	}
+	app.resetLoginFailures(r, authUser.Email)
+
+	sessionID, _ := getAuthClaimsFromCtx(r)["sid"].(string)
+	session, err := app.store.Sessions.GetByID(ctx, sessionID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	session.AuthTime = time.Now()
+	if err := app.store.Sessions.Reauthenticate(ctx, session.ID, session.AuthTime); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	accessToken, err := app.generateAccessToken(authUser, session)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// answer the way the request was authenticated, the refresh token stays
+	tokens := &TokenPair{Token: accessToken}
+	if _, fromCookie, _ := app.readAccessToken(r); fromCookie {
+		http.SetCookie(w, app.newCookie(accessTokenCookie, accessToken, "/", app.config.auth.token.exp, true))
+		tokens.Token = ""
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// only lets the request through when the user confirmed their login within
+// maxAge, has to run after AuthTokenMiddleware
+func (app *application) RequireRecentAuth(maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authTime, ok := authTimeFromClaims(getAuthClaimsFromCtx(r))
+			if !ok || time.Since(authTime) > maxAge {
+				app.reauthenticationRequiredResponse(w, r, maxAge)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// reads the auth_time claim, tokens issued before it existed have none
+func authTimeFromClaims(claims jwt.MapClaims) (time.Time, bool) {
+	var seconds float64
+	switch value := claims["auth_time"].(type) {
+	case float64:
+		seconds = value
+	case json.Number:
+		parsed, err := value.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		seconds = parsed
+	default:
+		return time.Time{}, false
+	}
+
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)), true
+}
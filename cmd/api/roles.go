@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// permissions as seeded in the permissions table
+const (
+	permissionPostsDeleteAny    = "posts:delete:any"
+	permissionCommentsDeleteAny = "comments:delete:any"
+	permissionRolesManage       = "roles:manage"
+)
+
+type GrantRolePayload struct {
+	Role string `json:"role" validate:"required,max=50"`
+}
+
+// ListRoles godoc
+//
+//	@Summary		List roles
+//	@Description	Lists the roles with the permissions they grant
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.Role
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/roles [get]
+func (app *application) listRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := app.store.Roles.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, roles); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// GrantRole godoc
+//
+//	@Summary		Grant a role
+//	@Description	Gives the user the role, replacing the one it had
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			userId	path		int					true	"User ID"
+//	@Param			payload	body		GrantRolePayload	true	"Role"
+//	@Success		204		{string}	string				"Role granted"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userId}/role [put]
+func (app *application) grantRoleHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readGrantedRole(w, r)
+	if !ok {
+		return
+	}
+
+	app.setUserRole(w, r, role, getAuthUserFromCtx(r).ID)
+}
+
+// RevokeRole godoc
+//
+//	@Summary		Revoke a role
+//	@Description	Puts the user back to the default role
+//	@Tags			admin
+//	@Produce		json
+//	@Param			userId	path		int		true	"User ID"
+//	@Success		204		{string}	string	"Role revoked"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		403		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/admin/users/{userId}/role [delete]
+func (app *application) revokeRoleHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserRole(w, r, store.DefaultRole, getAuthUserFromCtx(r).ID)
+}
+
+// GrantRoleAsOperator godoc
+//
+//	@Summary		Grant a role as operator
+//	@Description	Gives the user the role, replacing the one it had. This is how the first user gets roles:manage
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			userId	path		int					true	"User ID"
+//	@Param			payload	body		GrantRolePayload	true	"Role"
+//	@Success		204		{string}	string				"Role granted"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/operator/users/{userId}/role [put]
+func (app *application) grantRoleAsOperatorHandler(w http.ResponseWriter, r *http.Request) {
+	role, ok := app.readGrantedRole(w, r)
+	if !ok {
+		return
+	}
+
+	app.setUserRole(w, r, role, 0)
+}
+
+// RevokeRoleAsOperator godoc
+//
+//	@Summary		Revoke a role as operator
+//	@Description	Puts the user back to the default role
+//	@Tags			admin
+//	@Produce		json
+//	@Param			userId	path		int		true	"User ID"
+//	@Success		204		{string}	string	"Role revoked"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/operator/users/{userId}/role [delete]
+func (app *application) revokeRoleAsOperatorHandler(w http.ResponseWriter, r *http.Request) {
+	app.setUserRole(w, r, store.DefaultRole, 0)
+}
+
+func (app *application) readGrantedRole(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var payload GrantRolePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return "", false
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return "", false
+	}
+	return payload.Role, true
+}
+
+// changes the role of the user in the url, actorID is the user making the
+// change or 0 for an operator, who has no user account to lock out
+func (app *application) setUserRole(w http.ResponseWriter, r *http.Request, role string, actorID int64) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	// an admin taking away their own role could leave nobody to grant it back
+	if actorID != 0 && userID == actorID {
+		app.forbiddenResponse(w, r, fmt.Errorf("you can not change your own role"))
+		return
+	}
+
+	if err := app.store.Roles.SetUserRole(r.Context(), userID, role); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
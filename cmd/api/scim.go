@@ -0,0 +1,730 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+const (
+	scimUserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimPatchOpSchema      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimConfigSchema       = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimResourceTypeSchema = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	scimSchemaSchema       = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+)
+
+// page size when the provider asks for none, and the most it gets at once
+const scimMaxResults = 100
+
+var errSCIMUserNotFound = errors.New("user not found")
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+// SCIMUser is store.User in the SCIM core schema. Providers send attributes we
+// don't store, like name or locale, those are ignored
+type SCIMUser struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id,omitempty"`
+	ExternalID string      `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Emails     []SCIMEmail `json:"emails,omitempty"`
+	Active     *bool       `json:"active,omitempty"`
+	Meta       *SCIMMeta   `json:"meta,omitempty"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string   `json:"schemas"`
+	TotalResults int64      `json:"totalResults"`
+	StartIndex   int        `json:"startIndex"`
+	ItemsPerPage int        `json:"itemsPerPage"`
+	Resources    []SCIMUser `json:"Resources"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+func newSCIMUser(user *store.User) SCIMUser {
+	id := strconv.FormatInt(user.ID, 10)
+	active := user.IsActive
+
+	scimUser := SCIMUser{
+		Schemas:  []string{scimUserSchema},
+		ID:       id,
+		UserName: user.Username,
+		Emails:   []SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active:   &active,
+		Meta: &SCIMMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     "/scim/v2/Users/" + id,
+		},
+	}
+	if user.ExternalID != nil {
+		scimUser.ExternalID = *user.ExternalID
+	}
+	return scimUser
+}
+
+// the primary email, or the first one when none is marked
+func primarySCIMEmail(emails []SCIMEmail) string {
+	for _, email := range emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// the provider decides whether the account can be used, the moment it was
+// switched off tells it apart from an account that was never activated
+func setProvisionedActive(user *store.User, active bool) {
+	user.IsActive = active
+	switch {
+	case active:
+		user.DeactivatedAt = nil
+	case user.DeactivatedAt == nil:
+		now := time.Now()
+		user.DeactivatedAt = &now
+	}
+}
+
+func validateProvisionedUser(user *store.User) error {
+	if err := Validate.Var(user.Username, "required,max=255"); err != nil {
+		return fmt.Errorf("userName: %w", err)
+	}
+	if err := Validate.Var(user.Email, "required,email,max=255"); err != nil {
+		return fmt.Errorf("emails: %w", err)
+	}
+	if user.ExternalID != nil {
+		if err := Validate.Var(*user.ExternalID, "max=255"); err != nil {
+			return fmt.Errorf("externalId: %w", err)
+		}
+	}
+	return nil
+}
+
+// SCIMCreateUser godoc
+//
+//	@Summary		Provision a user
+//	@Description	Creates an account for the identity provider, it needs no activation and signs in through SSO or a password reset
+//	@Tags			scim
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		SCIMUser	true	"SCIM User"
+//	@Success		201		{object}	SCIMUser
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/scim/v2/Users [post]
+func (app *application) scimCreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var payload SCIMUser
+	if err := readSCIM(w, r, &payload); err != nil {
+		app.scimError(w, r, http.StatusBadRequest, "invalidSyntax", err)
+		return
+	}
+
+	user := &store.User{
+		Username: payload.UserName,
+		Email:    primarySCIMEmail(payload.Emails),
+	}
+	// most providers use the email as userName and may send no emails
+	if user.Email == "" && strings.Contains(payload.UserName, "@") {
+		user.Email = payload.UserName
+	}
+	if payload.ExternalID != "" {
+		user.ExternalID = &payload.ExternalID
+	}
+	setProvisionedActive(user, payload.Active == nil || *payload.Active)
+
+	if err := validateProvisionedUser(user); err != nil {
+		app.scimError(w, r, http.StatusBadRequest, "invalidValue", err)
+		return
+	}
+
+	if !app.checkSCIMEmailPolicy(w, r, user.Email, "") {
+		return
+	}
+
+	// passwords stay with the provider, the account can't be used with one
+	// until the user resets it
+	if err := setRandomPassword(user); err != nil {
+		app.scimError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	token := getProvisioningTokenFromCtx(r)
+	if err := app.store.Users.CreateProvisioned(r.Context(), user, token.ID); err != nil {
+		app.scimStoreError(w, r, err)
+		return
+	}
+
+	app.writeSCIM(w, r, http.StatusCreated, newSCIMUser(user))
+}
+
+// SCIMGetUser godoc
+//
+//	@Summary		Fetch a provisioned user
+//	@Description	Returns a user the identity provider created in the SCIM core schema whether or not the account is active
+//	@Tags			scim
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	SCIMUser
+//	@Failure		401	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/scim/v2/Users/{id} [get]
+func (app *application) scimGetUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadSCIMUser(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeSCIM(w, r, http.StatusOK, newSCIMUser(user))
+}
+
+// SCIMListUsers godoc
+//
+//	@Summary		List provisioned users
+//	@Description	Lists the users the identity provider created, the filter supports eq on userName, emails, emails.value, externalId and active
+//	@Tags			scim
+//	@Produce		json
+//	@Param			filter		query		string	false	"Filter, e.g. userName eq \"jane\""
+//	@Param			startIndex	query		int		false	"1-based index of the first result"
+//	@Param			count		query		int		false	"Page size, at most 100"
+//	@Success		200			{object}	SCIMListResponse
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/scim/v2/Users [get]
+func (app *application) scimListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := parseSCIMFilter(query.Get("filter"))
+	if err != nil {
+		app.scimError(w, r, http.StatusBadRequest, "invalidFilter", err)
+		return
+	}
+
+	// out of range values are clamped as RFC 7644 asks
+	startIndex := 1
+	if value := query.Get("startIndex"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			app.scimError(w, r, http.StatusBadRequest, "invalidValue", err)
+			return
+		}
+		startIndex = max(parsed, 1)
+	}
+
+	count := scimMaxResults
+	if value := query.Get("count"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			app.scimError(w, r, http.StatusBadRequest, "invalidValue", err)
+			return
+		}
+		count = min(max(parsed, 0), scimMaxResults)
+	}
+
+	users, total, err := app.store.Users.ListProvisioned(r.Context(), filter, startIndex-1, count)
+	if err != nil {
+		app.scimError(w, r, http.StatusInternalServerError, "", err)
+		return
+	}
+
+	response := SCIMListResponse{
+		Schemas:      []string{scimListResponseSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    make([]SCIMUser, 0, len(users)),
+	}
+	for _, user := range users {
+		response.Resources = append(response.Resources, newSCIMUser(user))
+	}
+	app.writeSCIM(w, r, http.StatusOK, response)
+}
+
+// SCIMPatchUser godoc
+//
+//	@Summary		Update a provisioned user
+//	@Description	Applies add, replace and remove operations to userName, emails, externalId and active of a user the identity provider created. Deactivating signs the user out everywhere
+//	@Tags			scim
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"User ID"
+//	@Param			payload	body		SCIMPatchRequest	true	"SCIM PatchOp"
+//	@Success		200		{object}	SCIMUser
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/scim/v2/Users/{id} [patch]
+func (app *application) scimPatchUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := app.loadSCIMUser(w, r)
+	if !ok {
+		return
+	}
+	currentEmail := user.Email
+
+	var payload SCIMPatchRequest
+	if err := readSCIM(w, r, &payload); err != nil {
+		app.scimError(w, r, http.StatusBadRequest, "invalidSyntax", err)
+		return
+	}
+
+	if !slices.Contains(payload.Schemas, scimPatchOpSchema) {
+		app.scimError(w, r, http.StatusBadRequest, "invalidSyntax", fmt.Errorf("the request is not a PatchOp"))
+		return
+	}
+
+	if len(payload.Operations) == 0 {
+		app.scimError(w, r, http.StatusBadRequest, "invalidSyntax", fmt.Errorf("no operations"))
+		return
+	}
+
+	for _, operation := range payload.Operations {
+		if err := applySCIMPatch(user, operation); err != nil {
+			app.scimError(w, r, http.StatusBadRequest, "invalidValue", err)
+			return
+		}
+	}
+
+	if err := validateProvisionedUser(user); err != nil {
+		app.scimError(w, r, http.StatusBadRequest, "invalidValue", err)
+		return
+	}
+
+	if !strings.EqualFold(user.Email, currentEmail) && !app.checkSCIMEmailPolicy(w, r, user.Email, currentEmail) {
+		return
+	}
+
+	if err := app.store.Users.UpdateProvisioned(r.Context(), user); err != nil {
+		app.scimStoreError(w, r, err)
+		return
+	}
+
+	app.writeSCIM(w, r, http.StatusOK, newSCIMUser(user))
+}
+
+// SCIMDeleteUser godoc
+//
+//	@Summary		Deprovision a user
+//	@Description	Deletes an account the identity provider created with its posts and comments, to keep them deactivate the user instead
+//	@Tags			scim
+//	@Produce		json
+//	@Param			id	path		int		true	"User ID"
+//	@Success		204	{string}	string	"User deleted"
+//	@Failure		401	{object}	error
+//	@Failure		404	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/scim/v2/Users/{id} [delete]
+func (app *application) scimDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.scimError(w, r, http.StatusNotFound, "", errSCIMUserNotFound)
+		return
+	}
+
+	if err := app.store.Users.DeleteProvisioned(r.Context(), userID); err != nil {
+		app.scimStoreError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkChangedEmailPolicy with the answers in the SCIM error format
+func (app *application) checkSCIMEmailPolicy(w http.ResponseWriter, r *http.Request, email, currentEmail string) bool {
+	violation, err := app.emailPolicyViolation(r.Context(), email, currentEmail)
+	switch {
+	case err != nil:
+		app.scimError(w, r, http.StatusInternalServerError, "", err)
+	case violation != nil && violation.Code == emailDuplicateCode:
+		app.scimError(w, r, http.StatusConflict, "uniqueness", violation)
+	case violation != nil:
+		app.scimError(w, r, http.StatusBadRequest, "invalidValue", fmt.Errorf("emails: %w", violation))
+	default:
+		return true
+	}
+	return false
+}
+
+// loads the user of the path, answers with 404 when there is none or the
+// identity provider didn't create it
+func (app *application) loadSCIMUser(w http.ResponseWriter, r *http.Request) (*store.User, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "userId"), 10, 64)
+	if err != nil {
+		app.scimError(w, r, http.StatusNotFound, "", errSCIMUserNotFound)
+		return nil, false
+	}
+
+	user, err := app.store.Users.GetProvisioned(r.Context(), userID)
+	if err != nil {
+		app.scimStoreError(w, r, err)
+		return nil, false
+	}
+	return user, true
+}
+
+// matches `attribute eq value`, the only operator providers use to look users up
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z][a-z0-9.]*)\s+eq\s+(.+?)\s*$`)
+
+func parseSCIMFilter(filter string) (store.UserFilter, error) {
+	var userFilter store.UserFilter
+	if filter == "" {
+		return userFilter, nil
+	}
+
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return userFilter, fmt.Errorf("unsupported filter %q", filter)
+	}
+	attribute, rawValue := strings.ToLower(match[1]), match[2]
+
+	if attribute == "active" {
+		active, err := strconv.ParseBool(rawValue)
+		if err != nil {
+			return userFilter, fmt.Errorf("active has to be compared with true or false")
+		}
+		userFilter.Active = &active
+		return userFilter, nil
+	}
+
+	var value string
+	if err := json.Unmarshal([]byte(rawValue), &value); err != nil {
+		return userFilter, fmt.Errorf("%s has to be compared with a quoted string", match[1])
+	}
+	// an empty value would turn into no filter at all
+	if value == "" {
+		return userFilter, fmt.Errorf("%s can't be compared with an empty string", match[1])
+	}
+
+	switch attribute {
+	case "username":
+		userFilter.Username = value
+	case "emails", "emails.value":
+		userFilter.Email = value
+	case "externalid":
+		userFilter.ExternalID = value
+	default:
+		return userFilter, fmt.Errorf("filtering by %s is not supported", match[1])
+	}
+	return userFilter, nil
+}
+
+// applies one operation of a PatchOp, attributes we don't store are ignored
+func applySCIMPatch(user *store.User, operation SCIMPatchOperation) error {
+	switch strings.ToLower(operation.Op) {
+	case "add", "replace":
+		// without a path the value holds the attributes to set
+		if operation.Path == "" {
+			var attributes map[string]json.RawMessage
+			if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+				return fmt.Errorf("the value of an operation without path has to be an object")
+			}
+			for path, value := range attributes {
+				if err := setSCIMAttribute(user, path, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return setSCIMAttribute(user, operation.Path, operation.Value)
+	case "remove":
+		switch strings.ToLower(operation.Path) {
+		case "externalid":
+			user.ExternalID = nil
+			return nil
+		case "username", "emails", "active":
+			return fmt.Errorf("%s is required and can't be removed", operation.Path)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported patch operation %q", operation.Op)
+	}
+}
+
+func setSCIMAttribute(user *store.User, path string, value json.RawMessage) error {
+	lowerPath := strings.ToLower(path)
+	switch {
+	case lowerPath == "active":
+		active, err := decodeSCIMBool(value)
+		if err != nil {
+			return fmt.Errorf("active: %w", err)
+		}
+		setProvisionedActive(user, active)
+	case lowerPath == "username":
+		if err := json.Unmarshal(value, &user.Username); err != nil {
+			return fmt.Errorf("userName: %w", err)
+		}
+	case lowerPath == "externalid":
+		var externalID string
+		if err := json.Unmarshal(value, &externalID); err != nil {
+			return fmt.Errorf("externalId: %w", err)
+		}
+		user.ExternalID = &externalID
+		if externalID == "" {
+			user.ExternalID = nil
+		}
+	case lowerPath == "emails":
+		var emails []SCIMEmail
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return fmt.Errorf("emails: %w", err)
+		}
+		if email := primarySCIMEmail(emails); email != "" {
+			user.Email = email
+		}
+	// emails.value or a value path like emails[type eq "work"].value, we keep one email
+	case strings.HasPrefix(lowerPath, "emails") && strings.HasSuffix(lowerPath, ".value"):
+		if err := json.Unmarshal(value, &user.Email); err != nil {
+			return fmt.Errorf("emails: %w", err)
+		}
+	}
+	return nil
+}
+
+// some providers send booleans as the strings "True" and "False"
+func decodeSCIMBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, fmt.Errorf("not a boolean")
+	}
+	return strconv.ParseBool(strings.ToLower(s))
+}
+
+// SCIMServiceProviderConfig godoc
+//
+//	@Summary		SCIM service provider configuration
+//	@Description	Tells identity providers which SCIM features are supported
+//	@Tags			scim
+//	@Produce		json
+//	@Success		200	{object}	map[string]any
+//	@Router			/scim/v2/ServiceProviderConfig [get]
+func (app *application) scimServiceProviderConfigHandler(w http.ResponseWriter, r *http.Request) {
+	unsupported := map[string]bool{"supported": false}
+	config := map[string]any{
+		"schemas": []string{scimConfigSchema},
+		"patch":   map[string]bool{"supported": true},
+		"filter": map[string]any{
+			"supported":  true,
+			"maxResults": scimMaxResults,
+		},
+		"bulk": map[string]any{
+			"supported":      false,
+			"maxOperations":  0,
+			"maxPayloadSize": 0,
+		},
+		"changePassword": unsupported,
+		"sort":           unsupported,
+		"etag":           unsupported,
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Provisioning Token",
+			"description": "A bearer token created by an admin under /v1/admin/provisioning-tokens",
+			"primary":     true,
+		}},
+		"meta": map[string]string{
+			"resourceType": "ServiceProviderConfig",
+			"location":     "/scim/v2/ServiceProviderConfig",
+		},
+	}
+	app.writeSCIM(w, r, http.StatusOK, config)
+}
+
+var scimUserResourceType = map[string]any{
+	"schemas":     []string{scimResourceTypeSchema},
+	"id":          "User",
+	"name":        "User",
+	"endpoint":    "/Users",
+	"description": "User Account",
+	"schema":      scimUserSchema,
+	"meta": map[string]string{
+		"resourceType": "ResourceType",
+		"location":     "/scim/v2/ResourceTypes/User",
+	},
+}
+
+// SCIMResourceTypes godoc
+//
+//	@Summary		SCIM resource types
+//	@Description	Lists the resource types, only User is supported
+//	@Tags			scim
+//	@Produce		json
+//	@Success		200	{object}	map[string]any
+//	@Router			/scim/v2/ResourceTypes [get]
+func (app *application) scimResourceTypesHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeSCIMList(w, r, []any{scimUserResourceType})
+}
+
+func scimAttribute(name, attributeType string, required bool, mutability, uniqueness string) map[string]any {
+	return map[string]any{
+		"name":        name,
+		"type":        attributeType,
+		"multiValued": false,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    "default",
+		"uniqueness":  uniqueness,
+	}
+}
+
+var scimUserSchemaDefinition = map[string]any{
+	"schemas":     []string{scimSchemaSchema},
+	"id":          scimUserSchema,
+	"name":        "User",
+	"description": "User Account",
+	"attributes": []map[string]any{
+		scimAttribute("userName", "string", true, "readWrite", "server"),
+		scimAttribute("externalId", "string", false, "readWrite", "server"),
+		scimAttribute("active", "boolean", false, "readWrite", "none"),
+		{
+			"name":        "emails",
+			"type":        "complex",
+			"multiValued": true,
+			"required":    true,
+			"mutability":  "readWrite",
+			"returned":    "default",
+			"uniqueness":  "server",
+			"subAttributes": []map[string]any{
+				scimAttribute("value", "string", true, "readWrite", "server"),
+				scimAttribute("type", "string", false, "readWrite", "none"),
+				scimAttribute("primary", "boolean", false, "readWrite", "none"),
+			},
+		},
+	},
+	"meta": map[string]string{
+		"resourceType": "Schema",
+		"location":     "/scim/v2/Schemas/" + scimUserSchema,
+	},
+}
+
+// SCIMSchemas godoc
+//
+//	@Summary		SCIM schemas
+//	@Description	Describes the attributes of the User resource that are stored
+//	@Tags			scim
+//	@Produce		json
+//	@Success		200	{object}	map[string]any
+//	@Router			/scim/v2/Schemas [get]
+func (app *application) scimSchemasHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeSCIMList(w, r, []any{scimUserSchemaDefinition})
+}
+
+func (app *application) writeSCIMList(w http.ResponseWriter, r *http.Request, resources []any) {
+	app.writeSCIM(w, r, http.StatusOK, map[string]any{
+		"schemas":      []string{scimListResponseSchema},
+		"totalResults": len(resources),
+		"startIndex":   1,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	})
+}
+
+// SCIM bodies are sent as application/scim+json without our data envelope
+func (app *application) writeSCIM(w http.ResponseWriter, r *http.Request, status int, data any) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		app.logger.Errorw("writing scim response failed", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	}
+}
+
+// unlike readJson unknown fields are fine, providers send whole resources
+func readSCIM(w http.ResponseWriter, r *http.Request, data any) error {
+	maxBytes := 1_048_578 // 1MB
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	return json.NewDecoder(r.Body).Decode(data)
+}
+
+// answers in the SCIM error format, scimType is empty when none applies
+func (app *application) scimError(w http.ResponseWriter, r *http.Request, status int, scimType string, err error) {
+	detail := err.Error()
+	if status >= http.StatusInternalServerError {
+		app.logger.Errorw("internal error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+		detail = "server encountered an error"
+	} else {
+		app.logger.Warnw("scim request failed", "method", r.Method, "path", r.URL.Path, "status", status, "error", err.Error())
+	}
+
+	type envelop struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType,omitempty"`
+		Detail   string   `json:"detail"`
+	}
+	app.writeSCIM(w, r, status, &envelop{
+		Schemas:  []string{scimErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+func (app *application) scimUnauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="scim"`)
+	app.scimError(w, r, http.StatusUnauthorized, "", err)
+}
+
+// maps the errors of the user store onto SCIM responses
+func (app *application) scimStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		app.scimError(w, r, http.StatusNotFound, "", errSCIMUserNotFound)
+	case errors.Is(err, store.ErrDuplicateEmail),
+		errors.Is(err, store.ErrDuplicateUsername),
+		errors.Is(err, store.ErrDuplicateExternal):
+		app.scimError(w, r, http.StatusConflict, "uniqueness", err)
+	default:
+		app.scimError(w, r, http.StatusInternalServerError, "", err)
+	}
+}
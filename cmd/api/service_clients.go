@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/harshvse/go-api/internal/signing"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// key ids are told apart from other credentials by this prefix
+const serviceKeyIDPrefix = "svc_"
+
+// signed bodies are read before the handler runs, the same limit as readJson
+const maxSignedBodyBytes = 1_048_578
+
+// verifies requests signed with the key of a service client, see the signing
+// package for the scheme. Each nonce is accepted once while its timestamp is
+// within the allowed clock skew
+func (app *application) ServiceAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signature, err := signing.Parse(r)
+		if err != nil {
+			app.unauthorizedError(w, r, err)
+			return
+		}
+
+		clockSkew := app.config.auth.service.clockSkew
+		if skew := time.Since(signature.Timestamp); skew > clockSkew || skew < -clockSkew {
+			app.unauthorizedError(w, r, fmt.Errorf("signature timestamp is off by %s", skew.Round(time.Second)))
+			return
+		}
+
+		ctx := r.Context()
+		client, err := app.store.ServiceClients.GetByKeyID(ctx, signature.KeyID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.unauthorizedError(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		// the body is part of the signature, put it back for the handler
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodyBytes))
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if err := signature.Verify(r, body, client.Secret); err != nil {
+			app.unauthorizedError(w, r, err)
+			return
+		}
+
+		// only checked once the signature holds so nobody can burn the nonces of a client
+		if err := app.store.ServiceClients.UseNonce(ctx, client.ID, signature.Nonce, signature.Timestamp.Add(clockSkew)); err != nil {
+			switch {
+			case errors.Is(err, store.ErrNonceReused):
+				app.unauthorizedError(w, r, err)
+			default:
+				app.internalServerError(w, r, err)
+			}
+			return
+		}
+
+		ctx = context.WithValue(ctx, authServiceClientCtx, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type CreateServiceClientPayload struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type ServiceClientWithSecret struct {
+	*store.ServiceClient
+	Secret string `json:"secret"`
+}
+
+// ListServiceClients godoc
+//
+//	@Summary		List service clients
+//	@Description	Lists the clients internal services sign their requests with, revoked ones included
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{array}		store.ServiceClient
+//	@Failure		401	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/service-clients [get]
+func (app *application) listServiceClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := app.store.ServiceClients.List(r.Context())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, clients); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateServiceClient godoc
+//
+//	@Summary		Create a service client
+//	@Description	Creates a key id and secret for an internal service, the secret is only shown in this response
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		CreateServiceClientPayload	true	"Name of the service"
+//	@Success		201		{object}	ServiceClientWithSecret
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/service-clients [post]
+func (app *application) createServiceClientHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateServiceClientPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	keyID := make([]byte, 12)
+	if _, err := rand.Read(keyID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	client := &store.ServiceClient{
+		KeyID:  serviceKeyIDPrefix + base64.RawURLEncoding.EncodeToString(keyID),
+		Name:   payload.Name,
+		Secret: base64.RawURLEncoding.EncodeToString(secret),
+	}
+	if err := app.store.ServiceClients.Create(r.Context(), client); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := ServiceClientWithSecret{
+		ServiceClient: client,
+		Secret:        client.Secret,
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, response); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RevokeServiceClient godoc
+//
+//	@Summary		Revoke a service client
+//	@Description	Stops accepting requests signed with the key of the client
+//	@Tags			admin
+//	@Produce		json
+//	@Param			clientId	path		int		true	"Service Client ID"
+//	@Success		204			{string}	string	"Client revoked"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		BasicAuth
+//	@Router			/admin/service-clients/{clientId} [delete]
+func (app *application) revokeServiceClientHandler(w http.ResponseWriter, r *http.Request) {
+	clientID, err := strconv.ParseInt(chi.URLParam(r, "clientId"), 10, 64)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.ServiceClients.Revoke(r.Context(), clientID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harshvse/go-api/internal/signing"
+	"github.com/harshvse/go-api/internal/store"
+	"go.uber.org/zap"
+)
+
+const (
+	testServiceKeyID  = "svc_batch"
+	testServiceSecret = "batch secret"
+)
+
+func newServiceAuthTest() http.Handler {
+	app := &application{
+		config: config{
+			auth: authConfig{service: serviceAuthConfig{clockSkew: time.Minute * 5}},
+		},
+		store: store.Storage{
+			ServiceClients: &fakeServiceClientStore{
+				clients: []*store.ServiceClient{{ID: 1, KeyID: testServiceKeyID, Secret: testServiceSecret}},
+				nonces:  make(map[string]time.Time),
+			},
+		},
+		logger: zap.NewNop().Sugar(),
+	}
+
+	return app.ServiceAuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// signs the request like signing.Signer, but with the timestamp and nonce of the test
+func newServiceRequest(body string, timestamp time.Time, nonce string) *http.Request {
+	r := httptest.NewRequest(http.MethodDelete, "/v1/service/users/unactivated", strings.NewReader(body))
+
+	rawTimestamp := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testServiceSecret))
+	mac.Write([]byte(signing.CanonicalRequest(r.Method, r.URL, rawTimestamp, nonce, []byte(body))))
+
+	r.Header.Set(signing.HeaderKeyID, testServiceKeyID)
+	r.Header.Set(signing.HeaderTimestamp, rawTimestamp)
+	r.Header.Set(signing.HeaderNonce, nonce)
+	r.Header.Set(signing.HeaderSignature, hex.EncodeToString(mac.Sum(nil)))
+	return r
+}
+
+func TestServiceAuthRejectsReusedNonce(t *testing.T) {
+	handler := newServiceAuthTest()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newServiceRequest("", time.Now(), "nonce-1"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("first request: status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+
+	// the exact same request again is a replay
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newServiceRequest("", time.Now(), "nonce-1"))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed request: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, newServiceRequest("", time.Now(), "nonce-2"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("request with a new nonce: status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+}
+
+func TestServiceAuthRejectsTimestampOutsideTheSkew(t *testing.T) {
+	handler := newServiceAuthTest()
+
+	for _, offset := range []time.Duration{-time.Minute * 6, time.Minute * 6} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newServiceRequest("", time.Now().Add(offset), "nonce-"+offset.String()))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("timestamp %s off: status = %d, want %d", offset, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newServiceRequest("", time.Now().Add(-time.Minute*4), "nonce-late"))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("timestamp within the skew: status = %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+}
+
+func TestServiceAuthRejectsTamperedBody(t *testing.T) {
+	handler := newServiceAuthTest()
+
+	r := newServiceRequest(`{"older_than":"720h"}`, time.Now(), "nonce-1")
+	tampered := httptest.NewRequest(r.Method, r.URL.String(), strings.NewReader(`{"older_than":"1h"}`))
+	tampered.Header = r.Header
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, tampered)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+type SessionResponse struct {
+	*store.Session
+	Current bool `json:"current"`
+}
+
+// ListSessions godoc
+//
+//	@Summary		List sessions
+//	@Description	Lists the devices the current user is signed in on, the session of the request is marked as current
+//	@Tags			sessions
+//	@Produce		json
+//	@Success		200	{array}		SessionResponse
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions [get]
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+	currentID, _ := getAuthClaimsFromCtx(r)["sid"].(string)
+
+	sessions, err := app.store.Sessions.ListActiveByUserID(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			Session: session,
+			Current: session.ID == currentID,
+		})
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, response); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RevokeSession godoc
+//
+//	@Summary		Sign out a session
+//	@Description	Ends a session of the current user, its access and refresh tokens stop working
+//	@Tags			sessions
+//	@Produce		json
+//	@Param			sessionId	path		string	true	"Session ID"
+//	@Success		204			{string}	string	"Session revoked"
+//	@Failure		400			{object}	error
+//	@Failure		401			{object}	error
+//	@Failure		403			{object}	error
+//	@Failure		404			{object}	error
+//	@Failure		500			{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/sessions/{sessionId} [delete]
+func (app *application) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	sessionID, err := uuid.Parse(chi.URLParam(r, "sessionId"))
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := app.store.Sessions.Revoke(r.Context(), user.ID, sessionID.String()); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// the token_use claim keeps tokens for different purposes apart, only access
+// tokens are accepted by AuthTokenMiddleware
+const (
+	accessTokenUse    = "access"
+	twoFactorTokenUse = "2fa"
+	magicLinkTokenUse = "magic_link"
+)
+
+// clients using cookies only get the csrf token in the body
+type TokenPair struct {
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	CSRFToken    string `json:"csrf_token,omitempty"`
+}
+
+// tokens sent by email or handed to clients are only stored as their sha256 hash
+func hashToken(plainToken string) string {
+	hash := sha256.Sum256([]byte(plainToken))
+	return hex.EncodeToString(hash[:])
+}
+
+func (app *application) generateAccessToken(user *store.User, session *store.Session) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":       strconv.FormatInt(user.ID, 10),
+		"sid":       session.ID,
+		"auth_time": session.AuthTime.Unix(),
+		"jti":       uuid.New().String(),
+		"token_use": accessTokenUse,
+		"exp":       now.Add(app.config.auth.token.exp).Unix(),
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"iss":       app.config.auth.token.iss,
+		"aud":       app.config.auth.token.iss,
+	}
+	return app.authenticator.GenerateToken(claims)
+}
+
+// creates a fresh access token and a refresh token for the session of the
+// user, the session id is the family of the refresh token
+func (app *application) issueTokens(user *store.User, session *store.Session) (*TokenPair, *store.RefreshToken, error) {
+	accessToken, err := app.generateAccessToken(user, session)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plainRefreshToken := uuid.New().String()
+	refreshToken := &store.RefreshToken{
+		Token:    hashToken(plainRefreshToken),
+		UserID:   user.ID,
+		FamilyID: session.ID,
+		Expiry:   time.Now().Add(app.config.auth.token.refreshExp),
+	}
+
+	return &TokenPair{
+		Token:        accessToken,
+		RefreshToken: plainRefreshToken,
+	}, refreshToken, nil
+}
+
+// starts a new session for the device that logged in and sends its tokens
+func (app *application) respondWithNewTokens(w http.ResponseWriter, r *http.Request, user *store.User) {
+	now := time.Now()
+	session := &store.Session{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+		AuthTime:  now,
+		Expiry:    now.Add(app.config.auth.token.refreshExp),
+	}
+
+	tokens, refreshToken, err := app.issueTokens(user, session)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.Sessions.Create(r.Context(), session, refreshToken); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.writeTokens(w, r, tokens, app.wantsCookies(r))
+}
+
+// sends the tokens in the body or, for browser clients, as cookies
+func (app *application) writeTokens(w http.ResponseWriter, r *http.Request, tokens *TokenPair, asCookies bool) {
+	if asCookies {
+		if err := app.setAuthCookies(w, tokens); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, tokens); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// removes revoked token entries once the tokens they block have expired,
+// failed login counters that no longer count, nonces of signed requests
+// that can't be replayed anymore and passkey challenges and oauth states of
+// flows nobody finished
+func (app *application) pruneExpiredRecords(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := app.store.RevokedTokens.DeleteExpired(ctx)
+			if err != nil {
+				app.logger.Errorw("pruning revoked tokens failed", "error", err.Error())
+			} else {
+				app.logger.Infow("pruned revoked tokens", "deleted", deleted)
+			}
+
+			now := time.Now()
+			// the longest window of every policy counting attempts in the table
+			lockoutConfig := app.config.lockout
+			window := max(lockoutConfig.account.Window, lockoutConfig.ip.Window, lockoutConfig.admin.Window, app.twoFactorTokenPolicy().Window)
+			deleted, err = app.store.LoginAttempts.DeleteStale(ctx, now, now.Add(-window))
+			if err != nil {
+				app.logger.Errorw("pruning login attempts failed", "error", err.Error())
+			} else {
+				app.logger.Infow("pruned login attempts", "deleted", deleted)
+			}
+
+			deleted, err = app.store.ServiceClients.DeleteExpiredNonces(ctx)
+			if err != nil {
+				app.logger.Errorw("pruning service request nonces failed", "error", err.Error())
+			} else {
+				app.logger.Infow("pruned service request nonces", "deleted", deleted)
+			}
+
+			deleted, err = app.store.Passkeys.DeleteExpiredChallenges(ctx)
+			if err != nil {
+				app.logger.Errorw("pruning passkey challenges failed", "error", err.Error())
+			} else {
+				app.logger.Infow("pruned passkey challenges", "deleted", deleted)
+			}
+
+			deleted, err = app.store.Identities.DeleteExpiredStates(ctx)
+			if err != nil {
+				app.logger.Errorw("pruning oauth states failed", "error", err.Error())
+			} else {
+				app.logger.Infow("pruned oauth states", "deleted", deleted)
+			}
+		}
+	}
+}
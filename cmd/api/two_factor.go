@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/harshvse/go-api/internal/auth"
+	"github.com/harshvse/go-api/internal/lockout"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+const recoveryCodeCount = 10
+
+// wrong codes a 2fa token takes before it stops working and the password step
+// has to be repeated
+const twoFactorTokenMaxFailures = 5
+
+var errInvalidTwoFactorCode = errors.New("invalid two factor code")
+
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	TwoFactorToken    string `json:"two_factor_token"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorCodePayload struct {
+	Code string `json:"code" validate:"required,numeric,max=10"`
+}
+
+// either a code from the authenticator app or one of the recovery codes
+type TwoFactorVerificationPayload struct {
+	Code         string `json:"code" validate:"omitempty,numeric,max=10"`
+	RecoveryCode string `json:"recovery_code" validate:"omitempty,max=32"`
+}
+
+type TwoFactorTokenPayload struct {
+	TwoFactorToken string `json:"two_factor_token" validate:"required"`
+	TwoFactorVerificationPayload
+}
+
+// EnrollTwoFactor godoc
+//
+//	@Summary		Start 2FA enrollment
+//	@Description	Generates a new TOTP secret, 2FA is enabled once a code for it is confirmed
+//	@Tags			two factor
+//	@Produce		json
+//	@Success		201	{object}	TwoFactorEnrollment
+//	@Failure		401	{object}	error
+//	@Failure		409	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/enroll [post]
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Enroll(r.Context(), user.ID, secret); err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.conflictResponse(w, r, fmt.Errorf("two factor authentication is already enabled"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	enrollment := TwoFactorEnrollment{
+		Secret:     secret,
+		OTPAuthURI: app.totp.ProvisioningURI(secret, user.Email),
+	}
+	if err := app.jsonResponse(w, http.StatusCreated, enrollment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// ConfirmTwoFactor godoc
+//
+//	@Summary		Confirm 2FA enrollment
+//	@Description	Enables 2FA with the first code from the authenticator app and returns the recovery codes
+//	@Tags			two factor
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TwoFactorCodePayload	true	"TOTP Code"
+//	@Success		200		{object}	RecoveryCodes
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		409		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/confirm [post]
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	var payload TwoFactorCodePayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	twoFactor, err := app.store.TwoFactor.GetByUserID(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestError(w, r, fmt.Errorf("two factor enrollment has not been started"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if twoFactor.Enabled {
+		app.conflictResponse(w, r, fmt.Errorf("two factor authentication is already enabled"))
+		return
+	}
+
+	if !app.startLoginAttempt(w, r, user.Email) {
+		return
+	}
+
+	if err := app.verifySecondFactor(ctx, twoFactor, payload.Code, ""); err != nil {
+		if errors.Is(err, errInvalidTwoFactorCode) {
+			app.recordLoginFailure(r, user.Email, user)
+		}
+		app.twoFactorError(w, r, err)
+		return
+	}
+	app.resetLoginFailures(r, user.Email)
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Enable(ctx, user.ID, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodes{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// DisableTwoFactor godoc
+//
+//	@Summary		Disable 2FA
+//	@Description	Turns 2FA off after checking a code or a recovery code
+//	@Tags			two factor
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TwoFactorVerificationPayload	true	"TOTP or Recovery Code"
+//	@Success		204		{string}	string							"2FA disabled"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/disable [post]
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	twoFactor, ok := app.readAndVerifySecondFactor(w, r, user)
+	if !ok {
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(r.Context(), twoFactor.UserID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// RegenerateRecoveryCodes godoc
+//
+//	@Summary		Regenerate recovery codes
+//	@Description	Replaces all recovery codes after checking a code or a recovery code
+//	@Tags			two factor
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TwoFactorVerificationPayload	true	"TOTP or Recovery Code"
+//	@Success		200		{object}	RecoveryCodes
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/2fa/recovery-codes [post]
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	twoFactor, ok := app.readAndVerifySecondFactor(w, r, user)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.ReplaceRecoveryCodes(r.Context(), twoFactor.UserID, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodes{RecoveryCodes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// CreateTwoFactorToken godoc
+//
+//	@Summary		Complete a 2FA login
+//	@Description	Exchanges the token from the password step and a code or recovery code for the access and refresh tokens
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		TwoFactorTokenPayload	true	"2FA Token and Code"
+//	@Success		201		{object}	TokenPair				"Tokens"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		429		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/authentication/token/2fa [post]
+func (app *application) createTwoFactorTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TwoFactorTokenPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	token, err := app.verifyTwoFactorToken(payload.TwoFactorToken)
+	if err != nil {
+		app.unauthorizedError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	// every token works for one login and a few wrong codes
+	used, err := app.store.RevokedTokens.IsRevoked(ctx, token.jti)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if used {
+		app.unauthorizedError(w, r, fmt.Errorf("two factor token has already been used"))
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, token.userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	twoFactor, err := app.store.TwoFactor.GetByUserID(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			// 2fa was turned off after the password step
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	// every token takes a few codes, on top the codes are guessed against the
+	// same counters as the password
+	if !app.startTwoFactorTokenAttempt(w, r, token) {
+		return
+	}
+	if !app.startLoginAttempt(w, r, user.Email) {
+		return
+	}
+
+	if err := app.verifySecondFactor(ctx, twoFactor, payload.Code, payload.RecoveryCode); err != nil {
+		if errors.Is(err, errInvalidTwoFactorCode) {
+			app.recordLoginFailure(r, user.Email, user)
+			app.recordTwoFactorTokenFailure(r, token)
+		}
+		app.twoFactorError(w, r, err)
+		return
+	}
+
+	if err := app.store.RevokedTokens.Revoke(ctx, token.jti, token.userID, token.expiry); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.resetLoginFailures(r, user.Email)
+
+	app.respondWithNewTokens(w, r, user)
+}
+
+func (app *application) twoFactorTokenPolicy() lockout.Policy {
+	return lockout.Policy{
+		MaxFailures:     twoFactorTokenMaxFailures,
+		LockoutDuration: app.config.auth.token.twoFactorExp,
+		Window:          app.config.auth.token.twoFactorExp,
+	}
+}
+
+// counts a code tried with the 2fa token, answers with 401 and returns false
+// once the token has taken twoFactorTokenMaxFailures of them
+func (app *application) startTwoFactorTokenAttempt(w http.ResponseWriter, r *http.Request, token *twoFactorToken) bool {
+	wait, err := app.loginGuard.Attempt(r.Context(), "2fa-token:"+token.jti, app.twoFactorTokenPolicy())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return false
+	}
+	if wait > 0 {
+		app.unauthorizedError(w, r, fmt.Errorf("too many wrong codes for 2fa token %s", token.jti))
+		return false
+	}
+	return true
+}
+
+// marks the code tried with the 2fa token as wrong and revokes the token once
+// it has taken twoFactorTokenMaxFailures of them
+func (app *application) recordTwoFactorTokenFailure(r *http.Request, token *twoFactorToken) {
+	locked, err := app.loginGuard.Fail(r.Context(), "2fa-token:"+token.jti, app.twoFactorTokenPolicy())
+	if err != nil {
+		app.logger.Errorw("recording the failed code of a 2fa token failed", "error", err.Error())
+		return
+	}
+	if !locked {
+		return
+	}
+
+	if err := app.store.RevokedTokens.Revoke(r.Context(), token.jti, token.userID, token.expiry); err != nil {
+		app.logger.Errorw("revoking the 2fa token failed", "user_id", token.userID, "error", err.Error())
+	}
+}
+
+// finishes a login after the first factor, with 2fa on that was only the first step
+func (app *application) respondWithLogin(w http.ResponseWriter, r *http.Request, user *store.User) {
+	twoFactorEnabled, err := app.twoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if twoFactorEnabled {
+		app.respondWithTwoFactorChallenge(w, r, user)
+		return
+	}
+	app.respondWithNewTokens(w, r, user)
+}
+
+func (app *application) twoFactorEnabled(ctx context.Context, userID int64) (bool, error) {
+	twoFactor, err := app.store.TwoFactor.GetByUserID(ctx, userID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return twoFactor.Enabled, nil
+}
+
+// sends the short lived token that proves the password step of a 2fa login
+func (app *application) respondWithTwoFactorChallenge(w http.ResponseWriter, r *http.Request, user *store.User) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":       strconv.FormatInt(user.ID, 10),
+		"jti":       uuid.New().String(),
+		"token_use": twoFactorTokenUse,
+		"exp":       now.Add(app.config.auth.token.twoFactorExp).Unix(),
+		"iat":       now.Unix(),
+		"nbf":       now.Unix(),
+		"iss":       app.config.auth.token.iss,
+		"aud":       app.config.auth.token.iss,
+	}
+	token, err := app.authenticator.GenerateToken(claims)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	challenge := TwoFactorChallenge{
+		TwoFactorRequired: true,
+		TwoFactorToken:    token,
+	}
+	if err := app.jsonResponse(w, http.StatusOK, challenge); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// the claims of a verified 2fa token
+type twoFactorToken struct {
+	userID int64
+	jti    string
+	expiry time.Time
+}
+
+func (app *application) verifyTwoFactorToken(token string) (*twoFactorToken, error) {
+	jwtToken, err := app.authenticator.VerifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	if tokenUse, _ := claims["token_use"].(string); tokenUse != twoFactorTokenUse {
+		return nil, fmt.Errorf("token is not a two factor token")
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return nil, err
+	}
+	userID, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, fmt.Errorf("two factor token has no id")
+	}
+
+	expiry, err := claims.GetExpirationTime()
+	if err != nil || expiry == nil {
+		return nil, fmt.Errorf("two factor token has no expiry")
+	}
+
+	return &twoFactorToken{userID: userID, jti: jti, expiry: expiry.Time}, nil
+}
+
+// reads a TwoFactorVerificationPayload and checks it against the enabled 2fa of
+// the user, writes the error response and returns false when it fails. wrong
+// codes count against the same limits as the login, so a stolen session can not
+// be used to guess them
+func (app *application) readAndVerifySecondFactor(w http.ResponseWriter, r *http.Request, user *store.User) (*store.TwoFactor, bool) {
+	var payload TwoFactorVerificationPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return nil, false
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return nil, false
+	}
+
+	ctx := r.Context()
+	twoFactor, err := app.store.TwoFactor.GetByUserID(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestError(w, r, fmt.Errorf("two factor authentication is not enabled"))
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !twoFactor.Enabled {
+		app.badRequestError(w, r, fmt.Errorf("two factor authentication is not enabled"))
+		return nil, false
+	}
+
+	if !app.startLoginAttempt(w, r, user.Email) {
+		return nil, false
+	}
+
+	if err := app.verifySecondFactor(ctx, twoFactor, payload.Code, payload.RecoveryCode); err != nil {
+		if errors.Is(err, errInvalidTwoFactorCode) {
+			app.recordLoginFailure(r, user.Email, user)
+		}
+		app.twoFactorError(w, r, err)
+		return nil, false
+	}
+	app.resetLoginFailures(r, user.Email)
+	return twoFactor, true
+}
+
+// accepts a totp code once per time step, or consumes a recovery code
+func (app *application) verifySecondFactor(ctx context.Context, twoFactor *store.TwoFactor, code, recoveryCode string) error {
+	switch {
+	case code != "":
+		step, ok := app.totp.Validate(twoFactor.Secret, code)
+		if !ok {
+			return errInvalidTwoFactorCode
+		}
+		return app.store.TwoFactor.RecordStep(ctx, twoFactor.UserID, step)
+	case recoveryCode != "" && twoFactor.Enabled:
+		err := app.store.TwoFactor.UseRecoveryCode(ctx, twoFactor.UserID, hashRecoveryCode(recoveryCode))
+		if errors.Is(err, store.ErrNotFound) {
+			return errInvalidTwoFactorCode
+		}
+		return err
+	default:
+		return errInvalidTwoFactorCode
+	}
+}
+
+func (app *application) twoFactorError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errInvalidTwoFactorCode), errors.Is(err, store.ErrCodeReused):
+		app.unauthorizedError(w, r, err)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+// returns the plain codes for the user and their hashes for the database
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 7)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(raw))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// recovery codes are typed by hand so ignore case, dashes and spaces
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/harshvse/go-api/internal/auth"
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// keeps the last used step like user_totp.last_used_step does
+type fakeTwoFactorStore struct {
+	lastUsedStep map[int64]int64
+}
+
+func (s *fakeTwoFactorStore) GetByUserID(ctx context.Context, userID int64) (*store.TwoFactor, error) {
+	return nil, store.ErrNotFound
+}
+func (s *fakeTwoFactorStore) Enroll(ctx context.Context, userID int64, secret string) error {
+	return nil
+}
+func (s *fakeTwoFactorStore) Enable(ctx context.Context, userID int64, codes []string) error {
+	return nil
+}
+func (s *fakeTwoFactorStore) Disable(ctx context.Context, userID int64) error { return nil }
+
+func (s *fakeTwoFactorStore) RecordStep(ctx context.Context, userID int64, step int64) error {
+	if last, ok := s.lastUsedStep[userID]; ok && last >= step {
+		return store.ErrCodeReused
+	}
+	s.lastUsedStep[userID] = step
+	return nil
+}
+
+func (s *fakeTwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	return store.ErrNotFound
+}
+func (s *fakeTwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, codes []string) error {
+	return nil
+}
+
+func TestVerifySecondFactorRejectsReusedCodes(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	now := time.Unix(1111111111, 0)
+
+	totp := auth.NewTOTP("test")
+	totp.Now = func() time.Time { return now }
+
+	twoFactorStore := &fakeTwoFactorStore{lastUsedStep: make(map[int64]int64)}
+	app := &application{
+		totp:  totp,
+		store: store.Storage{TwoFactor: twoFactorStore},
+	}
+	twoFactor := &store.TwoFactor{UserID: 1, Secret: secret, Enabled: true}
+	ctx := context.Background()
+
+	current := totp.Step(now)
+	code, _ := totp.Code(secret, current)
+	if err := app.verifySecondFactor(ctx, twoFactor, code, ""); err != nil {
+		t.Fatalf("first use of the code: %v", err)
+	}
+	if err := app.verifySecondFactor(ctx, twoFactor, code, ""); !errors.Is(err, store.ErrCodeReused) {
+		t.Fatalf("second use of the code = %v, want %v", err, store.ErrCodeReused)
+	}
+
+	// a code of an earlier step inside the skew window is not accepted after a later one
+	previous, _ := totp.Code(secret, current-1)
+	if err := app.verifySecondFactor(ctx, twoFactor, previous, ""); !errors.Is(err, store.ErrCodeReused) {
+		t.Fatalf("code of the previous step = %v, want %v", err, store.ErrCodeReused)
+	}
+
+	// the next step works once the clock moves on
+	now = now.Add(totp.Period)
+	next, _ := totp.Code(secret, current+1)
+	if err := app.verifySecondFactor(ctx, twoFactor, next, ""); err != nil {
+		t.Fatalf("code of the next step: %v", err)
+	}
+
+	if err := app.verifySecondFactor(ctx, twoFactor, "000000", ""); !errors.Is(err, errInvalidTwoFactorCode) {
+		t.Fatalf("wrong code = %v, want %v", err, errInvalidTwoFactorCode)
+	}
+}
@@ -3,16 +3,23 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/harshvse/go-api/internal/mailer"
 	"github.com/harshvse/go-api/internal/store"
 )
 
 type userKey string
 
-const userctx userKey = "user"
+const (
+	userctx     userKey = "user"
+	authUserCtx userKey = "authUser"
+)
 
 // GetUser godoc
 //
@@ -36,20 +43,12 @@ func (app *application) getUserByIDHandler(w http.ResponseWriter, r *http.Reques
 	}
 }
 
-type FollowUser struct {
-	UserID int64 `json:"user_id"`
-}
-
 func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request) {
 	followedUser := getUserFromCtx(r)
+	follower := getAuthUserFromCtx(r)
 
-	var payload FollowUser
-	if err := readJson(w, r, &payload); err != nil {
-		app.badRequestError(w, r, err)
-		return
-	}
 	ctx := r.Context()
-	if err := app.store.Followers.Follow(ctx, followedUser.ID, payload.UserID); err != nil {
+	if err := app.store.Followers.Follow(ctx, follower.ID, followedUser.ID); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -62,14 +61,10 @@ func (app *application) followUserHandler(w http.ResponseWriter, r *http.Request
 
 func (app *application) unFollowUserHandler(w http.ResponseWriter, r *http.Request) {
 	unFollowedUser := getUserFromCtx(r)
+	follower := getAuthUserFromCtx(r)
 
-	var payload FollowUser
-	if err := readJson(w, r, &payload); err != nil {
-		app.badRequestError(w, r, err)
-		return
-	}
 	ctx := r.Context()
-	if err := app.store.Followers.UnFollow(ctx, unFollowedUser.ID, payload.UserID); err != nil {
+	if err := app.store.Followers.UnFollow(ctx, follower.ID, unFollowedUser.ID); err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
@@ -110,6 +105,158 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// DeleteAccount godoc
+//
+//	@Summary		Delete the account
+//	@Description	Deletes the current user with their posts, comments and sessions, needs a recent reauthentication
+//	@Tags			user
+//	@Produce		json
+//	@Success		204	{string}	string	"Account deleted"
+//	@Failure		401	{object}	error
+//	@Failure		403	{object}	error
+//	@Failure		500	{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me [delete]
+func (app *application) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	if err := app.store.Users.DeleteAccount(r.Context(), user.ID); err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if _, fromCookie, _ := app.readAccessToken(r); fromCookie {
+		app.clearAuthCookies(w)
+	}
+
+	if err := app.jsonResponse(w, http.StatusNoContent, nil); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+type ChangeEmailPayload struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+}
+
+// ChangeEmail godoc
+//
+//	@Summary		Request an email change
+//	@Description	Mails a confirmation link to the new address and a notice to the current one, the email changes once confirmed
+//	@Tags			user
+//	@Accept			json
+//	@Produce		json
+//	@Param			payload	body		ChangeEmailPayload	true	"New Email"
+//	@Success		202		{string}	string				"Confirmation sent"
+//	@Failure		400		{object}	error
+//	@Failure		401		{object}	error
+//	@Failure		500		{object}	error
+//	@Security		ApiKeyAuth
+//	@Router			/users/me/email [post]
+func (app *application) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user := getAuthUserFromCtx(r)
+
+	var payload ChangeEmailPayload
+	if err := readJson(w, r, &payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	if strings.EqualFold(payload.Email, user.Email) {
+		app.badRequestError(w, r, fmt.Errorf("the new email is the same as the current one"))
+		return
+	}
+
+	if !app.checkChangedEmailPolicy(w, r, payload.Email, user.Email) {
+		return
+	}
+
+	ctx := r.Context()
+	plainToken := uuid.New().String()
+
+	err := app.store.Users.CreateEmailChange(ctx, user.ID, payload.Email, hashToken(plainToken), app.config.mail.emailChangeExp)
+	if err != nil {
+		switch err {
+		case store.ErrDuplicateEmail:
+			app.badRequestError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	confirmVars := struct {
+		Username   string
+		ConfirmUrl string
+		Expiry     string
+	}{
+		Username:   user.Username,
+		ConfirmUrl: fmt.Sprintf("%s/confirm-email/%s", app.config.frontendURL, plainToken),
+		Expiry:     app.config.mail.emailChangeExp.String(),
+	}
+	if err := app.sendEmail(mailer.EmailChangeConfirmTemplate, user.Username, payload.Email, confirmVars); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	// let the owner of the current address know, they can still stop it
+	noticeVars := struct {
+		Username string
+		NewEmail string
+	}{
+		Username: user.Username,
+		NewEmail: payload.Email,
+	}
+	if err := app.sendEmail(mailer.EmailChangeNoticeTemplate, user.Username, user.Email, noticeVars); err != nil {
+		app.logger.Errorw("sending the email change notice failed", "user_id", user.ID, "error", err.Error())
+	}
+
+	app.writeAccepted(w, r)
+}
+
+// ConfirmEmailChange godoc
+//
+//	@Summary		Confirm an email change
+//	@Description	Applies the pending email change from the link sent to the new address
+//	@Tags			user
+//	@Produce		json
+//	@Param			token	path		string	true	"Confirmation Token"
+//	@Success		200		{object}	store.User
+//	@Failure		400		{object}	error
+//	@Failure		404		{object}	error
+//	@Failure		500		{object}	error
+//	@Router			/users/email/confirm/{token} [put]
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	user, err := app.store.Users.ConfirmEmailChange(r.Context(), token)
+	if err != nil {
+		switch err {
+		case store.ErrNotFound:
+			app.notFoundError(w, r, err)
+		case store.ErrDuplicateEmail:
+			// somebody registered the address after the change was requested
+			app.badRequestError(w, r, err)
+		default:
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
 func (app *application) userContextMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		userIdString := chi.URLParam(r, "userId")
@@ -140,3 +287,9 @@ func getUserFromCtx(r *http.Request) *store.User {
 	user, _ := r.Context().Value(userctx).(*store.User)
 	return user
 }
+
+// returns the user who made the request, set by AuthTokenMiddleware
+func getAuthUserFromCtx(r *http.Request) *store.User {
+	user, _ := r.Context().Value(authUserCtx).(*store.User)
+	return user
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    jti uuid PRIMARY KEY,
+    user_id bigint NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_revoked_tokens_expiry ON revoked_tokens (expiry);
+
+ALTER TABLE
+    users
+ADD
+    COLUMN tokens_valid_after TIMESTAMP WITH TIME ZONE;
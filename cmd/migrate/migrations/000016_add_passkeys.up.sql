@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id BIGSERIAL PRIMARY KEY,
+    credential_id bytea UNIQUE NOT NULL,
+    user_id bigint NOT NULL,
+    public_key bytea NOT NULL,
+    algorithm INT NOT NULL,
+    aaguid bytea,
+    sign_count bigint NOT NULL DEFAULT 0,
+    transports VARCHAR(20) [],
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP(0) WITH TIME ZONE,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_passkeys_user_id ON passkeys (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+    challenge TEXT PRIMARY KEY,
+    user_id bigint,
+    ceremony VARCHAR(20) NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
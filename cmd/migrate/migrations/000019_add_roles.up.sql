@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id bigint NOT NULL,
+    permission_id bigint NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles (id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions (id) ON DELETE CASCADE
+);
+
+INSERT INTO roles (id, name, description) VALUES
+    (1, 'user', 'Can manage their own content'),
+    (2, 'moderator', 'Can delete the posts and comments of everyone'),
+    (3, 'admin', 'Can do everything including granting roles');
+
+SELECT setval('roles_id_seq', (SELECT MAX(id) FROM roles));
+
+INSERT INTO permissions (code, description) VALUES
+    ('posts:delete:any', 'Delete posts of other users'),
+    ('comments:delete:any', 'Delete comments of other users'),
+    ('roles:manage', 'Grant and revoke roles');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE (r.name = 'moderator' AND p.code IN ('posts:delete:any', 'comments:delete:any'))
+    OR r.name = 'admin';
+
+-- every existing and new user starts as a regular user, an admin operator
+-- promotes the first one with PUT /v1/admin/operator/users/{userId}/role
+ALTER TABLE users ADD COLUMN role_id bigint NOT NULL DEFAULT 1 REFERENCES roles (id);
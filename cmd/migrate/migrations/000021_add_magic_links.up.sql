@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS magic_links (
+    jti TEXT PRIMARY KEY,
+    user_id bigint NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS admin_accounts (
+    id bigserial PRIMARY KEY,
+    username citext UNIQUE NOT NULL,
+    password bytea NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+    id bigserial PRIMARY KEY,
+    -- the username is kept so entries still name the operator after the account is deleted
+    admin_id bigint,
+    username citext NOT NULL,
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    status int NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (admin_id) REFERENCES admin_accounts (id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_admin_audit_log_created_at ON admin_audit_log (created_at);
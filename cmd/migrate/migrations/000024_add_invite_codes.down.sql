@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS invite_code_id;
+ALTER TABLE users DROP COLUMN IF EXISTS referred_by;
+DROP TABLE IF EXISTS invite_codes;
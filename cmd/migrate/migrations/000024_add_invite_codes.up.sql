@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS invite_codes (
+    id bigserial PRIMARY KEY,
+    code bytea UNIQUE NOT NULL,
+    -- codes are made by a user or by an admin account
+    created_by_user_id bigint,
+    created_by_admin_id bigint,
+    max_uses int NOT NULL,
+    uses int NOT NULL DEFAULT 0,
+    expiry TIMESTAMP(0) WITH TIME ZONE,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (created_by_user_id) REFERENCES users (id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by_admin_id) REFERENCES admin_accounts (id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_invite_codes_created_by_user_id ON invite_codes (created_by_user_id);
+
+-- referred_by outlives the code so referral chains survive deleted codes
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS referred_by bigint REFERENCES users (id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS invite_code_id bigint REFERENCES invite_codes (id) ON DELETE SET NULL;
+
+CREATE INDEX idx_users_referred_by ON users (referred_by);
@@ -0,0 +1,7 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_time TIMESTAMP(0) WITH TIME ZONE;
+
+-- existing sessions last authenticated when they were created
+UPDATE sessions SET auth_time = created_at;
+
+ALTER TABLE sessions ALTER COLUMN auth_time SET NOT NULL;
+ALTER TABLE sessions ALTER COLUMN auth_time SET DEFAULT NOW();
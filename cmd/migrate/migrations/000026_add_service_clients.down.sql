@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS service_request_nonces;
+DROP TABLE IF EXISTS service_clients;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS service_clients (
+    id bigserial PRIMARY KEY,
+    key_id VARCHAR(64) UNIQUE NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    -- the api needs the secret itself to check signatures, it can't be hashed
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP(0) WITH TIME ZONE,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+-- nonces are only kept while their timestamp is within the allowed clock skew
+CREATE TABLE IF NOT EXISTS service_request_nonces (
+    client_id bigint NOT NULL,
+    nonce VARCHAR(128) NOT NULL,
+    expiry TIMESTAMP(0) WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (client_id, nonce),
+    FOREIGN KEY (client_id) REFERENCES service_clients (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_service_request_nonces_expiry ON service_request_nonces (expiry);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS provisioning_tokens (
+    id bigserial PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    token TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMP(0) WITH TIME ZONE,
+    revoked_at TIMESTAMP(0) WITH TIME ZONE
+);
+
+-- external_id is the id the identity provider knows the user by, deactivated_at
+-- tells accounts switched off by the provider apart from ones never activated
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS external_id VARCHAR(255) UNIQUE,
+    ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP(0) WITH TIME ZONE;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS normalized_email;
+DROP TABLE IF EXISTS email_domain_rules;
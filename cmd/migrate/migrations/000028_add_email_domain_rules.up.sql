@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS email_domain_rules (
+    id bigserial PRIMARY KEY,
+    domain citext UNIQUE NOT NULL,
+    action VARCHAR(10) NOT NULL CHECK (action IN ('allow', 'deny')),
+    note TEXT NOT NULL DEFAULT '',
+    created_by_admin_id bigint,
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (created_by_admin_id) REFERENCES admin_accounts (id) ON DELETE SET NULL
+);
+
+-- normalized_email is the address as emailpolicy.Normalize spells it, used to
+-- find accounts registered with another spelling of the same inbox
+ALTER TABLE users ADD COLUMN IF NOT EXISTS normalized_email TEXT;
+
+-- same steps as emailpolicy.Normalize: lower case, googlemail.com is gmail.com,
+-- no +tag and no dots in the local part at gmail.com
+UPDATE users u
+SET normalized_email = COALESCE(
+    CASE WHEN n.domain = 'gmail.com' THEN REPLACE(n.local, '.', '') ELSE n.local END || '@' || n.domain,
+    LOWER(u.email::text)
+)
+FROM (
+    SELECT
+        id,
+        CASE WHEN STRPOS(l, '+') > 1 THEN LEFT(l, STRPOS(l, '+') - 1) ELSE l END AS local,
+        CASE WHEN d = 'googlemail.com' THEN 'gmail.com' ELSE d END AS domain
+    FROM (
+        SELECT
+            id,
+            LOWER(SUBSTRING(email::text FROM '^(.*)@')) AS l,
+            RTRIM(LOWER(SUBSTRING(email::text FROM '@([^@]*)$')), '.') AS d
+        FROM users
+    ) parts
+) n
+WHERE u.id = n.id;
+
+ALTER TABLE users ALTER COLUMN normalized_email SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_normalized_email ON users (normalized_email);
@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN IF EXISTS provisioned_by, DROP COLUMN IF EXISTS provisioned_at;
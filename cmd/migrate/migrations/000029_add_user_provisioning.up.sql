@@ -0,0 +1,8 @@
+-- the SCIM endpoints only see accounts the identity provider created,
+-- provisioned_by keeps the token that did it for as long as the token exists
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS provisioned_at TIMESTAMP(0) WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS provisioned_by bigint REFERENCES provisioning_tokens (id) ON DELETE SET NULL;
+
+-- only provisioned accounts were given an external id so far
+UPDATE users SET provisioned_at = created_at WHERE external_id IS NOT NULL;
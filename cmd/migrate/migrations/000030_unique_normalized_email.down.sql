@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_normalized_email;
+
+CREATE INDEX IF NOT EXISTS idx_users_normalized_email ON users (normalized_email);
@@ -0,0 +1,7 @@
+-- two accounts of the same inbox could be registered at once while only the
+-- application checked for them. Accounts sharing a normalized_email have to be
+-- merged or renamed before this runs, they are listed by
+-- SELECT normalized_email, array_agg(id) FROM users GROUP BY 1 HAVING COUNT(*) > 1
+DROP INDEX IF EXISTS idx_users_normalized_email;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_normalized_email ON users (normalized_email);
@@ -1,30 +1,98 @@
 package auth
 
-import "github.com/golang-jwt/jwt/v5"
+import (
+	"fmt"
+	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTAuthenticator signs with a shared HS256 secret, or with the asymmetric
+// keys of a KeySet when one is configured
 type JWTAuthenticator struct {
 	secret   string
+	keys     *KeySet
 	audience string
 	iss      string
 }
 
-func NewJWTAuthenticator(secret, audience, iss string) JWTAuthenticator {
-	return JWTAuthenticator{
+func NewJWTAuthenticator(secret, audience, iss string) *JWTAuthenticator {
+	return &JWTAuthenticator{
 		secret:   secret,
 		audience: audience,
 		iss:      iss,
 	}
 }
 
+func NewJWTKeySetAuthenticator(keys *KeySet, audience, iss string) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		keys:     keys,
+		audience: audience,
+		iss:      iss,
+	}
+}
+
 func (a *JWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if a.keys == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+
+		tokenString, err := token.SignedString([]byte(a.secret))
+		if err != nil {
+			return "", err
+		}
+		return tokenString, nil
+	}
+
+	key, err := a.keys.Current(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
 
-	tokenString, err := token.SignedString([]byte(a.secret))
+	tokenString, err := token.SignedString(key.private)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
+	return tokenString, nil
 }
 
-func (a *JWTAuthenticator) VerifyToken(token string) *jwt.Token {
+func (a *JWTAuthenticator) VerifyToken(token string) (*jwt.Token, error) {
+	options := []jwt.ParserOption{
+		jwt.WithExpirationRequired(),
+		jwt.WithAudience(a.audience),
+		jwt.WithIssuer(a.iss),
+	}
 
+	if a.keys == nil {
+		return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+			// only accept the algorithm we sign with, otherwise anyone could send an unsigned token
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+			}
+			return []byte(a.secret), nil
+		}, append(options, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}))...)
+	}
+
+	return jwt.Parse(token, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := a.keys.Verifier(kid, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		// the algorithm is bound to the key, never to what the header claims
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %v for key %s", t.Header["alg"], kid)
+		}
+		return key.public, nil
+	}, append(options, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Name, jwt.SigningMethodEdDSA.Alg()}))...)
+}
+
+func (a *JWTAuthenticator) JWKS() JSONWebKeySet {
+	if a.keys == nil {
+		// the shared secret must never be published
+		return JSONWebKeySet{Keys: []JSONWebKey{}}
+	}
+	return a.keys.JWKS(time.Now())
 }
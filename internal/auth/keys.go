@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey   = errors.New("unknown signing key")
+	ErrKeyRetired   = errors.New("signing key is retired")
+	ErrNoActiveKeys = errors.New("no active signing key")
+)
+
+// SigningKey is a private key identified by its kid. A key starts signing at
+// ActivateAt and keeps signing until the next key in the set is activated
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	ActivateAt time.Time
+	private    crypto.Signer
+	public     crypto.PublicKey
+}
+
+// reads a PKCS#8 (RSA or Ed25519) or PKCS#1 (RSA) private key from a pem file
+func LoadSigningKeyFromPEM(kid, path string, activateAt time.Time) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem data found in %s", path)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported pem block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{
+		ID:         kid,
+		ActivateAt: activateAt,
+	}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.private = k
+		key.public = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.private = k
+		key.public = k.Public()
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %s", parsed, path)
+	}
+	return key, nil
+}
+
+// KeySet holds every key we know about ordered by activation time. The newest
+// activated key signs, older keys still verify for the grace window after
+// they got replaced
+type KeySet struct {
+	keys  []*SigningKey
+	grace time.Duration
+}
+
+func NewKeySet(grace time.Duration, keys ...*SigningKey) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoActiveKeys
+	}
+
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate kid %q", key.ID)
+		}
+		seen[key.ID] = true
+	}
+
+	sorted := append([]*SigningKey(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActivateAt.Before(sorted[j].ActivateAt)
+	})
+
+	return &KeySet{keys: sorted, grace: grace}, nil
+}
+
+// parses a comma separated list of kid=path or kid=path@RFC3339 entries, keys
+// without a timestamp are active right away
+func LoadKeySet(spec string, grace time.Duration) (*KeySet, error) {
+	var keys []*SigningKey
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kid, rest, ok := strings.Cut(entry, "=")
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("invalid key entry %q", entry)
+		}
+
+		path, activation, hasActivation := strings.Cut(rest, "@")
+		var activateAt time.Time
+		if hasActivation {
+			t, err := time.Parse(time.RFC3339, activation)
+			if err != nil {
+				return nil, fmt.Errorf("invalid activation time for key %q: %w", kid, err)
+			}
+			activateAt = t
+		}
+
+		key, err := LoadSigningKeyFromPEM(kid, path, activateAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeySet(grace, keys...)
+}
+
+// returns the key that should sign new tokens at the given time
+func (ks *KeySet) Current(now time.Time) (*SigningKey, error) {
+	for i := len(ks.keys) - 1; i >= 0; i-- {
+		if !ks.keys[i].ActivateAt.After(now) {
+			return ks.keys[i], nil
+		}
+	}
+	return nil, ErrNoActiveKeys
+}
+
+// returns the key for kid if tokens signed by it are still accepted
+func (ks *KeySet) Verifier(kid string, now time.Time) (*SigningKey, error) {
+	for i, key := range ks.keys {
+		if key.ID != kid {
+			continue
+		}
+		if key.ActivateAt.After(now) {
+			return nil, ErrUnknownKey
+		}
+		if i+1 < len(ks.keys) && now.After(ks.retiredAt(i)) {
+			return nil, ErrKeyRetired
+		}
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// the moment a key stops being accepted, only defined for replaced keys
+func (ks *KeySet) retiredAt(i int) time.Time {
+	return ks.keys[i+1].ActivateAt.Add(ks.grace)
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// publishes upcoming, current and still accepted keys so other services can
+// verify our tokens and pick up a new key before it starts signing
+func (ks *KeySet) JWKS(now time.Time) JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for i, key := range ks.keys {
+		if i+1 < len(ks.keys) && now.After(ks.retiredAt(i)) {
+			continue
+		}
+
+		jwk := JSONWebKey{
+			Kid: key.ID,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
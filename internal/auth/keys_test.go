@@ -0,0 +1,240 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newEd25519Key(t *testing.T, kid string, activateAt time.Time) *SigningKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, ActivateAt: activateAt, private: private, public: public}
+}
+
+func newRSAKey(t *testing.T, kid string, activateAt time.Time) *SigningKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, ActivateAt: activateAt, private: private, public: &private.PublicKey}
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": 1,
+		"aud": "test",
+		"iss": "test",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+// signs like GenerateToken would with the key, but lets the test pick the kid and algorithm
+func signWith(t *testing.T, key *SigningKey, method jwt.SigningMethod, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, testClaims())
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyTokenPicksTheKeyByKid(t *testing.T) {
+	now := time.Now()
+	old := newEd25519Key(t, "2024-01", now.Add(-time.Hour*48))
+	current := newEd25519Key(t, "2024-02", now.Add(-time.Hour))
+
+	keys, err := NewKeySet(time.Hour*24, current, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := NewJWTKeySetAuthenticator(keys, "test", "test")
+
+	token, err := authenticator.GenerateToken(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := authenticator.VerifyToken(token)
+	if err != nil {
+		t.Fatalf("token of the current key: %v", err)
+	}
+	if kid := parsed.Header["kid"]; kid != current.ID {
+		t.Errorf("signed with kid %v, want %s", kid, current.ID)
+	}
+
+	// the replaced key still verifies during the grace window
+	if _, err := authenticator.VerifyToken(signWith(t, old, jwt.SigningMethodEdDSA, old.ID)); err != nil {
+		t.Errorf("token of the replaced key: %v", err)
+	}
+
+	// the kid decides which public key checks the signature
+	if _, err := authenticator.VerifyToken(signWith(t, old, jwt.SigningMethodEdDSA, current.ID)); err == nil {
+		t.Error("token signed by another key than its kid was accepted")
+	}
+
+	if _, err := authenticator.VerifyToken(signWith(t, old, jwt.SigningMethodEdDSA, "unknown")); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of an unknown kid = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestVerifyTokenRejectsAnAlgorithmOtherThanTheKeys(t *testing.T) {
+	now := time.Now()
+	rsaKey := newRSAKey(t, "rsa", now.Add(-time.Hour))
+	edKey := newEd25519Key(t, "ed", now.Add(-time.Hour*2))
+
+	keys, err := NewKeySet(time.Hour*24, edKey, rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := NewJWTKeySetAuthenticator(keys, "test", "test")
+
+	if _, err := authenticator.VerifyToken(signWith(t, rsaKey, jwt.SigningMethodRS256, rsaKey.ID)); err != nil {
+		t.Fatalf("token of the rsa key: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"EdDSA token claiming the rsa kid", signWith(t, edKey, jwt.SigningMethodEdDSA, rsaKey.ID)},
+		{"RS512 token of the rsa key", signWith(t, rsaKey, jwt.SigningMethodRS512, rsaKey.ID)},
+	}
+	for _, tt := range tests {
+		if _, err := authenticator.VerifyToken(tt.token); err == nil {
+			t.Errorf("%s was accepted", tt.name)
+		}
+	}
+
+	// an HS256 token must not pass either, whatever it was signed with
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	hmacToken.Header["kid"] = rsaKey.ID
+	signed, err := hmacToken.SignedString([]byte("any secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticator.VerifyToken(signed); err == nil {
+		t.Error("HS256 token claiming the rsa kid was accepted")
+	}
+}
+
+func TestKeySetRetiresReplacedKeysAfterTheGrace(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	grace := time.Hour * 24
+
+	first := newEd25519Key(t, "first", start)
+	second := newEd25519Key(t, "second", start.Add(time.Hour*24*30))
+	upcoming := newEd25519Key(t, "upcoming", start.Add(time.Hour*24*60))
+
+	keys, err := NewKeySet(grace, upcoming, first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		kid     string
+		at      time.Time
+		wantErr error
+		current string
+	}{
+		{"first key while it signs", "first", start.Add(time.Hour), nil, "first"},
+		{"next key before its activation", "second", start.Add(time.Hour), ErrUnknownKey, "first"},
+		{"replaced key within the grace", "first", second.ActivateAt.Add(grace), nil, "second"},
+		{"replaced key after the grace", "first", second.ActivateAt.Add(grace + time.Second), ErrKeyRetired, "second"},
+		{"newest key never retires", "upcoming", upcoming.ActivateAt.Add(time.Hour * 24 * 365), nil, "upcoming"},
+	}
+
+	for _, tt := range tests {
+		key, err := keys.Verifier(tt.kid, tt.at)
+		switch {
+		case !errors.Is(err, tt.wantErr):
+			t.Errorf("%s: Verifier(%q) = %v, want %v", tt.name, tt.kid, err, tt.wantErr)
+		case err == nil && key.ID != tt.kid:
+			t.Errorf("%s: Verifier(%q) returned key %q", tt.name, tt.kid, key.ID)
+		}
+
+		current, err := keys.Current(tt.at)
+		if err != nil {
+			t.Fatalf("%s: Current: %v", tt.name, err)
+		}
+		if current.ID != tt.current {
+			t.Errorf("%s: Current = %q, want %q", tt.name, current.ID, tt.current)
+		}
+	}
+
+	if _, err := keys.Current(start.Add(-time.Second)); !errors.Is(err, ErrNoActiveKeys) {
+		t.Errorf("Current before any key is active = %v, want ErrNoActiveKeys", err)
+	}
+
+	if _, err := NewKeySet(grace, first, newEd25519Key(t, "first", start)); err == nil {
+		t.Error("NewKeySet accepted a duplicate kid")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	grace := time.Hour * 24
+
+	retired := newEd25519Key(t, "retired", now.Add(-time.Hour*24*60))
+	replaced := newRSAKey(t, "replaced", now.Add(-time.Hour*24*30))
+	current := newEd25519Key(t, "current", now.Add(-time.Hour))
+	upcoming := newEd25519Key(t, "upcoming", now.Add(time.Hour))
+
+	keys, err := NewKeySet(grace, upcoming, current, replaced, retired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := keys.JWKS(now)
+
+	var kids []string
+	for _, jwk := range set.Keys {
+		kids = append(kids, jwk.Kid)
+	}
+	// retired is gone, replaced is still in the grace of current
+	if want := []string{"replaced", "current", "upcoming"}; !slices.Equal(kids, want) {
+		t.Fatalf("JWKS kids = %v, want %v", kids, want)
+	}
+
+	rsaJWK := set.Keys[0]
+	rsaPublic := replaced.public.(*rsa.PublicKey)
+	if rsaJWK.Kty != "RSA" || rsaJWK.Alg != "RS256" || rsaJWK.Use != "sig" {
+		t.Errorf("rsa jwk = %+v", rsaJWK)
+	}
+	if rsaJWK.N != base64.RawURLEncoding.EncodeToString(rsaPublic.N.Bytes()) {
+		t.Error("rsa jwk has the wrong modulus")
+	}
+	// 65537
+	if rsaJWK.E != "AQAB" {
+		t.Errorf("rsa jwk exponent = %q, want AQAB", rsaJWK.E)
+	}
+
+	edJWK := set.Keys[1]
+	if edJWK.Kty != "OKP" || edJWK.Crv != "Ed25519" || edJWK.Alg != "EdDSA" || edJWK.Use != "sig" {
+		t.Errorf("ed25519 jwk = %+v", edJWK)
+	}
+	if edJWK.X != base64.RawURLEncoding.EncodeToString(current.public.(ed25519.PublicKey)) {
+		t.Error("ed25519 jwk has the wrong public key")
+	}
+	if edJWK.N != "" || edJWK.E != "" {
+		t.Errorf("ed25519 jwk carries rsa fields: %+v", edJWK)
+	}
+
+	// the shared secret is never published
+	if got := NewJWTAuthenticator("secret", "test", "test").JWKS(); len(got.Keys) != 0 {
+		t.Errorf("JWKS of the shared secret = %+v, want no keys", got.Keys)
+	}
+}
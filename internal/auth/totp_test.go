@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// base32 of the ascii secret "12345678901234567890" from appendix B of RFC 6238
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func newTestTOTP(now time.Time) *TOTP {
+	totp := NewTOTP("test")
+	totp.Now = func() time.Time { return now }
+	return totp
+}
+
+func TestTOTPCodeMatchesRFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	totp := NewTOTP("test")
+	totp.Digits = 8
+	for _, tt := range tests {
+		code, err := totp.Code(rfc6238Secret, totp.Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("code at %d: %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPValidateStepBoundaries(t *testing.T) {
+	// 59 is the last second of step 1, 60 the first of step 2
+	totp := newTestTOTP(time.Unix(59, 0))
+	code, err := totp.Code(rfc6238Secret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if step, ok := totp.Validate(rfc6238Secret, code); !ok || step != 1 {
+		t.Fatalf("Validate at 59s = %d, %v, want 1, true", step, ok)
+	}
+
+	totp.Now = func() time.Time { return time.Unix(60, 0) }
+	if totp.Step(totp.Now()) != 2 {
+		t.Fatalf("step at 60s = %d, want 2", totp.Step(totp.Now()))
+	}
+	// still inside the skew window, matched to the step it was made for
+	if step, ok := totp.Validate(rfc6238Secret, code); !ok || step != 1 {
+		t.Fatalf("Validate at 60s = %d, %v, want 1, true", step, ok)
+	}
+}
+
+func TestTOTPValidateSkewWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	totp := newTestTOTP(now)
+	current := totp.Step(now)
+
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tt := range tests {
+		code, err := totp.Code(rfc6238Secret, current+tt.offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := totp.Validate(rfc6238Secret, code)
+		if ok != tt.ok {
+			t.Errorf("code for step %+d accepted = %v, want %v", tt.offset, ok, tt.ok)
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("code for step %+d matched step %d", tt.offset, step-current)
+		}
+	}
+
+	totp.Skew = 0
+	code, _ := totp.Code(rfc6238Secret, current-1)
+	if _, ok := totp.Validate(rfc6238Secret, code); ok {
+		t.Error("code of the previous step accepted without skew")
+	}
+}
+
+func TestTOTPValidateRejectsMalformedCodes(t *testing.T) {
+	totp := newTestTOTP(time.Unix(1234567890, 0))
+	code, _ := totp.Code(rfc6238Secret, totp.Step(totp.Now()))
+
+	for _, input := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := totp.Validate(rfc6238Secret, input); ok {
+			t.Errorf("Validate(%q) accepted", input)
+		}
+	}
+	// authenticator apps are copied with surrounding whitespace
+	if _, ok := totp.Validate(rfc6238Secret, " "+code+"\n"); !ok {
+		t.Error("code with surrounding whitespace rejected")
+	}
+	if _, ok := totp.Validate("not base32!", code); ok {
+		t.Error("code accepted for an invalid secret")
+	}
+}
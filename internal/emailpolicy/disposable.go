@@ -0,0 +1,79 @@
+package emailpolicy
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+//go:embed disposable.txt
+var bundledDisposableDomains string
+
+// DisposableList holds the domains of throwaway inbox services. It starts
+// with the bundled list and can be replaced from a file while the server runs
+type DisposableList struct {
+	mu      sync.RWMutex
+	domains map[string]struct{}
+}
+
+func NewDisposableList() *DisposableList {
+	domains, _ := parseDomains(strings.NewReader(bundledDisposableDomains))
+	return &DisposableList{domains: domains}
+}
+
+// replaces the list with the domains in the file and returns how many there
+// are, the list is left alone when the file can't be read
+func (l *DisposableList) Load(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	domains, err := parseDomains(file)
+	if err != nil {
+		return 0, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.domains = domains
+	return len(domains), nil
+}
+
+// reports whether the domain or one of its parents is on the list
+func (l *DisposableList) Contains(domain string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, candidate := range domainAndParents(domain) {
+		if _, ok := l.domains[candidate]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *DisposableList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.domains)
+}
+
+// reads one domain per line, blank lines and lines starting with # are skipped
+func parseDomains(r io.Reader) (map[string]struct{}, error) {
+	domains := make(map[string]struct{})
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[strings.TrimSuffix(strings.ToLower(line), ".")] = struct{}{}
+	}
+	return domains, scanner.Err()
+}
@@ -0,0 +1,59 @@
+package emailpolicy
+
+import "strings"
+
+// providers known under more than one domain
+var domainAliases = map[string]string{
+	"googlemail.com": "gmail.com",
+}
+
+// providers that deliver a.b@ and ab@ to the same inbox
+var dotInsensitiveDomains = map[string]bool{
+	"gmail.com": true,
+}
+
+// Normalize returns the form every spelling of the same inbox shares, without
+// asking DNS: lower case, without the +tag of plus addressing and, for
+// providers that ignore them, without dots in the local part.
+// The migration adding users.normalized_email does the same in SQL
+func Normalize(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return strings.ToLower(email)
+	}
+	local, domain := strings.ToLower(email[:at]), Domain(email)
+
+	if canonical, ok := domainAliases[domain]; ok {
+		domain = canonical
+	}
+	// an address starting with + has no tag to drop
+	if tag := strings.IndexByte(local, '+'); tag > 0 {
+		local = local[:tag]
+	}
+	if dotInsensitiveDomains[domain] {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+	return local + "@" + domain
+}
+
+// Domain returns the lower cased domain of the address, empty without one
+func Domain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(email[at+1:]), ".")
+}
+
+// returns the domain followed by its parents, a.b.c gives a.b.c, b.c and c
+func domainAndParents(domain string) []string {
+	domains := []string{domain}
+	for {
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			return domains
+		}
+		domain = domain[dot+1:]
+		domains = append(domains, domain)
+	}
+}
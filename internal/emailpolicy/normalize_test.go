@@ -0,0 +1,31 @@
+package emailpolicy
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"Jane.Doe@Example.com", "jane.doe@example.com"},
+		// plus tags are dropped everywhere, dots only where the provider ignores them
+		{"jane+news@example.com", "jane@example.com"},
+		{"jane.doe+news+more@example.com", "jane.doe@example.com"},
+		{"Jane.Doe+news@Gmail.com", "janedoe@gmail.com"},
+		{"j.a.n.e@gmail.com", "jane@gmail.com"},
+		{"jane.doe@googlemail.com", "janedoe@gmail.com"},
+		{"jane.doe@mail.gmail.com", "jane.doe@mail.gmail.com"},
+		// an address starting with + has no tag to drop
+		{"+jane@example.com", "+jane@example.com"},
+		// the root dot of a fully qualified domain
+		{"jane@example.com.", "jane@example.com"},
+		{"jane.doe@googlemail.com.", "janedoe@gmail.com"},
+		{"not an address", "not an address"},
+	}
+
+	for _, tt := range tests {
+		if got := Normalize(tt.email); got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.email, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package emailpolicy
+
+import "fmt"
+
+// the codes of the reasons an email address is rejected for
+const (
+	CodeDenied     = "email_domain_denied"
+	CodeNotAllowed = "email_domain_not_allowed"
+	CodeDisposable = "email_disposable"
+)
+
+// what a Rule does with the addresses of its domain
+const (
+	ActionAllow = "allow"
+	ActionDeny  = "deny"
+)
+
+// Rule allows or denies a domain and its subdomains
+type Rule struct {
+	Domain string
+	Action string
+}
+
+type Policy struct {
+	// nil skips the disposable check
+	Disposable *DisposableList
+}
+
+// Violation is the reason an email address was rejected
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (v Violation) Error() string {
+	return v.Message
+}
+
+// Check returns why the address can't be used to register, nil when it can.
+// The rule for the most specific domain decides, so a subdomain can be allowed
+// inside a denied domain. As soon as there is one allow rule every domain
+// without a rule is rejected. Explicitly allowed domains skip the disposable check
+func (p *Policy) Check(email string, rules []Rule) *Violation {
+	domain := Domain(email)
+
+	actions := make(map[string]string, len(rules))
+	allowList := false
+	for _, rule := range rules {
+		actions[rule.Domain] = rule.Action
+		if rule.Action == ActionAllow {
+			allowList = true
+		}
+	}
+
+	for _, candidate := range domainAndParents(domain) {
+		switch actions[candidate] {
+		case ActionAllow:
+			return nil
+		case ActionDeny:
+			return &Violation{
+				Code:    CodeDenied,
+				Message: fmt.Sprintf("addresses at %s can not be used to register", domain),
+			}
+		}
+	}
+
+	if allowList {
+		return &Violation{
+			Code:    CodeNotAllowed,
+			Message: fmt.Sprintf("registration is limited to approved domains, %s is not one of them", domain),
+		}
+	}
+
+	if p.Disposable != nil && p.Disposable.Contains(domain) {
+		return &Violation{
+			Code:    CodeDisposable,
+			Message: "disposable email addresses can not be used to register",
+		}
+	}
+	return nil
+}
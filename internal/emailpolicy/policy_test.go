@@ -0,0 +1,64 @@
+package emailpolicy
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	policy := &Policy{Disposable: NewDisposableList()}
+
+	denyWithException := []Rule{
+		{Domain: "example.com", Action: ActionDeny},
+		{Domain: "corp.example.com", Action: ActionAllow},
+		{Domain: "legacy.corp.example.com", Action: ActionDeny},
+	}
+	denyOnly := []Rule{
+		{Domain: "example.com", Action: ActionDeny},
+	}
+	allowList := []Rule{
+		{Domain: "corp.example.com", Action: ActionAllow},
+		{Domain: "mailinator.com", Action: ActionAllow},
+	}
+
+	tests := []struct {
+		name  string
+		email string
+		rules []Rule
+		want  string
+	}{
+		{"no rules", "jane@example.org", nil, ""},
+		{"domain without a rule", "jane@example.org", denyOnly, ""},
+		{"denied domain", "jane@example.com", denyWithException, CodeDenied},
+		{"subdomain of a denied domain", "jane@mail.example.com", denyWithException, CodeDenied},
+		// the most specific rule wins, in either direction
+		{"allowed subdomain of a denied domain", "jane@corp.example.com", denyWithException, ""},
+		{"subdomain of the allowed subdomain", "jane@eu.corp.example.com", denyWithException, ""},
+		{"denied subdomain of an allowed subdomain", "jane@legacy.corp.example.com", denyWithException, CodeDenied},
+		{"upper case domain", "jane@EXAMPLE.com", denyWithException, CodeDenied},
+		// one allow rule turns the rules into an allow list
+		{"domain on the allow list", "jane@corp.example.com", allowList, ""},
+		{"domain without a rule next to an allowed one", "jane@example.org", denyWithException, CodeNotAllowed},
+		{"domain missing from the allow list", "jane@example.org", allowList, CodeNotAllowed},
+		{"disposable domain", "jane@mailinator.com", nil, CodeDisposable},
+		{"subdomain of a disposable domain", "jane@eu.mailinator.com", denyOnly, CodeDisposable},
+		{"allowed disposable domain", "jane@mailinator.com", allowList, ""},
+	}
+
+	for _, tt := range tests {
+		got := policy.Check(tt.email, tt.rules)
+		switch {
+		case tt.want == "" && got != nil:
+			t.Errorf("%s: Check(%q) = %s, want no violation", tt.name, tt.email, got.Code)
+		case tt.want != "" && got == nil:
+			t.Errorf("%s: Check(%q) = no violation, want %s", tt.name, tt.email, tt.want)
+		case tt.want != "" && got.Code != tt.want:
+			t.Errorf("%s: Check(%q) = %s, want %s", tt.name, tt.email, got.Code, tt.want)
+		}
+	}
+}
+
+func TestCheckWithoutDisposableList(t *testing.T) {
+	policy := &Policy{}
+
+	if got := policy.Check("jane@mailinator.com", nil); got != nil {
+		t.Errorf("Check = %s, want no violation without a disposable list", got.Code)
+	}
+}
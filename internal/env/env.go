@@ -4,19 +4,29 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"time"
 )
 
-// read the enviornment variable and return the string other types are given below
+// read the enviornment variable and return the string other types are given below.
+// A variable that is set but empty, like the ones copied from .env.example, counts as unset
 func GetString(key, fallback string) string {
-	val, ok := os.LookupEnv(key)
+	val, ok := lookup(key)
 	if !ok {
 		return fallback
 	}
 	return val
 }
 
-func GetInt(key string, fallback int) int {
+func lookup(key string) (string, bool) {
 	val, ok := os.LookupEnv(key)
+	if !ok || val == "" {
+		return "", false
+	}
+	return val, true
+}
+
+func GetInt(key string, fallback int) int {
+	val, ok := lookup(key)
 	if !ok {
 		return fallback
 	}
@@ -28,3 +38,17 @@ func GetInt(key string, fallback int) int {
 	}
 	return valAsInt
 }
+
+func GetDuration(key string, fallback time.Duration) time.Duration {
+	val, ok := lookup(key)
+	if !ok {
+		return fallback
+	}
+	valAsDuration, err := time.ParseDuration(val)
+
+	if err != nil {
+		log.Printf("The Environment Variable %s is not a duration", key)
+		return fallback
+	}
+	return valAsDuration
+}
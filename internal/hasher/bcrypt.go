@@ -0,0 +1,58 @@
+package hasher
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the cost every password was hashed with before argon2id
+const BcryptDefaultCost = bcrypt.DefaultCost
+
+// bcrypt ignores everything past 72 bytes
+const bcryptMaxLength = 72
+
+// Bcrypt is kept to verify the hashes of accounts created before argon2id
+type Bcrypt struct {
+	cost int
+}
+
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{cost: cost}
+}
+
+func (b *Bcrypt) Recognizes(encoded []byte) bool {
+	return bytes.HasPrefix(encoded, []byte("$2a$")) ||
+		bytes.HasPrefix(encoded, []byte("$2b$")) ||
+		bytes.HasPrefix(encoded, []byte("$2y$"))
+}
+
+func (b *Bcrypt) Hash(password []byte) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(password, b.cost)
+}
+
+func (b *Bcrypt) Verify(password, encoded []byte) error {
+	// passwords were limited to 72 bytes while bcrypt was in use, so a longer one can't match
+	if len(password) > bcryptMaxLength {
+		return ErrMismatch
+	}
+
+	err := bcrypt.CompareHashAndPassword(encoded, password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (b *Bcrypt) Outdated(encoded []byte) bool {
+	cost, err := bcrypt.Cost(encoded)
+	if err != nil {
+		return true
+	}
+	return cost != b.cost
+}
+
+func (b *Bcrypt) MaxLength() int {
+	return bcryptMaxLength
+}
@@ -0,0 +1,92 @@
+package hasher
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrMismatch           = errors.New("hasher: password does not match")
+	ErrUnknownAlgorithm   = errors.New("hasher: hash was made by an unknown algorithm")
+	ErrPasswordTooLong    = errors.New("hasher: password is too long for the algorithm")
+	ErrInvalidEncodedHash = errors.New("hasher: invalid encoded hash")
+)
+
+// Algorithm hashes passwords into a self describing string that carries the
+// algorithm and its parameters, so hashes of different versions can live side
+// by side in the same column
+type Algorithm interface {
+	// reports whether the encoded hash was made by this algorithm
+	Recognizes(encoded []byte) bool
+	Hash(password []byte) ([]byte, error)
+	// returns ErrMismatch when the password is wrong
+	Verify(password, encoded []byte) error
+	// reports whether the hash was made with other parameters than the current ones
+	Outdated(encoded []byte) bool
+	// the longest password in bytes that is hashed without being truncated
+	MaxLength() int
+}
+
+// Hasher hashes with the current algorithm and still verifies the hashes of
+// the legacy ones so they can be upgraded when the user logs in
+type Hasher struct {
+	current Algorithm
+	legacy  []Algorithm
+}
+
+func New(current Algorithm, legacy ...Algorithm) *Hasher {
+	return &Hasher{
+		current: current,
+		legacy:  legacy,
+	}
+}
+
+// the hasher every password is stored with, argon2id with bcrypt hashes of
+// older accounts still accepted
+var Default = New(NewArgon2id(DefaultArgon2idParams), NewBcrypt(BcryptDefaultCost))
+
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	if len(password) > h.current.MaxLength() {
+		return nil, ErrPasswordTooLong
+	}
+	return h.current.Hash([]byte(password))
+}
+
+func (h *Hasher) Verify(password string, encoded []byte) error {
+	algorithm, err := h.algorithmFor(encoded)
+	if err != nil {
+		return err
+	}
+	return algorithm.Verify([]byte(password), encoded)
+}
+
+// reports whether the hash should be replaced by one of the current algorithm
+// and parameters, only meaningful after the password was verified
+func (h *Hasher) NeedsRehash(encoded []byte) bool {
+	if !h.current.Recognizes(encoded) {
+		return true
+	}
+	return h.current.Outdated(encoded)
+}
+
+// the longest password the current algorithm takes
+func (h *Hasher) MaxLength() int {
+	return h.current.MaxLength()
+}
+
+func (h *Hasher) algorithmFor(encoded []byte) (Algorithm, error) {
+	if h.current.Recognizes(encoded) {
+		return h.current, nil
+	}
+	for _, algorithm := range h.legacy {
+		if algorithm.Recognizes(encoded) {
+			return algorithm, nil
+		}
+	}
+
+	prefix := encoded
+	if len(prefix) > 10 {
+		prefix = prefix[:10]
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, prefix)
+}
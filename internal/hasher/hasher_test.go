@@ -0,0 +1,178 @@
+package hasher
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap enough to keep the tests fast
+var testParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func newTestHasher(params Argon2idParams) *Hasher {
+	return New(NewArgon2id(params), NewBcrypt(bcrypt.MinCost))
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	h := newTestHasher(testParams)
+
+	encoded, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "$argon2id$v=19$m=1024,t=1,p=1$"; !strings.HasPrefix(string(encoded), want) {
+		t.Fatalf("hash %q does not start with %q", encoded, want)
+	}
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", encoded, err)
+	}
+	if params != testParams {
+		t.Errorf("decoded params = %+v, want %+v", params, testParams)
+	}
+	if len(salt) != int(testParams.SaltLength) || len(key) != int(testParams.KeyLength) {
+		t.Errorf("decoded salt of %d and key of %d bytes", len(salt), len(key))
+	}
+
+	if err := h.Verify("correct horse battery staple", encoded); err != nil {
+		t.Errorf("Verify with the right password: %v", err)
+	}
+	if err := h.Verify("correct horse battery stapler", encoded); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify with a wrong password = %v, want ErrMismatch", err)
+	}
+
+	// every hash gets its own salt
+	again, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) == string(encoded) {
+		t.Error("hashing the same password twice gave the same hash")
+	}
+}
+
+func TestArgon2idRejectsMalformedHashes(t *testing.T) {
+	tests := []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5",
+		"$argon2id$v=19$m=1024,t=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHRzYWx0$a2V5a2V5a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$not base64!$a2V5a2V5a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$$a2V5a2V5a2V5",
+	}
+
+	a := NewArgon2id(testParams)
+	for _, encoded := range tests {
+		if err := a.Verify([]byte("password"), []byte(encoded)); !errors.Is(err, ErrInvalidEncodedHash) {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidEncodedHash", encoded, err)
+		}
+		if !a.Outdated([]byte(encoded)) {
+			t.Errorf("Outdated(%q) = false, want true", encoded)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current := newTestHasher(testParams)
+
+	argon2idHash, err := current.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	costlierBcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := testParams
+	stronger.Iterations = 2
+
+	tests := []struct {
+		name    string
+		hasher  *Hasher
+		encoded []byte
+		want    bool
+	}{
+		{"current parameters", current, argon2idHash, false},
+		{"older parameters", newTestHasher(stronger), argon2idHash, true},
+		{"legacy algorithm", current, bcryptHash, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.hasher.NeedsRehash(tt.encoded); got != tt.want {
+			t.Errorf("%s: NeedsRehash = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	legacy := NewBcrypt(bcrypt.MinCost)
+	if legacy.Outdated(bcryptHash) {
+		t.Error("bcrypt hash of the configured cost is reported outdated")
+	}
+	if !legacy.Outdated(costlierBcryptHash) {
+		t.Error("bcrypt hash of another cost is not reported outdated")
+	}
+}
+
+func TestVerifyLegacyBcrypt(t *testing.T) {
+	h := newTestHasher(testParams)
+
+	encoded, err := bcrypt.GenerateFromPassword([]byte("hunter22"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.Verify("hunter22", encoded); err != nil {
+		t.Errorf("Verify with the right password: %v", err)
+	}
+	if err := h.Verify("hunter23", encoded); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify with a wrong password = %v, want ErrMismatch", err)
+	}
+
+	// bcrypt would only look at the first 72 bytes and accept this one
+	long := strings.Repeat("a", bcryptMaxLength)
+	encoded, err = bcrypt.GenerateFromPassword([]byte(long), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Verify(long+"b", encoded); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify with a password past 72 bytes = %v, want ErrMismatch", err)
+	}
+}
+
+func TestUnknownAlgorithm(t *testing.T) {
+	h := newTestHasher(testParams)
+
+	if err := h.Verify("password", []byte("$scrypt$ln=16,r=8,p=1$c2FsdA$a2V5")); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("Verify = %v, want ErrUnknownAlgorithm", err)
+	}
+}
+
+func TestLengthLimits(t *testing.T) {
+	h := newTestHasher(testParams)
+
+	if got := h.MaxLength(); got != argon2idMaxLength {
+		t.Fatalf("MaxLength = %d, want %d", got, argon2idMaxLength)
+	}
+
+	if _, err := h.Hash(strings.Repeat("a", argon2idMaxLength)); err != nil {
+		t.Errorf("hashing a password of the maximum length: %v", err)
+	}
+	if _, err := h.Hash(strings.Repeat("a", argon2idMaxLength+1)); !errors.Is(err, ErrPasswordTooLong) {
+		t.Errorf("hashing a longer password = %v, want ErrPasswordTooLong", err)
+	}
+}
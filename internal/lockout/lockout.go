@@ -0,0 +1,140 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// Store keeps the counters in the database so they survive restarts and are
+// shared by every instance of the server
+type Store interface {
+	Get(ctx context.Context, key string) (*store.LoginAttempt, error)
+	RecordAttempt(ctx context.Context, key string, now, forgetBefore time.Time, limits store.AttemptLimits) (*store.LoginAttempt, error)
+	Lock(ctx context.Context, key string, failures int, until time.Time) (bool, error)
+	Forgive(ctx context.Context, key string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Policy decides how a key is slowed down and locked after failed logins
+type Policy struct {
+	// failures allowed before any delay applies
+	FreeFailures int
+	// the wait after the first failure past the free ones, doubled for every further failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// failures that lock the key, 0 never locks
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// failures older than this are forgotten
+	Window time.Duration
+}
+
+// returns how long the key has to wait after its failures-th failure
+func (p Policy) Delay(failures int) time.Duration {
+	if failures <= p.FreeFailures || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.FreeFailures + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Guard applies policies to the counters in the store
+type Guard struct {
+	store Store
+	now   func() time.Time
+}
+
+func NewGuard(store Store) *Guard {
+	return &Guard{
+		store: store,
+		now:   time.Now,
+	}
+}
+
+// counts an attempt of the key before the credentials are checked and returns
+// how long the key has to wait, 0 when it can try now. Nothing is counted when
+// it has to wait. Counting up front means concurrent guesses can't all get
+// through while none of them failed yet, a successful attempt is taken back
+// with Reset or Forgive
+func (g *Guard) Attempt(ctx context.Context, key string, policy Policy) (time.Duration, error) {
+	limits := store.AttemptLimits{
+		FreeFailures: policy.FreeFailures,
+		BaseDelay:    policy.BaseDelay,
+		MaxDelay:     policy.MaxDelay,
+		MaxFailures:  policy.MaxFailures,
+	}
+
+	// the attempt is refused while the key waits, when the wait is over by the
+	// time it is read back another attempt got in between and it tries again
+	for range 3 {
+		now := g.now()
+		_, err := g.store.RecordAttempt(ctx, key, now, now.Add(-policy.Window), limits)
+		if err == nil {
+			return 0, nil
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return 0, err
+		}
+
+		attempt, err := g.store.Get(ctx, key)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			return 0, err
+		}
+		if wait := policy.wait(attempt, now); wait > 0 {
+			return wait, nil
+		}
+	}
+	return max(policy.BaseDelay, time.Second), nil
+}
+
+// returns how long the key of attempt has to wait at now
+func (p Policy) wait(attempt *store.LoginAttempt, now time.Time) time.Duration {
+	if attempt.LockedUntil != nil {
+		return attempt.LockedUntil.Sub(now)
+	}
+	if attempt.LastFailureAt.Before(now.Add(-p.Window)) {
+		return 0
+	}
+
+	// the attempt that reached the maximum is about to lock the key, until
+	// then nothing gets in before the window forgets them
+	if p.MaxFailures > 0 && attempt.Failures >= p.MaxFailures {
+		return attempt.LastFailureAt.Add(p.Window).Sub(now)
+	}
+	return attempt.LastFailureAt.Add(p.Delay(attempt.Failures)).Sub(now)
+}
+
+// marks the counted attempt as failed and reports whether it locked the key
+func (g *Guard) Fail(ctx context.Context, key string, policy Policy) (bool, error) {
+	if policy.MaxFailures == 0 {
+		return false, nil
+	}
+	return g.store.Lock(ctx, key, policy.MaxFailures, g.now().Add(policy.LockoutDuration))
+}
+
+// takes back the counted attempt after it succeeded, for keys like addresses
+// that are shared and shouldn't forget everyone's failures on one login
+func (g *Guard) Forgive(ctx context.Context, key string) error {
+	return g.store.Forgive(ctx, key)
+}
+
+// forgets the failures of the key after a successful login or when an admin clears it
+func (g *Guard) Reset(ctx context.Context, key string) error {
+	if err := g.store.Delete(ctx, key); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package lockout
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harshvse/go-api/internal/store"
+)
+
+// memStore keeps the counters like LoginAttemptStore does in the database
+type memStore struct {
+	mu       sync.Mutex
+	attempts map[string]store.LoginAttempt
+}
+
+func newMemStore() *memStore {
+	return &memStore{attempts: make(map[string]store.LoginAttempt)}
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (*store.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return &attempt, nil
+}
+
+func (s *memStore) RecordAttempt(ctx context.Context, key string, now, forgetBefore time.Time, limits store.AttemptLimits) (*store.LoginAttempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	switch {
+	case !ok:
+		attempt = store.LoginAttempt{Key: key}
+	case attempt.LockedUntil != nil && !attempt.LockedUntil.After(now):
+		attempt.Failures = 0
+		attempt.LockedUntil = nil
+	case attempt.LockedUntil != nil:
+		return nil, store.ErrNotFound
+	case attempt.LastFailureAt.Before(forgetBefore):
+		attempt.Failures = 0
+	case limits.MaxFailures > 0 && attempt.Failures >= limits.MaxFailures:
+		return nil, store.ErrNotFound
+	default:
+		maxDelay := limits.MaxDelay
+		if maxDelay <= 0 {
+			maxDelay = limits.BaseDelay
+		}
+		policy := Policy{FreeFailures: limits.FreeFailures, BaseDelay: limits.BaseDelay, MaxDelay: maxDelay}
+		if attempt.LastFailureAt.Add(policy.Delay(attempt.Failures)).After(now) {
+			return nil, store.ErrNotFound
+		}
+	}
+
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	s.attempts[key] = attempt
+	return &attempt, nil
+}
+
+func (s *memStore) Lock(ctx context.Context, key string, failures int, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.attempts[key]
+	if !ok || attempt.Failures < failures || attempt.LockedUntil != nil {
+		return false, nil
+	}
+	attempt.LockedUntil = &until
+	s.attempts[key] = attempt
+	return true, nil
+}
+
+func (s *memStore) Forgive(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempt, ok := s.attempts[key]; ok && attempt.Failures > 0 {
+		attempt.Failures--
+		s.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.attempts[key]; !ok {
+		return store.ErrNotFound
+	}
+	delete(s.attempts, key)
+	return nil
+}
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestGuard() (*Guard, *clock) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return &Guard{store: newMemStore(), now: c.Now}, c
+}
+
+var testPolicy = Policy{
+	FreeFailures:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Second * 8,
+	MaxFailures:     10,
+	LockoutDuration: time.Minute * 15,
+	Window:          time.Hour,
+}
+
+// tries once and fails, the attempt has to be allowed
+func fail(t *testing.T, g *Guard, key string, policy Policy) bool {
+	t.Helper()
+	ctx := context.Background()
+
+	wait, err := g.Attempt(ctx, key, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Fatalf("attempt has to wait %s", wait)
+	}
+
+	locked, err := g.Fail(ctx, key, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locked
+}
+
+func attemptWait(t *testing.T, g *Guard, key string, policy Policy) time.Duration {
+	t.Helper()
+	wait, err := g.Attempt(context.Background(), key, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wait
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, time.Second * 2},
+		{6, time.Second * 4},
+		{7, time.Second * 8},
+		{8, time.Second * 8},
+		{100, time.Second * 8},
+	}
+
+	for _, tt := range tests {
+		if got := testPolicy.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+
+	noMax := Policy{FreeFailures: 1, BaseDelay: time.Second}
+	if got := noMax.Delay(5); got != time.Second {
+		t.Errorf("Delay without a maximum = %s, want the base delay", got)
+	}
+}
+
+func TestFreeFailures(t *testing.T) {
+	g, _ := newTestGuard()
+
+	for i := 0; i < testPolicy.FreeFailures; i++ {
+		fail(t, g, "key", testPolicy)
+	}
+	// the failures so far cost nothing
+	fail(t, g, "key", testPolicy)
+
+	if wait := attemptWait(t, g, "key", testPolicy); wait != time.Second {
+		t.Fatalf("wait after the free failures = %s, want %s", wait, time.Second)
+	}
+}
+
+func TestBackoffGrows(t *testing.T) {
+	g, c := newTestGuard()
+
+	for i := 0; i <= testPolicy.FreeFailures; i++ {
+		fail(t, g, "key", testPolicy)
+	}
+
+	for _, want := range []time.Duration{1, 2, 4, 8, 8} {
+		want *= time.Second
+		if wait := attemptWait(t, g, "key", testPolicy); wait != want {
+			t.Fatalf("wait = %s, want %s", wait, want)
+		}
+
+		c.Advance(want - time.Millisecond)
+		if wait := attemptWait(t, g, "key", testPolicy); wait != time.Millisecond {
+			t.Fatalf("wait just before the delay is over = %s, want %s", wait, time.Millisecond)
+		}
+
+		c.Advance(time.Millisecond)
+		fail(t, g, "key", testPolicy)
+	}
+}
+
+func TestLockoutExpires(t *testing.T) {
+	g, c := newTestGuard()
+	policy := Policy{MaxFailures: 3, LockoutDuration: time.Minute * 15, Window: time.Hour}
+
+	for i := 1; i < policy.MaxFailures; i++ {
+		if fail(t, g, "key", policy) {
+			t.Fatalf("locked after %d failures", i)
+		}
+	}
+	if !fail(t, g, "key", policy) {
+		t.Fatal("not locked after the maximum of failures")
+	}
+
+	if wait := attemptWait(t, g, "key", policy); wait != policy.LockoutDuration {
+		t.Fatalf("wait = %s, want the lockout of %s", wait, policy.LockoutDuration)
+	}
+
+	c.Advance(policy.LockoutDuration)
+	if fail(t, g, "key", policy) {
+		t.Fatal("locked again by the first failure after the lockout")
+	}
+	attempt, _ := g.store.Get(context.Background(), "key")
+	if attempt.Failures != 1 || attempt.LockedUntil != nil {
+		t.Fatalf("attempt after the lockout = %+v, want the count started over", attempt)
+	}
+}
+
+func TestWindowForgetsFailures(t *testing.T) {
+	g, c := newTestGuard()
+
+	for i := 0; i < 6; i++ {
+		c.Advance(time.Minute)
+		fail(t, g, "key", testPolicy)
+	}
+	if wait := attemptWait(t, g, "key", testPolicy); wait == 0 {
+		t.Fatal("no wait after repeated failures")
+	}
+
+	c.Advance(testPolicy.Window + time.Second)
+	fail(t, g, "key", testPolicy)
+	attempt, _ := g.store.Get(context.Background(), "key")
+	if attempt.Failures != 1 {
+		t.Fatalf("failures = %d after the window, want 1", attempt.Failures)
+	}
+}
+
+func TestAttemptsCountBeforeTheyFail(t *testing.T) {
+	g, c := newTestGuard()
+	policy := Policy{MaxFailures: 3, LockoutDuration: time.Minute, Window: time.Hour}
+
+	// concurrent guesses that haven't failed yet still use up the attempts
+	for i := 0; i < policy.MaxFailures; i++ {
+		if wait := attemptWait(t, g, "key", policy); wait != 0 {
+			t.Fatalf("attempt %d has to wait %s", i+1, wait)
+		}
+	}
+	if wait := attemptWait(t, g, "key", policy); wait != policy.Window {
+		t.Fatalf("wait = %s, want the window of %s", wait, policy.Window)
+	}
+
+	// with the free failures used up pending attempts are spaced by the delay
+	g, c = newTestGuard()
+	for i := 0; i < testPolicy.FreeFailures+1; i++ {
+		attemptWait(t, g, "key", testPolicy)
+	}
+	if wait := attemptWait(t, g, "key", testPolicy); wait != time.Second {
+		t.Fatalf("wait = %s, want %s", wait, time.Second)
+	}
+	c.Advance(time.Second)
+	if wait := attemptWait(t, g, "key", testPolicy); wait != 0 {
+		t.Fatalf("wait after the delay = %s, want none", wait)
+	}
+}
+
+func TestForgiveAndReset(t *testing.T) {
+	g, _ := newTestGuard()
+	ctx := context.Background()
+
+	fail(t, g, "key", testPolicy)
+	attemptWait(t, g, "key", testPolicy)
+	if err := g.Forgive(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	attempt, _ := g.store.Get(ctx, "key")
+	if attempt.Failures != 1 {
+		t.Fatalf("failures = %d after forgiving, want 1", attempt.Failures)
+	}
+
+	if err := g.Reset(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.store.Get(ctx, "key"); err != store.ErrNotFound {
+		t.Fatalf("key still counted after the reset: %v", err)
+	}
+	// resetting a key without failures is fine
+	if err := g.Reset(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+}
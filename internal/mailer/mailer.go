@@ -3,14 +3,19 @@ package mailer
 import "embed"
 
 const (
-	FromName            = "GoAPITemplate"
-	MaxRetries          = 3
-	UserWelcomeTemplate = "user_invitation.tmpl"
+	FromName                   = "GoAPITemplate"
+	MaxRetries                 = 3
+	UserWelcomeTemplate        = "user_invitation.tmpl"
+	PasswordResetTemplate      = "password_reset.tmpl"
+	EmailChangeConfirmTemplate = "email_change_confirm.tmpl"
+	EmailChangeNoticeTemplate  = "email_change_notice.tmpl"
+	AccountLockedTemplate      = "account_locked.tmpl"
+	MagicLinkTemplate          = "magic_link.tmpl"
 )
 
 //go:embed "templates"
 var FS embed.FS
 
 type Client interface {
-	Send(templateFile, username, email string, data any, isSandBox bool) (int,error)
+	Send(templateFile, username, email string, data any, isSandBox bool) (int, error)
 }
@@ -0,0 +1,192 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var supportedAlgorithms = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}
+
+// a key rotation at the provider shows up as an unknown kid, but don't let
+// tokens with made up kids make us hammer the jwks endpoint
+const minKeyRefreshInterval = time.Minute
+
+var ErrUnknownKey = errors.New("oidc: no key found for the token")
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type keySet struct {
+	keys []parsedKey
+}
+
+type parsedKey struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+func (p *Provider) getKey(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// like getDiscovery the lock is not held while fetching
+	p.Lock()
+	expired := p.now().Sub(p.keysFetchedAt) >= cacheDuration
+	if p.keys != nil && !expired {
+		if key, ok := p.keys.find(kid, alg); ok {
+			p.Unlock()
+			return key, nil
+		}
+	}
+
+	if p.keys != nil && !expired && p.now().Sub(p.keysFetchedAt) < minKeyRefreshInterval {
+		p.Unlock()
+		return nil, ErrUnknownKey
+	}
+	p.Unlock()
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := &keySet{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := parseJSONWebKey(jwk)
+		if err != nil {
+			// skip key types we don't know instead of failing the whole set
+			continue
+		}
+		keys.keys = append(keys.keys, parsedKey{kid: jwk.Kid, alg: jwk.Alg, key: key})
+	}
+
+	p.Lock()
+	p.keys = keys
+	p.keysFetchedAt = p.now()
+	p.Unlock()
+
+	if key, ok := keys.find(kid, alg); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// finds the key by kid, a token without kid is only accepted when the
+// provider publishes a single key
+func (ks *keySet) find(kid, alg string) (crypto.PublicKey, bool) {
+	if kid == "" {
+		if len(ks.keys) == 1 && compatible(ks.keys[0], alg) {
+			return ks.keys[0].key, true
+		}
+		return nil, false
+	}
+
+	for _, key := range ks.keys {
+		if key.kid == kid && compatible(key, alg) {
+			return key.key, true
+		}
+	}
+	return nil, false
+}
+
+// the algorithm in the token header has to fit the key type
+func compatible(key parsedKey, alg string) bool {
+	if key.alg != "" && key.alg != alg {
+		return false
+	}
+	switch key.key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" || alg == "RS384" || alg == "RS512"
+	case *ecdsa.PublicKey:
+		return alg == "ES256" || alg == "ES384"
+	case ed25519.PublicKey:
+		return alg == "EdDSA"
+	}
+	return false
+}
+
+func parseJSONWebKey(jwk jsonWebKey) (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("oidc: invalid rsa exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("oidc: unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("oidc: ec key is not on the curve")
+		}
+		return key, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("oidc: unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("oidc: invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("oidc: unsupported key type %q", jwk.Kty)
+	}
+}
@@ -0,0 +1,173 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harshvse/go-api/internal/oidc/oidctest"
+)
+
+func newTestIssuer(t *testing.T) *oidctest.Issuer {
+	t.Helper()
+	issuer, err := oidctest.NewIssuer("client-id", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+func newTestProvider(issuer *oidctest.Issuer) *Provider {
+	return NewProvider(Config{
+		Name:         "test",
+		Issuer:       issuer.URL,
+		ClientID:     issuer.ClientID,
+		ClientSecret: issuer.ClientSecret,
+		RedirectURL:  "http://localhost:3000/callback",
+	}, nil)
+}
+
+// starts a login like the api does and returns what the callback needs
+func startLogin(t *testing.T, provider *Provider) (authorizationURL, nonce, verifier string) {
+	t.Helper()
+	state, _ := NewState()
+	nonce, _ = NewState()
+	verifier, _ = NewCodeVerifier()
+
+	authorizationURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	return authorizationURL, nonce, verifier
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(issuer)
+	ctx := context.Background()
+
+	authorizationURL, nonce, verifier := startLogin(t, provider)
+	query, _ := url.ParseQuery(authorizationURL[strings.Index(authorizationURL, "?")+1:])
+	if query.Get("redirect_uri") != "http://localhost:3000/callback" || query.Get("scope") != "openid email profile" {
+		t.Fatalf("unexpected authorization url %s", authorizationURL)
+	}
+
+	claims := issuer.Claims("subject-1", "jane@example.com")
+	claims["name"] = "Jane"
+	claims["preferred_username"] = "jane"
+	code, _, err := issuer.Authorize(authorizationURL, claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idToken, err := provider.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := IDToken{Subject: "subject-1", Email: "jane@example.com", EmailVerified: true, Name: "Jane", Username: "jane"}
+	if *idToken != want {
+		t.Fatalf("id token = %+v, want %+v", *idToken, want)
+	}
+
+	// the code works once
+	if _, err := provider.Exchange(ctx, code, verifier, nonce); err == nil {
+		t.Fatal("second exchange of the code succeeded")
+	}
+
+	// discovery and keys are cached between logins
+	authorizationURL, nonce, verifier = startLogin(t, provider)
+	code, _, _ = issuer.Authorize(authorizationURL, issuer.Claims("subject-1", "jane@example.com"))
+	if _, err := provider.Exchange(ctx, code, verifier, nonce); err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+	if hits := issuer.Hits("/.well-known/openid-configuration"); hits != 1 {
+		t.Errorf("discovery fetched %d times, want 1", hits)
+	}
+	if hits := issuer.Hits("/jwks"); hits != 1 {
+		t.Errorf("keys fetched %d times, want 1", hits)
+	}
+}
+
+func TestExchangeRejectsWrongCodeVerifier(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(issuer)
+
+	authorizationURL, nonce, _ := startLogin(t, provider)
+	code, _, _ := issuer.Authorize(authorizationURL, issuer.Claims("subject-1", "jane@example.com"))
+
+	otherVerifier, _ := NewCodeVerifier()
+	if _, err := provider.Exchange(context.Background(), code, otherVerifier, nonce); err == nil {
+		t.Fatal("exchange with the verifier of another login succeeded")
+	}
+}
+
+func TestExchangeRejectsWrongNonce(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(issuer)
+
+	authorizationURL, _, verifier := startLogin(t, provider)
+	code, _, _ := issuer.Authorize(authorizationURL, issuer.Claims("subject-1", "jane@example.com"))
+
+	otherNonce, _ := NewState()
+	if _, err := provider.Exchange(context.Background(), code, verifier, otherNonce); !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("exchange with another nonce = %v, want %v", err, ErrNonceMismatch)
+	}
+}
+
+func TestVerifyIDTokenRejectsBadClaims(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(issuer)
+
+	tests := []struct {
+		name   string
+		change func(jwt.MapClaims)
+	}{
+		{"other issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{"other audience", func(c jwt.MapClaims) { c["aud"] = "other-client" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "other" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := issuer.Claims("subject-1", "jane@example.com")
+			claims["nonce"] = "nonce"
+			tt.change(claims)
+
+			token, err := issuer.SignToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := provider.VerifyIDToken(context.Background(), token, "nonce"); err == nil {
+				t.Fatal("token accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRejectsUnknownKey(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider := newTestProvider(issuer)
+
+	// a token signed by another issuer's key under the kid we know
+	other := newTestIssuer(t)
+	claims := issuer.Claims("subject-1", "jane@example.com")
+	claims["nonce"] = "nonce"
+	token, _ := other.SignToken(claims)
+	if _, err := provider.VerifyIDToken(context.Background(), token, "nonce"); err == nil {
+		t.Fatal("token signed with another key accepted")
+	}
+
+	other.KeyID = "unknown"
+	token, _ = other.SignToken(claims)
+	if _, err := provider.VerifyIDToken(context.Background(), token, "nonce"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("token with unknown kid = %v, want %v", err, ErrUnknownKey)
+	}
+}
@@ -0,0 +1,227 @@
+// Package oidctest runs an OpenID Connect issuer in memory so the login flow
+// can be tested without a real provider
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Issuer serves discovery, the signing keys and the token endpoint. The user
+// consenting at the authorization endpoint is simulated by Authorize
+type Issuer struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	KeyID        string
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	sync.Mutex
+	requests map[string]authorization
+	// number of requests per path, to see what the relying party fetched
+	hits map[string]int
+}
+
+// what the issuer remembers between the authorization and the token request
+type authorization struct {
+	redirectURI   string
+	codeChallenge string
+	claims        jwt.MapClaims
+}
+
+func NewIssuer(clientID, clientSecret string) (*Issuer, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := &Issuer{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		KeyID:        "test-key",
+		key:          key,
+		requests:     make(map[string]authorization),
+		hits:         make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", issuer.discoveryHandler)
+	mux.HandleFunc("GET /jwks", issuer.jwksHandler)
+	mux.HandleFunc("POST /token", issuer.tokenHandler)
+	issuer.server = httptest.NewServer(issuer.count(mux))
+	issuer.URL = issuer.server.URL
+	return issuer, nil
+}
+
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+// returns how often the path was requested
+func (i *Issuer) Hits(path string) int {
+	i.Lock()
+	defer i.Unlock()
+	return i.hits[path]
+}
+
+func (i *Issuer) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i.Lock()
+		i.hits[r.URL.Path]++
+		i.Unlock()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Claims returns the claims of an ID token for the subject as the issuer would
+// send them, tests change them to send broken tokens
+func (i *Issuer) Claims(subject, email string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":            i.URL,
+		"aud":            i.ClientID,
+		"sub":            subject,
+		"email":          email,
+		"email_verified": true,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+	}
+}
+
+// SignToken signs the claims with the key published in the JWKS
+func (i *Issuer) SignToken(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = i.KeyID
+	return token.SignedString(i.key)
+}
+
+// Authorize plays the user logging in at the authorization url the relying
+// party built. It returns the code and state the browser would be redirected
+// back with, the nonce of the request is added to the claims unless they
+// already have one
+func (i *Issuer) Authorize(authorizationURL string, claims jwt.MapClaims) (string, string, error) {
+	u, err := url.Parse(authorizationURL)
+	if err != nil {
+		return "", "", err
+	}
+	query := u.Query()
+
+	switch {
+	case u.Scheme+"://"+u.Host+u.Path != i.URL+"/authorize":
+		return "", "", errors.New("oidctest: not the authorization endpoint")
+	case query.Get("response_type") != "code":
+		return "", "", errors.New("oidctest: response_type is not code")
+	case query.Get("client_id") != i.ClientID:
+		return "", "", errors.New("oidctest: unknown client")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return "", "", errors.New("oidctest: missing S256 code challenge")
+	case query.Get("state") == "":
+		return "", "", errors.New("oidctest: missing state")
+	}
+
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = query.Get("nonce")
+	}
+
+	code := randomString()
+	i.Lock()
+	i.requests[code] = authorization{
+		redirectURI:   query.Get("redirect_uri"),
+		codeChallenge: query.Get("code_challenge"),
+		claims:        claims,
+	}
+	i.Unlock()
+	return code, query.Get("state"), nil
+}
+
+func (i *Issuer) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 i.URL,
+		"authorization_endpoint": i.URL + "/authorize",
+		"token_endpoint":         i.URL + "/token",
+		"jwks_uri":               i.URL + "/jwks",
+	})
+}
+
+func (i *Issuer) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	encode := base64.RawURLEncoding.EncodeToString
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": i.KeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(i.key.N.Bytes()),
+			"e":   encode(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (i *Issuer) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != url.QueryEscape(i.ClientID) || clientSecret != url.QueryEscape(i.ClientSecret) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	// codes work once, whether the exchange succeeds or not
+	i.Lock()
+	request, ok := i.requests[r.PostForm.Get("code")]
+	delete(i.requests, r.PostForm.Get("code"))
+	i.Unlock()
+	if !ok || request.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != request.codeChallenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	idToken, err := i.SignToken(request.claims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
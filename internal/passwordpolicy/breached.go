@@ -0,0 +1,66 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachChecker reports whether a password is known from a data breach
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// RangeDirectory looks passwords up in a local copy of the Pwned Passwords
+// corpus split by hash prefix, the layout the k-anonymity range api serves:
+// the file <dir>/<first 5 hex chars of the SHA-1>.txt holds one
+// "<remaining 35 hex chars>:<count>" line per breached password. Only the
+// file of the prefix is read, so the corpus never has to fit into memory
+type RangeDirectory struct {
+	dir string
+}
+
+const hashPrefixLength = 5
+
+func NewRangeDirectory(dir string) (*RangeDirectory, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("passwordpolicy: %s is not a directory", dir)
+	}
+	return &RangeDirectory{dir: dir}, nil
+}
+
+func (d *RangeDirectory) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+
+	file, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if err != nil {
+		// a partial corpus has no file for prefixes it doesn't know
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// padding entries of the range api have a count of 0
+		if strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,76 @@
+package passwordpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+func newTestCorpus(t *testing.T) *RangeDirectory {
+	t.Helper()
+	dir := t.TempDir()
+
+	lines := []string{
+		"003D68EB55068C33ACE09247EE4C639306B:3",
+		// lower case like some mirrors write it
+		"1e4c9b93f3f0682250b6cf8331b7ee68fd8:9545824",
+		"",
+	}
+	if err := os.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(strings.Join(lines, "\r\n")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// SHA-1 of "hunter2" is F3BBBD66A63D4BF1747940578EC3D0103530E21D, the
+	// range api pads responses with entries that were never breached
+	padding := "D66A63D4BF1747940578EC3D0103530E21D:0\n"
+	if err := os.WriteFile(filepath.Join(dir, "F3BBB.txt"), []byte(padding), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	corpus, err := NewRangeDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return corpus
+}
+
+func TestRangeDirectory(t *testing.T) {
+	corpus := newTestCorpus(t)
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"password", true},
+		// a padding entry doesn't count
+		{"hunter2", false},
+		// no file for the prefix
+		{"x7#Kq9!vLm2$Rt", false},
+	}
+
+	for _, tt := range tests {
+		got, err := corpus.IsBreached(tt.password)
+		if err != nil {
+			t.Fatalf("IsBreached(%q): %v", tt.password, err)
+		}
+		if got != tt.want {
+			t.Errorf("IsBreached(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestNewRangeDirectoryRejectsFiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewRangeDirectory(file); err == nil {
+		t.Fatal("a file was accepted as corpus directory")
+	}
+	if _, err := NewRangeDirectory(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("a missing directory was accepted")
+	}
+}
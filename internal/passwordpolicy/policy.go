@@ -0,0 +1,128 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// the codes of the reasons a password is rejected for
+const (
+	CodeTooShort         = "too_short"
+	CodeTooLong          = "too_long"
+	CodeTooWeak          = "too_weak"
+	CodeContainsUserInfo = "contains_user_info"
+	CodeBreached         = "breached"
+)
+
+// parts of the username or email shorter than this are too common to reject
+// passwords for containing them
+const minUserInfoLength = 4
+
+type Policy struct {
+	MinLength int
+	MaxLength int
+	// the lowest acceptable Score, 0 accepts everything
+	MinScore int
+	// nil skips the breach check
+	Breached BreachChecker
+}
+
+// UserInfo is what a password must not be built from
+type UserInfo struct {
+	Username string
+	Email    string
+}
+
+// Violation is one reason a password was rejected
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (v Violation) Error() string {
+	return v.Message
+}
+
+// Check returns every rule the password breaks, an error is only returned
+// when the breach corpus couldn't be read
+func (p *Policy) Check(password string, user UserInfo) ([]Violation, error) {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("must be at most %d characters long", p.MaxLength),
+		})
+		// don't spend time estimating input nobody can use
+		return violations, nil
+	}
+
+	words := userWords(user)
+	if containsAny(strings.ToLower(password), words) {
+		violations = append(violations, Violation{
+			Code:    CodeContainsUserInfo,
+			Message: "must not contain your username or email",
+		})
+	}
+
+	if p.MinScore > 0 && Score(Strength(password, words)) < p.MinScore {
+		violations = append(violations, Violation{
+			Code:    CodeTooWeak,
+			Message: "is too easy to guess, use a longer password or a few unrelated words",
+		})
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Code:    CodeBreached,
+				Message: "has appeared in a data breach and can't be used",
+			})
+		}
+	}
+	return violations, nil
+}
+
+// the username, the local part of the email and the words they are made of
+func userWords(user UserInfo) []string {
+	localPart, _, _ := strings.Cut(user.Email, "@")
+
+	var words []string
+	for _, value := range []string{user.Username, localPart} {
+		value = strings.ToLower(value)
+		if utf8.RuneCountInString(value) >= minUserInfoLength {
+			words = append(words, value)
+		}
+		parts := strings.FieldsFunc(value, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, part := range parts {
+			if part != value && utf8.RuneCountInString(part) >= minUserInfoLength {
+				words = append(words, part)
+			}
+		}
+	}
+	return words
+}
+
+func containsAny(password string, words []string) bool {
+	for _, word := range words {
+		if strings.Contains(password, word) {
+			return true
+		}
+	}
+	return false
+}
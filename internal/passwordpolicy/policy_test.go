@@ -0,0 +1,49 @@
+package passwordpolicy
+
+import (
+	"slices"
+	"testing"
+)
+
+type breachedList []string
+
+func (b breachedList) IsBreached(password string) (bool, error) {
+	return slices.Contains(b, password), nil
+}
+
+func TestCheck(t *testing.T) {
+	policy := &Policy{
+		MinLength: 10,
+		MaxLength: 64,
+		MinScore:  3,
+		Breached:  breachedList{"gopher-lantern-quilt-77"},
+	}
+	user := UserInfo{Username: "janedoe", Email: "jane.doe@example.com"}
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"x7#Kq9!vLm2$Rt", nil},
+		{"x7#Kq9", []string{CodeTooShort, CodeTooWeak}},
+		{string(make([]byte, 65)), []string{CodeTooLong}},
+		{"password", []string{CodeTooShort, CodeTooWeak}},
+		{"janedoe-x7#Kq9!vLm2$Rt", []string{CodeContainsUserInfo}},
+		{"gopher-lantern-quilt-77", []string{CodeBreached}},
+	}
+
+	for _, tt := range tests {
+		violations, err := policy.Check(tt.password, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var codes []string
+		for _, violation := range violations {
+			codes = append(codes, violation.Code)
+		}
+		if !slices.Equal(codes, tt.want) {
+			t.Errorf("Check(%q) = %v, want %v", tt.password, codes, tt.want)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package passwordpolicy
+
+import (
+	_ "embed"
+	"math"
+	"strings"
+	"unicode"
+)
+
+//go:embed common.txt
+var commonPasswordList string
+
+// common passwords ranked by how often they are used, the rank is the number
+// of guesses an attacker needs
+var commonPasswords = rankedWords(commonPasswordList)
+
+// longer input is truncated, the rest can only make the password stronger
+const maxEstimatedLength = 100
+
+// the fewest guesses a match counts for, so patterns aren't rated cheaper than
+// brute forcing a character or two
+const (
+	minGuessesSingleChar = 10
+	minGuessesMultiChar  = 50
+)
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+}
+
+var leetSubstitutions = map[rune]rune{
+	'4': 'a',
+	'@': 'a',
+	'8': 'b',
+	'(': 'c',
+	'3': 'e',
+	'6': 'g',
+	'1': 'i',
+	'!': 'i',
+	'0': 'o',
+	'$': 's',
+	'5': 's',
+	'7': 't',
+	'+': 't',
+	'2': 'z',
+}
+
+type match struct {
+	// the match covers the runes i up to but not including j
+	i, j    int
+	guesses float64
+}
+
+// Score is 0 to 4 like zxcvbn: too guessable, very guessable, somewhat
+// guessable, safely unguessable and very unguessable
+func Score(log10Guesses float64) int {
+	switch {
+	case log10Guesses < 3:
+		return 0
+	case log10Guesses < 6:
+		return 1
+	case log10Guesses < 8:
+		return 2
+	case log10Guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Strength estimates the log10 of the guesses an attacker needs the way zxcvbn
+// does: the password is split into the cheapest sequence of patterns (common
+// passwords, keyboard walks, sequences, repeats, years and brute force) whose
+// guesses are combined. words are treated like the most common passwords
+func Strength(password string, words []string) float64 {
+	runes := []rune(password)
+	if len(runes) > maxEstimatedLength {
+		runes = runes[:maxEstimatedLength]
+	}
+	if len(runes) == 0 {
+		return 0
+	}
+
+	dictionary := commonPasswords
+	if len(words) > 0 {
+		dictionary = make(map[string]int, len(commonPasswords)+len(words))
+		for word, rank := range commonPasswords {
+			dictionary[word] = rank
+		}
+		for _, word := range words {
+			dictionary[strings.ToLower(word)] = 1
+		}
+	}
+
+	matches := findMatches(runes, dictionary)
+	return minimumGuesses(len(runes), matches)
+}
+
+func findMatches(runes []rune, dictionary map[string]int) []match {
+	var matches []match
+	matches = append(matches, dictionaryMatches(runes, dictionary)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes, dictionary)...)
+	matches = append(matches, yearMatches(runes)...)
+
+	// any part can be brute forced
+	for i := range runes {
+		for j := i + 1; j <= len(runes); j++ {
+			matches = append(matches, match{i: i, j: j, guesses: math.Pow(10, float64(j-i))})
+		}
+	}
+
+	for k := range matches {
+		floor := float64(minGuessesMultiChar)
+		if matches[k].j-matches[k].i == 1 {
+			floor = minGuessesSingleChar
+		}
+		matches[k].guesses = max(matches[k].guesses, floor)
+	}
+	return matches
+}
+
+// finds the cheapest way to cover the whole password with matches. Like
+// zxcvbn, a split into k parts costs k! times the product of the guesses of
+// the parts plus 10000^(k-1) so splitting into many cheap parts doesn't pay
+func minimumGuesses(n int, matches []match) float64 {
+	byEnd := make([][]match, n+1)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	inf := math.Inf(1)
+	// best[k][j] is the smallest sum of log10 guesses covering the first j
+	// runes with k matches
+	best := make([][]float64, n+1)
+	for k := range best {
+		best[k] = make([]float64, n+1)
+		for j := range best[k] {
+			best[k][j] = inf
+		}
+	}
+	best[0][0] = 0
+
+	for k := 1; k <= n; k++ {
+		for j := 1; j <= n; j++ {
+			for _, m := range byEnd[j] {
+				if prev := best[k-1][m.i]; prev < inf {
+					best[k][j] = min(best[k][j], prev+math.Log10(m.guesses))
+				}
+			}
+		}
+	}
+
+	result := inf
+	for k := 1; k <= n; k++ {
+		if best[k][n] == inf {
+			continue
+		}
+		lgamma, _ := math.Lgamma(float64(k + 1))
+		product := lgamma/math.Ln10 + best[k][n]
+		penalty := 4 * float64(k-1)
+		result = min(result, log10Sum(product, penalty))
+	}
+	return result
+}
+
+// returns log10(10^a + 10^b)
+func log10Sum(a, b float64) float64 {
+	high, low := max(a, b), min(a, b)
+	return high + math.Log10(1+math.Pow(10, low-high))
+}
+
+func dictionaryMatches(runes []rune, dictionary map[string]int) []match {
+	var matches []match
+	for i := range runes {
+		for j := i + 3; j <= len(runes); j++ {
+			part := runes[i:j]
+			lower := strings.ToLower(string(part))
+			variations := uppercaseVariations(part)
+
+			if rank, ok := dictionary[lower]; ok {
+				matches = append(matches, match{i: i, j: j, guesses: float64(rank) * variations})
+			}
+
+			unleeted, substitutions := unleet(lower)
+			if substitutions == 0 {
+				continue
+			}
+			if rank, ok := dictionary[unleeted]; ok {
+				guesses := float64(rank) * variations * math.Pow(2, float64(substitutions))
+				matches = append(matches, match{i: i, j: j, guesses: guesses})
+			}
+		}
+	}
+	return matches
+}
+
+// the number of ways the letters of a word could have been capitalized, a
+// capital first or last letter and all caps are what people do most
+func uppercaseVariations(part []rune) float64 {
+	var upper, lower int
+	for _, r := range part {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && (unicode.IsUpper(part[0]) || unicode.IsUpper(part[len(part)-1]))) {
+		return 2
+	}
+
+	variations := 0.0
+	for k := 1; k <= min(upper, lower); k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return variations
+}
+
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result *= float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+func unleet(word string) (string, int) {
+	substitutions := 0
+	unleeted := strings.Map(func(r rune) rune {
+		if letter, ok := leetSubstitutions[r]; ok {
+			substitutions++
+			return letter
+		}
+		return r
+	}, word)
+	return unleeted, substitutions
+}
+
+// straight runs of at least four keys along a row, forwards or backwards
+func keyboardMatches(runes []rune) []match {
+	var matches []match
+	lower := []rune(strings.ToLower(string(runes)))
+	for i := range lower {
+		for j := i + 4; j <= len(lower); j++ {
+			part := string(lower[i:j])
+			if onKeyboardRow(part) || onKeyboardRow(reverse(part)) {
+				// a start key, a direction and the length
+				matches = append(matches, match{i: i, j: j, guesses: 47 * 2 * float64(j-i)})
+			}
+		}
+	}
+	return matches
+}
+
+func onKeyboardRow(part string) bool {
+	for _, row := range keyboardRows {
+		if strings.Contains(row, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// runs like abcd, 4321 or acegi where every step is the same
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i+2 < len(runes); {
+		delta := runes[i+1] - runes[i]
+		if delta == 0 || delta > 5 || delta < -5 || !sameClass(runes[i], runes[i+1]) {
+			i++
+			continue
+		}
+
+		j := i + 2
+		for j < len(runes) && runes[j]-runes[j-1] == delta && sameClass(runes[j-1], runes[j]) {
+			j++
+		}
+
+		if j-i >= 3 {
+			var guesses float64
+			switch first := unicode.ToLower(runes[i]); {
+			case strings.ContainsRune("az019", first):
+				guesses = 4
+			case unicode.IsDigit(first):
+				guesses = 10
+			default:
+				guesses = 26
+			}
+			if delta < 0 {
+				guesses *= 2
+			}
+			matches = append(matches, match{i: i, j: j, guesses: guesses * float64(j-i)})
+			i = j
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+func sameClass(a, b rune) bool {
+	switch {
+	case unicode.IsDigit(a):
+		return unicode.IsDigit(b)
+	case unicode.IsLower(a):
+		return unicode.IsLower(b)
+	case unicode.IsUpper(a):
+		return unicode.IsUpper(b)
+	}
+	return false
+}
+
+// a part written several times in a row, the guesses are those of the part
+// times the number of repetitions. Only the repeat covering the most runes is
+// taken at every position
+func repeatMatches(runes []rune, dictionary map[string]int) []match {
+	var matches []match
+	unitGuesses := make(map[string]float64)
+	for i := range runes {
+		bestSize, bestCount := 0, 0
+		for size := 1; i+2*size <= len(runes); size++ {
+			unit := string(runes[i : i+size])
+			count := 1
+			for end := i + size; end+size <= len(runes) && string(runes[end:end+size]) == unit; end += size {
+				count++
+			}
+			if count < 2 || (size == 1 && count < 3) {
+				continue
+			}
+			if size*count > bestSize*bestCount {
+				bestSize, bestCount = size, count
+			}
+		}
+		if bestCount == 0 {
+			continue
+		}
+
+		unit := runes[i : i+bestSize]
+		guesses, ok := unitGuesses[string(unit)]
+		if !ok {
+			guesses = math.Pow(10, minimumGuesses(bestSize, findMatches(unit, dictionary)))
+			unitGuesses[string(unit)] = guesses
+		}
+		matches = append(matches, match{i: i, j: i + bestSize*bestCount, guesses: guesses * float64(bestCount)})
+	}
+	return matches
+}
+
+// four digit years from 1900 to 2099
+func yearMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i+4 <= len(runes); i++ {
+		year := string(runes[i : i+4])
+		if (strings.HasPrefix(year, "19") || strings.HasPrefix(year, "20")) && isDigits(year) {
+			matches = append(matches, match{i: i, j: i + 4, guesses: 50})
+		}
+	}
+	return matches
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func rankedWords(list string) map[string]int {
+	words := make(map[string]int)
+	for rank, word := range strings.Fields(list) {
+		if _, ok := words[word]; !ok {
+			words[word] = rank + 1
+		}
+	}
+	return words
+}
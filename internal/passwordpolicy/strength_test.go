@@ -0,0 +1,80 @@
+package passwordpolicy
+
+import "testing"
+
+func TestStrengthRatesGuessablePasswordsWeak(t *testing.T) {
+	weak := []string{
+		"",
+		"password",
+		"Password1",
+		"P@ssw0rd",
+		"123456789",
+		"qwertyuiop",
+		"abcdefghij",
+		"zzzzzzzzzz",
+		"iloveyou",
+		"summer2019",
+		"asdfasdfasdf",
+		// built from the words of the user
+		"janedoe2024",
+	}
+
+	for _, password := range weak {
+		if score := Score(Strength(password, []string{"janedoe"})); score >= 3 {
+			t.Errorf("%q scored %d, want less than 3", password, score)
+		}
+	}
+}
+
+func TestStrengthRatesUnguessablePasswordsStrong(t *testing.T) {
+	strong := []string{
+		"correct horse battery staple",
+		"Tr0ub4dor&3",
+		"x7#Kq9!vLm2$Rt",
+		"gopher-lantern-quilt-77",
+	}
+
+	for _, password := range strong {
+		if score := Score(Strength(password, []string{"janedoe"})); score < 3 {
+			t.Errorf("%q scored %d, want at least 3", password, score)
+		}
+	}
+}
+
+func TestStrengthGrowsWithLength(t *testing.T) {
+	short := Strength("kq9vlm", nil)
+	long := Strength("kq9vlmx7rtwp", nil)
+	if long <= short {
+		t.Fatalf("Strength of 12 random characters %.2f, want more than the %.2f of 6", long, short)
+	}
+
+	// everything past the estimated length only makes a password stronger
+	huge := make([]byte, maxEstimatedLength*2)
+	for i := range huge {
+		huge[i] = 'a' + byte(i*7%26)
+	}
+	if Strength(string(huge), nil) <= 0 {
+		t.Fatal("very long password rated as guessable")
+	}
+}
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		log10Guesses float64
+		want         int
+	}{
+		{0, 0},
+		{2.99, 0},
+		{3, 1},
+		{6, 2},
+		{8, 3},
+		{10, 4},
+		{40, 4},
+	}
+
+	for _, tt := range tests {
+		if got := Score(tt.log10Guesses); got != tt.want {
+			t.Errorf("Score(%v) = %d, want %d", tt.log10Guesses, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimiter
+
+import (
+	"sync"
+	"time"
+)
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// FixedWindowLimiter allows limit requests per key in every window. State is
+// kept in memory so every instance of the server counts on its own
+type FixedWindowLimiter struct {
+	sync.Mutex
+	windows map[string]*window
+	limit   int
+	window  time.Duration
+	now     func() time.Time
+	// when the map was last swept for finished windows
+	lastEvict time.Time
+}
+
+func NewFixedWindowLimiter(limit int, windowSize time.Duration) *FixedWindowLimiter {
+	return &FixedWindowLimiter{
+		windows: make(map[string]*window),
+		limit:   limit,
+		window:  windowSize,
+		now:     time.Now,
+	}
+}
+
+func (l *FixedWindowLimiter) Allow(key string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.now()
+	if !now.Before(l.lastEvict.Add(l.window)) {
+		l.evict(now)
+		l.lastEvict = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || !now.Before(w.start.Add(l.window)) {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// drops finished windows so the map doesn't grow with every key ever seen,
+// it runs at most once per window so a request doesn't pay for every client
+func (l *FixedWindowLimiter) evict(now time.Time) {
+	for key, w := range l.windows {
+		if !now.Before(w.start.Add(l.window)) {
+			delete(l.windows, key)
+		}
+	}
+}
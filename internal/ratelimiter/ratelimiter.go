@@ -0,0 +1,9 @@
+package ratelimiter
+
+import "time"
+
+type Limiter interface {
+	// reports whether another request for key is allowed and if not how long
+	// the caller has to wait before trying again
+	Allow(key string) (bool, time.Duration)
+}
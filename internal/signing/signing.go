@@ -0,0 +1,210 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// headers a signed request carries, the signature is the hex encoded
+// HMAC-SHA256 of CanonicalRequest keyed with the client secret
+const (
+	HeaderKeyID     = "X-Signature-Key-Id"
+	HeaderTimestamp = "X-Signature-Timestamp"
+	HeaderNonce     = "X-Signature-Nonce"
+	HeaderSignature = "X-Signature"
+)
+
+const maxNonceLength = 128
+
+var (
+	ErrNotSigned         = errors.New("signing: request is not signed")
+	ErrInvalidTimestamp  = errors.New("signing: invalid timestamp")
+	ErrInvalidNonce      = errors.New("signing: invalid nonce")
+	ErrSignatureMismatch = errors.New("signing: signature does not match")
+)
+
+// CanonicalRequest returns the string that gets signed, one line each for
+//
+//	the upper case method
+//	the escaped path
+//	the query sorted by key and value, keys and values query escaped
+//	the unix timestamp in seconds
+//	the nonce
+//	the hex encoded sha256 of the body
+func CanonicalRequest(method string, u *url.URL, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		canonicalPath(u),
+		canonicalQuery(u),
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+func canonicalPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func canonicalQuery(u *url.URL) string {
+	values, _ := url.ParseQuery(u.RawQuery)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		vals := append([]string{}, values[key]...)
+		sort.Strings(vals)
+		for _, value := range vals {
+			pairs = append(pairs, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func compute(secret string, method string, u *url.URL, timestamp, nonce string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(CanonicalRequest(method, u, timestamp, nonce, body)))
+	return mac.Sum(nil)
+}
+
+// Signer signs the requests of a service client, batch jobs and other services
+// calling the api create one from the key id and secret they were given
+type Signer struct {
+	KeyID  string
+	Secret string
+}
+
+func NewSigner(keyID, secret string) *Signer {
+	return &Signer{KeyID: keyID, Secret: secret}
+}
+
+// adds the signature headers to the request. The body is read and put back so
+// the request can still be sent
+func (s *Signer) Sign(r *http.Request) error {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	encodedNonce := base64.RawURLEncoding.EncodeToString(nonce)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	r.Header.Set(HeaderKeyID, s.KeyID)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, encodedNonce)
+	r.Header.Set(HeaderSignature, hex.EncodeToString(compute(s.Secret, r.Method, r.URL, timestamp, encodedNonce, body)))
+	return nil
+}
+
+// Transport signs every request before handing it to Base, use it as the
+// transport of an http.Client. A nil Base means http.DefaultTransport
+type Transport struct {
+	Signer *Signer
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request it was given
+	signed := r.Clone(r.Context())
+	if err := t.Signer.Sign(signed); err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// Signature is what a signed request carries in its headers
+type Signature struct {
+	KeyID     string
+	Nonce     string
+	Timestamp time.Time
+
+	rawTimestamp string
+	value        []byte
+}
+
+// reads the signature headers of a request without checking the signature,
+// the caller looks up the secret by KeyID and calls Verify
+func Parse(r *http.Request) (*Signature, error) {
+	keyID := r.Header.Get(HeaderKeyID)
+	rawTimestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	rawSignature := r.Header.Get(HeaderSignature)
+	if keyID == "" || rawTimestamp == "" || nonce == "" || rawSignature == "" {
+		return nil, ErrNotSigned
+	}
+
+	seconds, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidTimestamp
+	}
+
+	if len(nonce) > maxNonceLength {
+		return nil, ErrInvalidNonce
+	}
+
+	value, err := hex.DecodeString(rawSignature)
+	if err != nil {
+		return nil, ErrSignatureMismatch
+	}
+
+	return &Signature{
+		KeyID:        keyID,
+		Nonce:        nonce,
+		Timestamp:    time.Unix(seconds, 0),
+		rawTimestamp: rawTimestamp,
+		value:        value,
+	}, nil
+}
+
+// checks the signature against the secret of the client, body is the body
+// that was read from the request
+func (sig *Signature) Verify(r *http.Request, body []byte, secret string) error {
+	expected := compute(secret, r.Method, r.URL, sig.rawTimestamp, sig.Nonce, body)
+	if !hmac.Equal(expected, sig.value) {
+		return ErrSignatureMismatch
+	}
+	return nil
+}
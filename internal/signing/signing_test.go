@@ -0,0 +1,144 @@
+package signing
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testKeyID  = "svc_test"
+	testSecret = "s3cret"
+)
+
+func newSignedRequest(t *testing.T, method, target, body string) *http.Request {
+	t.Helper()
+
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if err := NewSigner(testKeyID, testSecret).Sign(r); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// parses and verifies the request the way the server does
+func verify(r *http.Request, secret string) error {
+	sig, err := Parse(r)
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return sig.Verify(r, body, secret)
+}
+
+func TestSignParseVerify(t *testing.T) {
+	r := newSignedRequest(t, http.MethodDelete, "/v1/service/users/unactivated?older_than=720h", `{"dry_run":true}`)
+
+	sig, err := Parse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.KeyID != testKeyID {
+		t.Errorf("KeyID = %q, want %q", sig.KeyID, testKeyID)
+	}
+	if sig.Nonce == "" {
+		t.Error("the request was signed without a nonce")
+	}
+	if skew := time.Since(sig.Timestamp); skew < 0 || skew > time.Minute {
+		t.Errorf("Timestamp is %s off", skew)
+	}
+
+	// Sign puts the body back for whoever sends the request
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != `{"dry_run":true}` {
+		t.Fatalf("body after signing = %q", body)
+	}
+
+	if err := sig.Verify(r, body, testSecret); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := sig.Verify(r, body, "another secret"); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("Verify with another secret = %v, want ErrSignatureMismatch", err)
+	}
+}
+
+func TestQueryOrderDoesNotChangeTheSignature(t *testing.T) {
+	r := newSignedRequest(t, http.MethodGet, "/v1/service/report?b=2&a=1&a=0", "")
+
+	// a proxy may put the parameters in another order
+	reordered := httptest.NewRequest(http.MethodGet, "/v1/service/report?a=0&b=2&a=1", nil)
+	reordered.Header = r.Header.Clone()
+	if err := verify(reordered, testSecret); err != nil {
+		t.Fatalf("reordered query: %v", err)
+	}
+
+	changed := httptest.NewRequest(http.MethodGet, "/v1/service/report?a=0&b=3&a=1", nil)
+	changed.Header = r.Header.Clone()
+	if err := verify(changed, testSecret); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("changed query = %v, want ErrSignatureMismatch", err)
+	}
+}
+
+func TestTamperedRequestsFail(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"body", http.MethodPost, "/v1/service/jobs", `{"limit":1000}`},
+		{"path", http.MethodPost, "/v1/service/other", `{"limit":10}`},
+		{"method", http.MethodPut, "/v1/service/jobs", `{"limit":10}`},
+	}
+
+	for _, tt := range tests {
+		original := newSignedRequest(t, http.MethodPost, "/v1/service/jobs", `{"limit":10}`)
+
+		tampered := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		tampered.Header = original.Header.Clone()
+		if err := verify(tampered, testSecret); !errors.Is(err, ErrSignatureMismatch) {
+			t.Errorf("tampered %s = %v, want ErrSignatureMismatch", tt.name, err)
+		}
+	}
+
+	// the timestamp is signed too, moving it forward breaks the signature
+	r := newSignedRequest(t, http.MethodPost, "/v1/service/jobs", `{"limit":10}`)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+	if err := verify(r, testSecret); !errors.Is(err, ErrSignatureMismatch) {
+		t.Errorf("tampered timestamp = %v, want ErrSignatureMismatch", err)
+	}
+}
+
+func TestParseRejectsMalformedHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   error
+	}{
+		{"missing key id", HeaderKeyID, "", ErrNotSigned},
+		{"missing signature", HeaderSignature, "", ErrNotSigned},
+		{"timestamp that isn't a number", HeaderTimestamp, "yesterday", ErrInvalidTimestamp},
+		{"overlong nonce", HeaderNonce, strings.Repeat("n", maxNonceLength+1), ErrInvalidNonce},
+		{"signature that isn't hex", HeaderSignature, "not hex", ErrSignatureMismatch},
+	}
+
+	for _, tt := range tests {
+		r := newSignedRequest(t, http.MethodGet, "/v1/service/report", "")
+		r.Header.Set(tt.header, tt.value)
+		if _, err := Parse(r); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Parse = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrDuplicateAdmin = errors.New("an admin already exists with that username")
+
+// Admin is an operator account for the basic auth protected admin endpoints,
+// it is unrelated to the admin role of users
+type Admin struct {
+	ID        int64    `json:"id"`
+	Username  string   `json:"username"`
+	Password  password `json:"-"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// AdminAuditEntry records one request made with an admin account
+type AdminAuditEntry struct {
+	ID        int64  `json:"id"`
+	AdminID   *int64 `json:"admin_id"`
+	Username  string `json:"username"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	IP        string `json:"ip"`
+	Status    int    `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+type AdminStore struct {
+	db *sql.DB
+}
+
+func (s *AdminStore) GetByUsername(ctx context.Context, username string) (*Admin, error) {
+	query := `SELECT id, username, password, created_at FROM admin_accounts WHERE username = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var admin Admin
+	err := s.db.QueryRowContext(ctx, query, username).Scan(
+		&admin.ID,
+		&admin.Username,
+		&admin.Password.hash,
+		&admin.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &admin, nil
+}
+
+func (s *AdminStore) List(ctx context.Context) ([]*Admin, error) {
+	query := `SELECT id, username, created_at FROM admin_accounts ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	admins := []*Admin{}
+	for rows.Next() {
+		var admin Admin
+		if err := rows.Scan(&admin.ID, &admin.Username, &admin.CreatedAt); err != nil {
+			return nil, err
+		}
+		admins = append(admins, &admin)
+	}
+	return admins, rows.Err()
+}
+
+func (s *AdminStore) Count(ctx context.Context) (int64, error) {
+	query := `SELECT COUNT(*) FROM admin_accounts`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var count int64
+	if err := s.db.QueryRowContext(ctx, query).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *AdminStore) Create(ctx context.Context, admin *Admin) error {
+	query := `INSERT INTO admin_accounts (username, password) VALUES ($1, $2) RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, admin.Username, admin.Password.hash).Scan(
+		&admin.ID,
+		&admin.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "admin_accounts_username_key"`:
+			return ErrDuplicateAdmin
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AdminStore) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM admin_accounts WHERE id = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *AdminStore) RecordAudit(ctx context.Context, entry *AdminAuditEntry) error {
+	query := `
+		INSERT INTO admin_audit_log (admin_id, username, method, path, ip, status)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		entry.AdminID,
+		entry.Username,
+		entry.Method,
+		entry.Path,
+		entry.IP,
+		entry.Status,
+	).Scan(
+		&entry.ID,
+		&entry.CreatedAt,
+	)
+}
+
+func (s *AdminStore) ListAudit(ctx context.Context, fq PaginatedFeedQuery) ([]*AdminAuditEntry, error) {
+	order := "DESC"
+	if fq.Sort == "asc" {
+		order = "ASC"
+	}
+	query := `
+		SELECT id, admin_id, username, method, path, ip, status, created_at
+		FROM admin_audit_log
+		ORDER BY id ` + order + `
+		LIMIT $1 OFFSET $2
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, fq.Limit, fq.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AdminAuditEntry{}
+	for rows.Next() {
+		var entry AdminAuditEntry
+		err := rows.Scan(
+			&entry.ID,
+			&entry.AdminID,
+			&entry.Username,
+			&entry.Method,
+			&entry.Path,
+			&entry.IP,
+			&entry.Status,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 )
 
 type Comment struct {
@@ -82,3 +83,50 @@ func (s *CommentStore) GetPostByID(ctx context.Context, postId int64) ([]PostWit
 	}
 	return postWithComments, nil
 }
+
+func (s *CommentStore) GetByID(ctx context.Context, commentId int64) (*Comment, error) {
+	query := `SELECT id, post_id, user_id, content, created_at FROM comments WHERE id=($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var comment Comment
+	err := s.db.QueryRowContext(ctx, query, commentId).Scan(
+		&comment.ID,
+		&comment.PostID,
+		&comment.UserID,
+		&comment.Content,
+		&comment.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &comment, nil
+}
+
+func (s *CommentStore) Delete(ctx context.Context, commentId int64) error {
+	query := `DELETE FROM comments WHERE id=($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, commentId)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
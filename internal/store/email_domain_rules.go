@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrDuplicateDomainRule = errors.New("a rule already exists for that domain")
+
+// EmailDomainRule allows or denies registering with addresses at Domain and
+// its subdomains, Action is emailpolicy.ActionAllow or emailpolicy.ActionDeny
+type EmailDomainRule struct {
+	ID               int64  `json:"id"`
+	Domain           string `json:"domain"`
+	Action           string `json:"action"`
+	Note             string `json:"note"`
+	CreatedByAdminID *int64 `json:"created_by_admin_id,omitempty"`
+	CreatedAt        string `json:"created_at"`
+}
+
+type EmailDomainRuleStore struct {
+	db *sql.DB
+}
+
+func (s *EmailDomainRuleStore) List(ctx context.Context) ([]*EmailDomainRule, error) {
+	query := `
+		SELECT id, domain, action, note, created_by_admin_id, created_at
+		FROM email_domain_rules
+		ORDER BY domain
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []*EmailDomainRule{}
+	for rows.Next() {
+		var rule EmailDomainRule
+		err := rows.Scan(
+			&rule.ID,
+			&rule.Domain,
+			&rule.Action,
+			&rule.Note,
+			&rule.CreatedByAdminID,
+			&rule.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *EmailDomainRuleStore) Create(ctx context.Context, rule *EmailDomainRule) error {
+	query := `
+		INSERT INTO email_domain_rules (domain, action, note, created_by_admin_id)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		rule.Domain,
+		rule.Action,
+		rule.Note,
+		rule.CreatedByAdminID,
+	).Scan(
+		&rule.ID,
+		&rule.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "email_domain_rules_domain_key"`:
+			return ErrDuplicateDomainRule
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *EmailDomainRuleStore) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM email_domain_rules WHERE id = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Identity links an account at an external OpenID Connect provider to a user
+type Identity struct {
+	ID        int64  `json:"id"`
+	UserID    int64  `json:"user_id"`
+	Provider  string `json:"provider"`
+	Subject   string `json:"subject"`
+	Email     string `json:"email"`
+	CreatedAt string `json:"created_at"`
+}
+
+// OAuthState is what we remember between sending the user to the provider
+// and the callback. UserID is set when an identity is being linked
+type OAuthState struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	UserID       int64
+}
+
+type IdentityStore struct {
+	db *sql.DB
+}
+
+func (s *IdentityStore) GetBySubject(ctx context.Context, provider, subject string) (*Identity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, COALESCE(email, ''), created_at
+		FROM user_identities
+		WHERE provider = ($1) AND subject = ($2)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var identity Identity
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(
+		&identity.ID,
+		&identity.UserID,
+		&identity.Provider,
+		&identity.Subject,
+		&identity.Email,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &identity, nil
+}
+
+func (s *IdentityStore) ListByUserID(ctx context.Context, userID int64) ([]*Identity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, COALESCE(email, ''), created_at
+		FROM user_identities
+		WHERE user_id = ($1)
+		ORDER BY created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		identity := &Identity{}
+		err := rows.Scan(
+			&identity.ID,
+			&identity.UserID,
+			&identity.Provider,
+			&identity.Subject,
+			&identity.Email,
+			&identity.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+func (s *IdentityStore) Create(ctx context.Context, identity *Identity) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		return createIdentity(ctx, tx, identity)
+	})
+}
+
+func (s *IdentityStore) Delete(ctx context.Context, userID, id int64) error {
+	query := `DELETE FROM user_identities WHERE id = ($1) AND user_id = ($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// stores the state under its hash, the plain state travels through the browser
+func (s *IdentityStore) CreateState(ctx context.Context, state string, oauthState *OAuthState, exp time.Duration) error {
+	query := `
+		INSERT INTO oauth_states (state, provider, nonce, code_verifier, user_id, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID sql.NullInt64
+	if oauthState.UserID != 0 {
+		userID = sql.NullInt64{Int64: oauthState.UserID, Valid: true}
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		query,
+		state,
+		oauthState.Provider,
+		oauthState.Nonce,
+		oauthState.CodeVerifier,
+		userID,
+		time.Now().Add(exp),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// deletes and returns the state, every state can be used for one callback only
+func (s *IdentityStore) ConsumeState(ctx context.Context, state, provider string) (*OAuthState, error) {
+	query := `
+		DELETE FROM oauth_states
+		WHERE state = ($1) AND provider = ($2) AND expiry > ($3)
+		RETURNING provider, nonce, code_verifier, user_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var oauthState OAuthState
+	var userID sql.NullInt64
+	err := s.db.QueryRowContext(ctx, query, state, provider, time.Now()).Scan(
+		&oauthState.Provider,
+		&oauthState.Nonce,
+		&oauthState.CodeVerifier,
+		&userID,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	oauthState.UserID = userID.Int64
+	return &oauthState, nil
+}
+
+// removes the states of flows that were never finished
+func (s *IdentityStore) DeleteExpiredStates(ctx context.Context) (int64, error) {
+	query := `DELETE FROM oauth_states WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func createIdentity(ctx context.Context, tx *sql.Tx, identity *Identity) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		identity.UserID,
+		identity.Provider,
+		identity.Subject,
+		identity.Email,
+	).Scan(
+		&identity.ID,
+		&identity.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "user_identities_provider_subject_key"`:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
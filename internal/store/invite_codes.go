@@ -0,0 +1,281 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidInviteCode  = errors.New("the invite code is invalid, used up or expired")
+	ErrTooManyInviteCodes = errors.New("too many invite codes that can still be used")
+)
+
+// referral chains are cut off at this depth
+const maxReferralDepth = 100
+
+// InviteCode lets up to MaxUses people register while registration is invite
+// only. Only the hash of the code is stored
+type InviteCode struct {
+	ID               int64      `json:"id"`
+	Code             string     `json:"-"`
+	CreatedByUserID  *int64     `json:"created_by_user_id,omitempty"`
+	CreatedByAdminID *int64     `json:"created_by_admin_id,omitempty"`
+	MaxUses          int        `json:"max_uses"`
+	Uses             int        `json:"uses"`
+	Expiry           *time.Time `json:"expiry"`
+	CreatedAt        string     `json:"created_at"`
+}
+
+// Referral is a user in a referral chain, Depth counts the steps from the
+// user the report was made for
+type Referral struct {
+	UserID     int64  `json:"user_id"`
+	Username   string `json:"username"`
+	ReferredBy *int64 `json:"referred_by"`
+	Depth      int    `json:"depth"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type InviteCodeStore struct {
+	db *sql.DB
+}
+
+func (s *InviteCodeStore) Create(ctx context.Context, code *InviteCode) error {
+	query := `
+		INSERT INTO invite_codes (code, created_by_user_id, created_by_admin_id, max_uses, expiry)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, uses, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	return s.db.QueryRowContext(
+		ctx,
+		query,
+		code.Code,
+		code.CreatedByUserID,
+		code.CreatedByAdminID,
+		code.MaxUses,
+		code.Expiry,
+	).Scan(
+		&code.ID,
+		&code.Uses,
+		&code.CreatedAt,
+	)
+}
+
+// creates a code of a user unless they already have maxActive codes that can
+// still be used, then it returns ErrTooManyInviteCodes
+func (s *InviteCodeStore) CreateForUser(ctx context.Context, code *InviteCode, maxActive int) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// the user row serializes concurrent requests of the same user
+		var userID int64
+		query := `SELECT id FROM users WHERE id = ($1) FOR UPDATE`
+		if err := tx.QueryRowContext(ctx, query, *code.CreatedByUserID).Scan(&userID); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		var active int
+		query = `
+			SELECT COUNT(*) FROM invite_codes
+			WHERE created_by_user_id = ($1) AND uses < max_uses AND (expiry IS NULL OR expiry > NOW())
+		`
+		if err := tx.QueryRowContext(ctx, query, userID).Scan(&active); err != nil {
+			return err
+		}
+		if active >= maxActive {
+			return ErrTooManyInviteCodes
+		}
+
+		query = `
+			INSERT INTO invite_codes (code, created_by_user_id, max_uses, expiry)
+			VALUES ($1, $2, $3, $4) RETURNING id, uses, created_at
+		`
+		return tx.QueryRowContext(ctx, query, code.Code, userID, code.MaxUses, code.Expiry).Scan(
+			&code.ID,
+			&code.Uses,
+			&code.CreatedAt,
+		)
+	})
+}
+
+func (s *InviteCodeStore) ListByUserID(ctx context.Context, userID int64) ([]*InviteCode, error) {
+	query := `
+		SELECT id, created_by_user_id, created_by_admin_id, max_uses, uses, expiry, created_at
+		FROM invite_codes
+		WHERE created_by_user_id = ($1)
+		ORDER BY id DESC
+	`
+	return s.list(ctx, query, userID)
+}
+
+func (s *InviteCodeStore) List(ctx context.Context) ([]*InviteCode, error) {
+	query := `
+		SELECT id, created_by_user_id, created_by_admin_id, max_uses, uses, expiry, created_at
+		FROM invite_codes
+		ORDER BY id DESC
+	`
+	return s.list(ctx, query)
+}
+
+func (s *InviteCodeStore) list(ctx context.Context, query string, args ...any) ([]*InviteCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := []*InviteCode{}
+	for rows.Next() {
+		var code InviteCode
+		err := rows.Scan(
+			&code.ID,
+			&code.CreatedByUserID,
+			&code.CreatedByAdminID,
+			&code.MaxUses,
+			&code.Uses,
+			&code.Expiry,
+			&code.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, &code)
+	}
+	return codes, rows.Err()
+}
+
+func (s *InviteCodeStore) Delete(ctx context.Context, id int64) error {
+	query := `DELETE FROM invite_codes WHERE id = ($1)`
+	return s.delete(ctx, query, id)
+}
+
+// deletes the code only when the user made it
+func (s *InviteCodeStore) DeleteForUser(ctx context.Context, userID, id int64) error {
+	query := `DELETE FROM invite_codes WHERE id = ($1) AND created_by_user_id = ($2)`
+	return s.delete(ctx, query, id, userID)
+}
+
+func (s *InviteCodeStore) delete(ctx context.Context, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// returns the user followed by whoever referred them, up to the first user
+// that registered without a referral
+func (s *InviteCodeStore) ReferralChain(ctx context.Context, userID int64) ([]*Referral, error) {
+	query := `
+		WITH RECURSIVE chain AS (
+			SELECT id, username, referred_by, created_at, 0 AS depth
+			FROM users WHERE id = ($1)
+			UNION ALL
+			SELECT u.id, u.username, u.referred_by, u.created_at, c.depth + 1
+			FROM users u JOIN chain c ON u.id = c.referred_by
+			WHERE c.depth < ($2)
+		)
+		SELECT id, username, referred_by, depth, created_at FROM chain ORDER BY depth
+	`
+	return s.listReferrals(ctx, query, userID, maxReferralDepth)
+}
+
+// returns everyone the user referred, directly or through the people they referred
+func (s *InviteCodeStore) Referrals(ctx context.Context, userID int64) ([]*Referral, error) {
+	query := `
+		WITH RECURSIVE tree AS (
+			SELECT id, username, referred_by, created_at, 1 AS depth
+			FROM users WHERE referred_by = ($1)
+			UNION ALL
+			SELECT u.id, u.username, u.referred_by, u.created_at, t.depth + 1
+			FROM users u JOIN tree t ON u.referred_by = t.id
+			WHERE t.depth < ($2)
+		)
+		SELECT id, username, referred_by, depth, created_at FROM tree ORDER BY depth, id
+	`
+	return s.listReferrals(ctx, query, userID, maxReferralDepth)
+}
+
+func (s *InviteCodeStore) listReferrals(ctx context.Context, query string, args ...any) ([]*Referral, error) {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referrals := []*Referral{}
+	for rows.Next() {
+		var referral Referral
+		err := rows.Scan(
+			&referral.UserID,
+			&referral.Username,
+			&referral.ReferredBy,
+			&referral.Depth,
+			&referral.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		referrals = append(referrals, &referral)
+	}
+	return referrals, rows.Err()
+}
+
+// counts a use of the code, ErrInvalidInviteCode when it doesn't exist, is
+// used up or expired. The row stays locked until tx ends
+func useInviteCode(ctx context.Context, tx *sql.Tx, code string) (*InviteCode, error) {
+	query := `
+		UPDATE invite_codes SET uses = uses + 1
+		WHERE code = ($1) AND uses < max_uses AND (expiry IS NULL OR expiry > NOW())
+		RETURNING id, created_by_user_id, created_by_admin_id, max_uses, uses, expiry, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var invite InviteCode
+	err := tx.QueryRowContext(ctx, query, code).Scan(
+		&invite.ID,
+		&invite.CreatedByUserID,
+		&invite.CreatedByAdminID,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.Expiry,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrInvalidInviteCode
+		default:
+			return nil, err
+		}
+	}
+	return &invite, nil
+}
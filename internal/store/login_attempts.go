@@ -0,0 +1,227 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginAttempt counts the failed logins for an account or an address
+type LoginAttempt struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// AttemptLimits tell RecordAttempt when a key has to wait before its next attempt
+type AttemptLimits struct {
+	// attempts allowed before any delay applies
+	FreeFailures int
+	// the wait after the first attempt past the free ones, doubled for every
+	// further attempt up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// attempts after which the key waits for the window to pass, 0 allows any number
+	MaxFailures int
+}
+
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+func (s *LoginAttemptStore) Get(ctx context.Context, key string) (*LoginAttempt, error) {
+	query := `SELECT key, failures, last_failure_at, locked_until FROM login_attempts WHERE key = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	attempt, err := scanLoginAttempt(s.db.QueryRowContext(ctx, query, key))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return attempt, nil
+}
+
+// counts an attempt at now and returns the new state, or ErrNotFound when the
+// key is locked or has to wait by the limits and nothing was counted. Checking
+// and counting in one statement keeps concurrent attempts from all getting
+// through before the first of them is counted. Attempts before forgetBefore
+// and those of an expired lockout start the count over
+func (s *LoginAttemptStore) RecordAttempt(ctx context.Context, key string, now, forgetBefore time.Time, limits AttemptLimits) (*LoginAttempt, error) {
+	query := `
+		INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failure_at < ($3) OR login_attempts.locked_until <= ($2) THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			locked_until = CASE
+				WHEN login_attempts.locked_until <= ($2) THEN NULL
+				ELSE login_attempts.locked_until
+			END,
+			last_failure_at = ($2)
+		WHERE login_attempts.locked_until <= ($2)
+			OR login_attempts.locked_until IS NULL AND login_attempts.last_failure_at < ($3)
+			OR login_attempts.locked_until IS NULL
+				AND (($7)::int = 0 OR login_attempts.failures < ($7)::int)
+				AND login_attempts.last_failure_at + make_interval(secs => CASE
+					WHEN login_attempts.failures <= ($4)::int THEN 0
+					ELSE LEAST(
+						($5)::float8 * power(2::float8, LEAST(login_attempts.failures - ($4)::int - 1, 62)),
+						($6)::float8
+					)
+				END) <= ($2)
+		RETURNING key, failures, last_failure_at, locked_until
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	// without a maximum the delay stays at the base
+	maxDelay := limits.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = limits.BaseDelay
+	}
+
+	attempt, err := scanLoginAttempt(s.db.QueryRowContext(
+		ctx,
+		query,
+		key,
+		now,
+		forgetBefore,
+		limits.FreeFailures,
+		max(limits.BaseDelay, 0).Seconds(),
+		max(maxDelay, 0).Seconds(),
+		limits.MaxFailures,
+	))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return attempt, nil
+}
+
+// locks the key until then when it has counted at least failures attempts and
+// reports whether it did, a key that is already locked stays as it is
+func (s *LoginAttemptStore) Lock(ctx context.Context, key string, failures int, until time.Time) (bool, error) {
+	query := `
+		UPDATE login_attempts SET locked_until = ($1)
+		WHERE key = ($2) AND failures >= ($3) AND locked_until IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, until, key, failures)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// takes back one counted attempt of the key
+func (s *LoginAttemptStore) Forgive(ctx context.Context, key string) error {
+	query := `UPDATE login_attempts SET failures = failures - 1 WHERE key = ($1) AND failures > 0`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// returns the keys that are locked at now
+func (s *LoginAttemptStore) ListLocked(ctx context.Context, now time.Time) ([]*LoginAttempt, error) {
+	query := `
+		SELECT key, failures, last_failure_at, locked_until
+		FROM login_attempts
+		WHERE locked_until > ($1)
+		ORDER BY locked_until DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []*LoginAttempt{}
+	for rows.Next() {
+		attempt, err := scanLoginAttempt(rows)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+func (s *LoginAttemptStore) Delete(ctx context.Context, key string) error {
+	query := `DELETE FROM login_attempts WHERE key = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, key)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// removes counters that are neither locked nor recent enough to matter
+func (s *LoginAttemptStore) DeleteStale(ctx context.Context, now, forgetBefore time.Time) (int64, error) {
+	query := `
+		DELETE FROM login_attempts
+		WHERE last_failure_at < ($2) AND (locked_until IS NULL OR locked_until <= ($1))
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, now, forgetBefore)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanLoginAttempt(row rowScanner) (*LoginAttempt, error) {
+	var attempt LoginAttempt
+	err := row.Scan(
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailureAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
@@ -0,0 +1,229 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type Passkey struct {
+	ID           int64      `json:"id"`
+	CredentialID []byte     `json:"credential_id"`
+	UserID       int64      `json:"user_id"`
+	PublicKey    []byte     `json:"-"`
+	Algorithm    int64      `json:"algorithm"`
+	AAGUID       []byte     `json:"aaguid"`
+	SignCount    uint32     `json:"-"`
+	Transports   []string   `json:"transports"`
+	Name         string     `json:"name"`
+	CreatedAt    string     `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+type PasskeyStore struct {
+	db *sql.DB
+}
+
+// remembers the challenge of a ceremony, userID is 0 for logins where the
+// user is only known once the browser answers
+func (s *PasskeyStore) CreateChallenge(ctx context.Context, challenge string, userID int64, ceremony string, exp time.Duration) error {
+	query := `INSERT INTO webauthn_challenges (challenge, user_id, ceremony, expiry) VALUES ($1, $2, $3, $4)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var user sql.NullInt64
+	if userID != 0 {
+		user = sql.NullInt64{Int64: userID, Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx, query, challenge, user, ceremony, time.Now().Add(exp))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// deletes the challenge and returns the user it was issued for, a challenge
+// can only be answered once
+func (s *PasskeyStore) ConsumeChallenge(ctx context.Context, challenge, ceremony string) (int64, error) {
+	query := `
+		DELETE FROM webauthn_challenges
+		WHERE challenge = ($1) AND ceremony = ($2) AND expiry > ($3)
+		RETURNING user_id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var userID sql.NullInt64
+	err := s.db.QueryRowContext(ctx, query, challenge, ceremony, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+	return userID.Int64, nil
+}
+
+// removes the challenges of ceremonies that were never finished
+func (s *PasskeyStore) DeleteExpiredChallenges(ctx context.Context) (int64, error) {
+	query := `DELETE FROM webauthn_challenges WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *PasskeyStore) Create(ctx context.Context, passkey *Passkey) error {
+	query := `
+		INSERT INTO passkeys (credential_id, user_id, public_key, algorithm, aaguid, sign_count, transports, name)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		passkey.CredentialID,
+		passkey.UserID,
+		passkey.PublicKey,
+		passkey.Algorithm,
+		passkey.AAGUID,
+		int64(passkey.SignCount),
+		pq.Array(passkey.Transports),
+		passkey.Name,
+	).Scan(
+		&passkey.ID,
+		&passkey.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "passkeys_credential_id_key"`:
+			return ErrConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PasskeyStore) GetByCredentialID(ctx context.Context, credentialID []byte) (*Passkey, error) {
+	query := `
+		SELECT id, credential_id, user_id, public_key, algorithm, aaguid, sign_count, transports, name, created_at, last_used_at
+		FROM passkeys
+		WHERE credential_id = ($1)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	passkey, err := scanPasskey(s.db.QueryRowContext(ctx, query, credentialID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return passkey, nil
+}
+
+func (s *PasskeyStore) ListByUserID(ctx context.Context, userID int64) ([]*Passkey, error) {
+	query := `
+		SELECT id, credential_id, user_id, public_key, algorithm, aaguid, sign_count, transports, name, created_at, last_used_at
+		FROM passkeys
+		WHERE user_id = ($1)
+		ORDER BY created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*Passkey{}
+	for rows.Next() {
+		passkey, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, passkey)
+	}
+	return passkeys, rows.Err()
+}
+
+func (s *PasskeyStore) UpdateSignCount(ctx context.Context, id int64, signCount uint32) error {
+	query := `UPDATE passkeys SET sign_count = ($2), last_used_at = NOW() WHERE id = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, id, int64(signCount))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *PasskeyStore) Delete(ctx context.Context, userID, id int64) error {
+	query := `DELETE FROM passkeys WHERE id = ($1) AND user_id = ($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPasskey(row rowScanner) (*Passkey, error) {
+	var passkey Passkey
+	var signCount int64
+	err := row.Scan(
+		&passkey.ID,
+		&passkey.CredentialID,
+		&passkey.UserID,
+		&passkey.PublicKey,
+		&passkey.Algorithm,
+		&passkey.AAGUID,
+		&signCount,
+		pq.Array(&passkey.Transports),
+		&passkey.Name,
+		&passkey.CreatedAt,
+		&passkey.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	passkey.SignCount = uint32(signCount)
+	return &passkey, nil
+}
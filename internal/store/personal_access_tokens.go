@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PersonalAccessToken lets scripts act as a user within the scopes it was given
+type PersonalAccessToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	CreatedAt  string     `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip"`
+}
+
+// reports whether the token was granted the scope
+func (t *PersonalAccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type PersonalAccessTokenStore struct {
+	db *sql.DB
+}
+
+func (s *PersonalAccessTokenStore) Create(ctx context.Context, token *PersonalAccessToken) error {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token, scopes, expiry)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		token.UserID,
+		token.Name,
+		token.Token,
+		pq.Array(token.Scopes),
+		token.ExpiresAt,
+	).Scan(
+		&token.ID,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// returns the token with the hash unless it has expired
+func (s *PersonalAccessTokenStore) GetByToken(ctx context.Context, token string) (*PersonalAccessToken, error) {
+	query := `
+		SELECT id, user_id, name, token, scopes, expiry, created_at, last_used_at, last_used_ip
+		FROM personal_access_tokens
+		WHERE token = ($1) AND (expiry IS NULL OR expiry > ($2))
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	accessToken, err := scanPersonalAccessToken(s.db.QueryRowContext(ctx, query, token, time.Now()))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return accessToken, nil
+}
+
+func (s *PersonalAccessTokenStore) ListByUserID(ctx context.Context, userID int64) ([]*PersonalAccessToken, error) {
+	query := `
+		SELECT id, user_id, name, token, scopes, expiry, created_at, last_used_at, last_used_ip
+		FROM personal_access_tokens
+		WHERE user_id = ($1)
+		ORDER BY created_at DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// stores when and from where the token was used, a token used again from the
+// same address within a minute is not written again
+func (s *PersonalAccessTokenStore) RecordUse(ctx context.Context, id int64, ip string) error {
+	query := `
+		UPDATE personal_access_tokens SET last_used_at = ($1), last_used_ip = ($2)
+		WHERE id = ($3) AND (last_used_at IS NULL OR last_used_at < ($4) OR last_used_ip IS DISTINCT FROM ($2))
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, query, now, ip, id, now.Add(-time.Minute))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *PersonalAccessTokenStore) Delete(ctx context.Context, userID, id int64) error {
+	query := `DELETE FROM personal_access_tokens WHERE id = ($1) AND user_id = ($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scanPersonalAccessToken(row rowScanner) (*PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.Token,
+		pq.Array(&token.Scopes),
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+		&token.LastUsedIP,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
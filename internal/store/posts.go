@@ -142,7 +142,7 @@ func (s *PostStore) GetUserFeed(ctx context.Context, userId int64, paginatedFeed
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, query, int64(61))
+	rows, err := s.db.QueryContext(ctx, query, userId)
 	if err != nil {
 		return nil, err
 	}
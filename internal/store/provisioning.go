@@ -0,0 +1,374 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/harshvse/go-api/internal/emailpolicy"
+)
+
+// ProvisioningToken lets the identity provider of a customer create and
+// deactivate users through the SCIM endpoints
+type ProvisioningToken struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"-"`
+	CreatedAt  string     `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type ProvisioningTokenStore struct {
+	db *sql.DB
+}
+
+func (s *ProvisioningTokenStore) Create(ctx context.Context, token *ProvisioningToken) error {
+	query := `INSERT INTO provisioning_tokens (name, token) VALUES ($1, $2) RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, token.Name, token.Token).Scan(
+		&token.ID,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// returns the token with the hash unless it has been revoked
+func (s *ProvisioningTokenStore) GetByToken(ctx context.Context, token string) (*ProvisioningToken, error) {
+	query := `
+		SELECT id, name, token, created_at, last_used_at, revoked_at
+		FROM provisioning_tokens
+		WHERE token = ($1) AND revoked_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	provisioningToken, err := scanProvisioningToken(s.db.QueryRowContext(ctx, query, token))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return provisioningToken, nil
+}
+
+func (s *ProvisioningTokenStore) List(ctx context.Context) ([]*ProvisioningToken, error) {
+	query := `
+		SELECT id, name, token, created_at, last_used_at, revoked_at
+		FROM provisioning_tokens
+		ORDER BY id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*ProvisioningToken{}
+	for rows.Next() {
+		token, err := scanProvisioningToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// the provider polls a lot, a use within the last minute is not written again
+func (s *ProvisioningTokenStore) RecordUse(ctx context.Context, id int64) error {
+	query := `
+		UPDATE provisioning_tokens SET last_used_at = ($1)
+		WHERE id = ($2) AND (last_used_at IS NULL OR last_used_at < ($3))
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, query, now, id, now.Add(-time.Minute))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *ProvisioningTokenStore) Revoke(ctx context.Context, id int64) error {
+	query := `UPDATE provisioning_tokens SET revoked_at = NOW() WHERE id = ($1) AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scanProvisioningToken(row rowScanner) (*ProvisioningToken, error) {
+	var token ProvisioningToken
+	err := row.Scan(
+		&token.ID,
+		&token.Name,
+		&token.Token,
+		&token.CreatedAt,
+		&token.LastUsedAt,
+		&token.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// narrows ListProvisioned down, empty fields match every user
+type UserFilter struct {
+	Username   string
+	Email      string
+	ExternalID string
+	Active     *bool
+}
+
+// returns the user whether or not the account is active, ErrNotFound when the
+// identity provider didn't create the account
+func (s *UserStore) GetProvisioned(ctx context.Context, id int64) (*User, error) {
+	query := `
+		SELECT id, email, username, created_at, updated_at, is_active, external_id, deactivated_at
+		FROM users
+		WHERE id = ($1) AND provisioned_at IS NOT NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	user, err := scanProvisionedUser(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// returns a page of the provisioned users matching the filter and how many
+// match in total
+func (s *UserStore) ListProvisioned(ctx context.Context, filter UserFilter, offset, limit int) ([]*User, int64, error) {
+	where := `
+		WHERE provisioned_at IS NOT NULL
+			AND ($1::text = '' OR LOWER(username) = LOWER($1::text))
+			AND ($2::citext = '' OR email = $2::citext)
+			AND ($3::text = '' OR external_id = $3::text)
+			AND ($4::boolean IS NULL OR is_active = $4)
+	`
+	args := []any{filter.Username, filter.Email, filter.ExternalID, filter.Active}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var total int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `
+		SELECT id, email, username, created_at, updated_at, is_active, external_id, deactivated_at
+		FROM users` + where + `
+		ORDER BY id
+		LIMIT $5 OFFSET $6
+	`
+	rows, err := s.db.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanProvisionedUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, rows.Err()
+}
+
+// creates a user for the identity provider with the provisioning token
+// tokenID, the account needs no activation
+func (s *UserStore) CreateProvisioned(ctx context.Context, user *User, tokenID int64) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.Create(ctx, tx, user); err != nil {
+			return err
+		}
+
+		query := `UPDATE users SET provisioned_at = NOW(), provisioned_by = ($1) WHERE id = ($2)`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, query, tokenID, user.ID); err != nil {
+			return err
+		}
+		return s.updateProvisioned(ctx, tx, user)
+	})
+}
+
+// stores the changes of the identity provider. A new email drops pending
+// password resets and email changes sent to the old one, a deactivated user
+// is signed out everywhere and loses pending activation links
+func (s *UserStore) UpdateProvisioned(ctx context.Context, user *User) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		emailChanged, err := s.provisionedEmailChanged(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+
+		if err := s.updateProvisioned(ctx, tx, user); err != nil {
+			return err
+		}
+
+		if emailChanged {
+			if err := s.deletePasswordResets(ctx, tx, user.ID); err != nil {
+				return err
+			}
+			if err := s.deleteEmailChanges(ctx, tx, user.ID); err != nil {
+				return err
+			}
+		}
+
+		if user.DeactivatedAt == nil {
+			return nil
+		}
+
+		if err := s.deleteUserInvitation(ctx, tx, user.ID); err != nil {
+			return err
+		}
+		return s.invalidateTokens(ctx, tx, user.ID)
+	})
+}
+
+// locks the provisioned user and reports whether user has another email than
+// the stored one
+func (s *UserStore) provisionedEmailChanged(ctx context.Context, tx *sql.Tx, user *User) (bool, error) {
+	query := `
+		SELECT email = ($1)::citext FROM users
+		WHERE id = ($2) AND provisioned_at IS NOT NULL
+		FOR UPDATE
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var same bool
+	if err := tx.QueryRowContext(ctx, query, user.Email, user.ID).Scan(&same); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrNotFound
+		default:
+			return false, err
+		}
+	}
+	return !same, nil
+}
+
+// deletes an account the identity provider created together with its posts
+// and comments, ErrNotFound for every other account
+func (s *UserStore) DeleteProvisioned(ctx context.Context, userID int64) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		query := `SELECT id FROM users WHERE id = ($1) AND provisioned_at IS NOT NULL FOR UPDATE`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if err := tx.QueryRowContext(ctx, query, userID).Scan(&userID); err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+		return s.deleteAccount(ctx, tx, userID)
+	})
+}
+
+func (s *UserStore) updateProvisioned(ctx context.Context, tx *sql.Tx, user *User) error {
+	query := `
+		UPDATE users
+		SET username = ($1), email = ($2), normalized_email = ($3), is_active = ($4), external_id = ($5),
+			deactivated_at = ($6), updated_at = NOW()
+		WHERE id = ($7) AND provisioned_at IS NOT NULL
+		RETURNING updated_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		user.Username,
+		user.Email,
+		emailpolicy.Normalize(user.Email),
+		user.IsActive,
+		user.ExternalID,
+		user.DeactivatedAt,
+		user.ID,
+	).Scan(&user.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_users_normalized_email"`:
+			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
+			return ErrDuplicateUsername
+		case err.Error() == `pq: duplicate key value violates unique constraint "users_external_id_key"`:
+			return ErrDuplicateExternal
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func scanProvisionedUser(row rowScanner) (*User, error) {
+	var user User
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&user.IsActive,
+		&user.ExternalID,
+		&user.DeactivatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,137 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrTokenReused = errors.New("refresh token has already been used")
+
+// a refresh token belongs to a family that starts at login, every rotation adds
+// a new token to the same family so a reused token can revoke the whole chain
+type RefreshToken struct {
+	ID        int64      `json:"id"`
+	Token     string     `json:"-"`
+	UserID    int64      `json:"user_id"`
+	FamilyID  string     `json:"family_id"`
+	Expiry    time.Time  `json:"expiry"`
+	UsedAt    *time.Time `json:"used_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt string     `json:"created_at"`
+}
+
+type RefreshTokenStore struct {
+	db *sql.DB
+}
+
+func (s *RefreshTokenStore) GetByToken(ctx context.Context, token string) (*RefreshToken, error) {
+	query := `
+		SELECT id, token, user_id, family_id, expiry, used_at, revoked_at, created_at
+		FROM refresh_tokens
+		WHERE token = ($1)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var refreshToken RefreshToken
+	err := s.db.QueryRowContext(ctx, query, token).Scan(
+		&refreshToken.ID,
+		&refreshToken.Token,
+		&refreshToken.UserID,
+		&refreshToken.FamilyID,
+		&refreshToken.Expiry,
+		&refreshToken.UsedAt,
+		&refreshToken.RevokedAt,
+		&refreshToken.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &refreshToken, nil
+}
+
+// marks the old token as used and stores its replacement in the same transaction.
+// if the old token was used in the meantime ErrTokenReused is returned
+func (s *RefreshTokenStore) Rotate(ctx context.Context, oldTokenID int64, next *RefreshToken) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.markUsed(ctx, tx, oldTokenID); err != nil {
+			return err
+		}
+		return s.create(ctx, tx, next)
+	})
+}
+
+// revokes every token of the family and ends the session it belongs to
+func (s *RefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = ($1) AND revoked_at IS NULL`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, query, familyID); err != nil {
+			return err
+		}
+
+		query = `UPDATE sessions SET revoked_at = NOW() WHERE id = ($1) AND revoked_at IS NULL`
+		if _, err := tx.ExecContext(ctx, query, familyID); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *RefreshTokenStore) create(ctx context.Context, tx *sql.Tx, token *RefreshToken) error {
+	query := `
+		INSERT INTO refresh_tokens (token, user_id, family_id, expiry)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := tx.QueryRowContext(
+		ctx,
+		query,
+		token.Token,
+		token.UserID,
+		token.FamilyID,
+		token.Expiry,
+	).Scan(
+		&token.ID,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *RefreshTokenStore) markUsed(ctx context.Context, tx *sql.Tx, id int64) error {
+	query := `
+		UPDATE refresh_tokens SET used_at = NOW()
+		WHERE id = ($1) AND used_at IS NULL AND revoked_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrTokenReused
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// access tokens are stateless, a revoked token is remembered by its jti until
+// it would have expired anyway
+type RevokedTokenStore struct {
+	db *sql.DB
+}
+
+func (s *RevokedTokenStore) Revoke(ctx context.Context, jti string, userID int64, expiry time.Time) error {
+	query := `INSERT INTO revoked_tokens (jti, user_id, expiry) VALUES ($1, $2, $3) ON CONFLICT (jti) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, jti, userID, expiry)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *RevokedTokenStore) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = ($1))`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var revoked bool
+	if err := s.db.QueryRowContext(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
+
+func (s *RevokedTokenStore) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM revoked_tokens WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
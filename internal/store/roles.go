@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+)
+
+// the role every user has until another one is granted
+const DefaultRole = "user"
+
+type Role struct {
+	ID          int64    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+}
+
+// reports whether the role grants the permission
+func (r *Role) HasPermission(permission string) bool {
+	if r == nil {
+		return false
+	}
+	for _, p := range r.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type RoleStore struct {
+	db *sql.DB
+}
+
+func (s *RoleStore) List(ctx context.Context) ([]*Role, error) {
+	query := `
+		SELECT r.id, r.name, r.description,
+			ARRAY(
+				SELECT p.code FROM permissions p
+				INNER JOIN role_permissions rp ON rp.permission_id = p.id
+				WHERE rp.role_id = r.id ORDER BY p.code
+			)
+		FROM roles r
+		ORDER BY r.id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	for rows.Next() {
+		role := &Role{}
+		err := rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			pq.Array(&role.Permissions),
+		)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// gives the user the role with the name, replacing the one it had
+func (s *RoleStore) SetUserRole(ctx context.Context, userID int64, roleName string) error {
+	query := `UPDATE users SET role_id = (SELECT id FROM roles WHERE name = ($1)) WHERE id = ($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM roles WHERE name = ($1))`, roleName).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	res, err := s.db.ExecContext(ctx, query, roleName, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
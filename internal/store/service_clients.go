@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var ErrNonceReused = errors.New("the nonce has already been used")
+
+// ServiceClient is another service of ours calling the api with signed
+// requests instead of a user token
+type ServiceClient struct {
+	ID         int64      `json:"id"`
+	KeyID      string     `json:"key_id"`
+	Name       string     `json:"name"`
+	Secret     string     `json:"-"`
+	CreatedAt  string     `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type ServiceClientStore struct {
+	db *sql.DB
+}
+
+func (s *ServiceClientStore) Create(ctx context.Context, client *ServiceClient) error {
+	query := `
+		INSERT INTO service_clients (key_id, name, secret)
+		VALUES ($1, $2, $3) RETURNING id, created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		client.KeyID,
+		client.Name,
+		client.Secret,
+	).Scan(
+		&client.ID,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// returns the client with the key id unless it has been revoked
+func (s *ServiceClientStore) GetByKeyID(ctx context.Context, keyID string) (*ServiceClient, error) {
+	query := `
+		SELECT id, key_id, name, secret, created_at, last_used_at, revoked_at
+		FROM service_clients
+		WHERE key_id = ($1) AND revoked_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	client, err := scanServiceClient(s.db.QueryRowContext(ctx, query, keyID))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+func (s *ServiceClientStore) List(ctx context.Context) ([]*ServiceClient, error) {
+	query := `
+		SELECT id, key_id, name, secret, created_at, last_used_at, revoked_at
+		FROM service_clients
+		ORDER BY id
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []*ServiceClient{}
+	for rows.Next() {
+		client, err := scanServiceClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+// revoked clients are kept so the key id is never handed out again
+func (s *ServiceClientStore) Revoke(ctx context.Context, id int64) error {
+	query := `UPDATE service_clients SET revoked_at = NOW() WHERE id = ($1) AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// remembers the nonce of a verified request until expiry and records the use
+// of the client, ErrNonceReused when the request is a replay
+func (s *ServiceClientStore) UseNonce(ctx context.Context, clientID int64, nonce string, expiry time.Time) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			INSERT INTO service_request_nonces (client_id, nonce, expiry)
+			VALUES ($1, $2, $3) ON CONFLICT (client_id, nonce) DO NOTHING
+		`
+		res, err := tx.ExecContext(ctx, query, clientID, nonce, expiry)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNonceReused
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE service_clients SET last_used_at = NOW() WHERE id = ($1)`, clientID)
+		return err
+	})
+}
+
+func (s *ServiceClientStore) DeleteExpiredNonces(ctx context.Context) (int64, error) {
+	query := `DELETE FROM service_request_nonces WHERE expiry < NOW()`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanServiceClient(row rowScanner) (*ServiceClient, error) {
+	var client ServiceClient
+	err := row.Scan(
+		&client.ID,
+		&client.KeyID,
+		&client.Name,
+		&client.Secret,
+		&client.CreatedAt,
+		&client.LastUsedAt,
+		&client.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
@@ -0,0 +1,217 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Session is one login of a user on a device. Its ID is the family of the
+// refresh tokens handed out for it and the sid claim of its access tokens
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int64     `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// when the user last proved who they are in this session, by logging in
+	// or confirming their password again
+	AuthTime  time.Time  `json:"auth_time"`
+	Expiry    time.Time  `json:"expiry"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// a session can be used until it is revoked or expires
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.Expiry)
+}
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+// stores the session together with the first refresh token of its family
+func (s *SessionStore) Create(ctx context.Context, session *Session, token *RefreshToken) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		query := `
+			INSERT INTO sessions (id, user_id, user_agent, ip, auth_time, expiry)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at, last_seen_at
+		`
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		err := tx.QueryRowContext(
+			ctx,
+			query,
+			session.ID,
+			session.UserID,
+			session.UserAgent,
+			session.IP,
+			session.AuthTime,
+			session.Expiry,
+		).Scan(
+			&session.CreatedAt,
+			&session.LastSeenAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		refreshTokens := &RefreshTokenStore{db: s.db}
+		return refreshTokens.create(ctx, tx, token)
+	})
+}
+
+func (s *SessionStore) GetByID(ctx context.Context, id string) (*Session, error) {
+	query := `
+		SELECT id, user_id, user_agent, ip, created_at, last_seen_at, auth_time, expiry, revoked_at
+		FROM sessions
+		WHERE id = ($1)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	session, err := scanSession(s.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// returns the sessions that are neither revoked nor expired
+func (s *SessionStore) ListActiveByUserID(ctx context.Context, userID int64) ([]*Session, error) {
+	query := `
+		SELECT id, user_id, user_agent, ip, created_at, last_seen_at, auth_time, expiry, revoked_at
+		FROM sessions
+		WHERE user_id = ($1) AND revoked_at IS NULL AND expiry > ($2)
+		ORDER BY last_seen_at DESC
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// stores that the session was just used, a session seen again from the same
+// address within a minute is not written again
+func (s *SessionStore) Touch(ctx context.Context, id, ip, userAgent string) error {
+	query := `
+		UPDATE sessions SET last_seen_at = ($1), ip = ($2), user_agent = ($3)
+		WHERE id = ($4) AND (last_seen_at < ($5) OR ip <> ($2))
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx, query, now, ip, userAgent, id, now.Add(-time.Minute))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// moves the expiry along with the refresh token that was just issued
+func (s *SessionStore) Extend(ctx context.Context, id string, expiry time.Time) error {
+	query := `UPDATE sessions SET expiry = ($1) WHERE id = ($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, expiry, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// records that the user of the active session just confirmed who they are
+func (s *SessionStore) Reauthenticate(ctx context.Context, id string, authTime time.Time) error {
+	query := `UPDATE sessions SET auth_time = ($1) WHERE id = ($2) AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, authTime, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ends the session of the user and revokes its refresh tokens, ErrNotFound
+// when the user has no such active session
+func (s *SessionStore) Revoke(ctx context.Context, userID int64, id string) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		query := `UPDATE sessions SET revoked_at = NOW() WHERE id = ($1) AND user_id = ($2) AND revoked_at IS NULL`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		res, err := tx.ExecContext(ctx, query, id, userID)
+		if err != nil {
+			return err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNotFound
+		}
+
+		query = `UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = ($1) AND revoked_at IS NULL`
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func scanSession(row rowScanner) (*Session, error) {
+	var session Session
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.AuthTime,
+		&session.Expiry,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
@@ -9,6 +9,7 @@ import (
 
 var (
 	ErrNotFound          = errors.New("resource not found")
+	ErrConflict          = errors.New("resource already exists")
 	QueryTimeoutDuration = time.Second * 5
 )
 
@@ -24,33 +25,180 @@ type Storage struct {
 		Create(context.Context, *sql.Tx, *User) error
 		GetByID(context.Context, int64) (*User, error)
 		GetByEmail(context.Context, string) (*User, error)
-		CreateAndInviteUser(context.Context, *User, string, time.Duration) error
+		CreateAndInviteUser(context.Context, *User, string, time.Duration, string) error
 		ActivateUser(context.Context, string) error
 		Delete(context.Context, int64) error
+		DeleteAccount(context.Context, int64) error
+		InvalidateTokens(context.Context, int64) error
+		CreatePasswordReset(context.Context, int64, string, time.Duration) error
+		ResetPassword(context.Context, string, *User) error
+		GetByPasswordResetToken(context.Context, string) (*User, error)
+		ChangePassword(context.Context, *User, string) error
+		RehashPassword(context.Context, *User, string) error
+		CreateMagicLink(context.Context, int64, string, time.Duration) error
+		ConsumeMagicLink(context.Context, int64, string) error
+		GetInactiveByEmail(context.Context, string) (*User, error)
+		ReplaceInvitation(context.Context, int64, string, time.Duration) error
+		ListExpiredInvitations(context.Context) ([]Invitation, error)
+		PurgeExpiredInvitations(context.Context) (int64, error)
+		ListUnactivated(context.Context, time.Duration) ([]*User, error)
+		PurgeUnactivated(context.Context, time.Duration) (int64, error)
+		CreateEmailChange(context.Context, int64, string, string, time.Duration) error
+		ConfirmEmailChange(context.Context, string) (*User, error)
+		CreateWithIdentity(context.Context, *User, *Identity) error
+		LinkIdentityAndActivate(context.Context, *User, *Identity) error
+		GetProvisioned(context.Context, int64) (*User, error)
+		ListProvisioned(context.Context, UserFilter, int, int) ([]*User, int64, error)
+		CreateProvisioned(context.Context, *User, int64) error
+		UpdateProvisioned(context.Context, *User) error
+		DeleteProvisioned(context.Context, int64) error
+		ExistsByNormalizedEmail(context.Context, string) (bool, error)
 	}
 	Comments interface {
 		Create(context.Context, *Comment) error
 		GetPostByID(context.Context, int64) ([]PostWithComments, error)
+		GetByID(context.Context, int64) (*Comment, error)
+		Delete(context.Context, int64) error
 	}
 	Followers interface {
 		Follow(context.Context, int64, int64) error
 		UnFollow(context.Context, int64, int64) error
 	}
+	RefreshTokens interface {
+		GetByToken(context.Context, string) (*RefreshToken, error)
+		Rotate(context.Context, int64, *RefreshToken) error
+		RevokeFamily(context.Context, string) error
+	}
+	RevokedTokens interface {
+		Revoke(context.Context, string, int64, time.Time) error
+		IsRevoked(context.Context, string) (bool, error)
+		DeleteExpired(context.Context) (int64, error)
+	}
+	TwoFactor interface {
+		GetByUserID(context.Context, int64) (*TwoFactor, error)
+		Enroll(context.Context, int64, string) error
+		Enable(context.Context, int64, []string) error
+		Disable(context.Context, int64) error
+		RecordStep(context.Context, int64, int64) error
+		UseRecoveryCode(context.Context, int64, string) error
+		ReplaceRecoveryCodes(context.Context, int64, []string) error
+	}
+	Passkeys interface {
+		CreateChallenge(context.Context, string, int64, string, time.Duration) error
+		ConsumeChallenge(context.Context, string, string) (int64, error)
+		DeleteExpiredChallenges(context.Context) (int64, error)
+		Create(context.Context, *Passkey) error
+		GetByCredentialID(context.Context, []byte) (*Passkey, error)
+		ListByUserID(context.Context, int64) ([]*Passkey, error)
+		UpdateSignCount(context.Context, int64, uint32) error
+		Delete(context.Context, int64, int64) error
+	}
+	Identities interface {
+		GetBySubject(context.Context, string, string) (*Identity, error)
+		ListByUserID(context.Context, int64) ([]*Identity, error)
+		Create(context.Context, *Identity) error
+		Delete(context.Context, int64, int64) error
+		CreateState(context.Context, string, *OAuthState, time.Duration) error
+		ConsumeState(context.Context, string, string) (*OAuthState, error)
+		DeleteExpiredStates(context.Context) (int64, error)
+	}
+	Roles interface {
+		List(context.Context) ([]*Role, error)
+		SetUserRole(context.Context, int64, string) error
+	}
+	LoginAttempts interface {
+		Get(context.Context, string) (*LoginAttempt, error)
+		RecordAttempt(context.Context, string, time.Time, time.Time, AttemptLimits) (*LoginAttempt, error)
+		Lock(context.Context, string, int, time.Time) (bool, error)
+		Forgive(context.Context, string) error
+		ListLocked(context.Context, time.Time) ([]*LoginAttempt, error)
+		Delete(context.Context, string) error
+		DeleteStale(context.Context, time.Time, time.Time) (int64, error)
+	}
+	Sessions interface {
+		Create(context.Context, *Session, *RefreshToken) error
+		GetByID(context.Context, string) (*Session, error)
+		ListActiveByUserID(context.Context, int64) ([]*Session, error)
+		Touch(context.Context, string, string, string) error
+		Extend(context.Context, string, time.Time) error
+		Reauthenticate(context.Context, string, time.Time) error
+		Revoke(context.Context, int64, string) error
+	}
+	InviteCodes interface {
+		Create(context.Context, *InviteCode) error
+		CreateForUser(context.Context, *InviteCode, int) error
+		ListByUserID(context.Context, int64) ([]*InviteCode, error)
+		List(context.Context) ([]*InviteCode, error)
+		Delete(context.Context, int64) error
+		DeleteForUser(context.Context, int64, int64) error
+		ReferralChain(context.Context, int64) ([]*Referral, error)
+		Referrals(context.Context, int64) ([]*Referral, error)
+	}
+	Admins interface {
+		GetByUsername(context.Context, string) (*Admin, error)
+		List(context.Context) ([]*Admin, error)
+		Count(context.Context) (int64, error)
+		Create(context.Context, *Admin) error
+		Delete(context.Context, int64) error
+		RecordAudit(context.Context, *AdminAuditEntry) error
+		ListAudit(context.Context, PaginatedFeedQuery) ([]*AdminAuditEntry, error)
+	}
+	PersonalAccessTokens interface {
+		Create(context.Context, *PersonalAccessToken) error
+		GetByToken(context.Context, string) (*PersonalAccessToken, error)
+		ListByUserID(context.Context, int64) ([]*PersonalAccessToken, error)
+		RecordUse(context.Context, int64, string) error
+		Delete(context.Context, int64, int64) error
+	}
+	ServiceClients interface {
+		Create(context.Context, *ServiceClient) error
+		GetByKeyID(context.Context, string) (*ServiceClient, error)
+		List(context.Context) ([]*ServiceClient, error)
+		Revoke(context.Context, int64) error
+		UseNonce(context.Context, int64, string, time.Time) error
+		DeleteExpiredNonces(context.Context) (int64, error)
+	}
+	ProvisioningTokens interface {
+		Create(context.Context, *ProvisioningToken) error
+		GetByToken(context.Context, string) (*ProvisioningToken, error)
+		List(context.Context) ([]*ProvisioningToken, error)
+		RecordUse(context.Context, int64) error
+		Revoke(context.Context, int64) error
+	}
+	EmailDomainRules interface {
+		List(context.Context) ([]*EmailDomainRule, error)
+		Create(context.Context, *EmailDomainRule) error
+		Delete(context.Context, int64) error
+	}
 }
 
 func NewPostgresStorage(db *sql.DB) Storage {
 	return Storage{
-		Posts:     &PostStore{db: db},
-		Users:     &UserStore{db: db},
-		Comments:  &CommentStore{db: db},
-		Followers: &FollowerStore{db: db},
+		Posts:                &PostStore{db: db},
+		Users:                &UserStore{db: db},
+		Comments:             &CommentStore{db: db},
+		Followers:            &FollowerStore{db: db},
+		RefreshTokens:        &RefreshTokenStore{db: db},
+		RevokedTokens:        &RevokedTokenStore{db: db},
+		TwoFactor:            &TwoFactorStore{db: db},
+		Passkeys:             &PasskeyStore{db: db},
+		Identities:           &IdentityStore{db: db},
+		PersonalAccessTokens: &PersonalAccessTokenStore{db: db},
+		Roles:                &RoleStore{db: db},
+		LoginAttempts:        &LoginAttemptStore{db: db},
+		Admins:               &AdminStore{db: db},
+		InviteCodes:          &InviteCodeStore{db: db},
+		Sessions:             &SessionStore{db: db},
+		ServiceClients:       &ServiceClientStore{db: db},
+		ProvisioningTokens:   &ProvisioningTokenStore{db: db},
+		EmailDomainRules:     &EmailDomainRuleStore{db: db},
 	}
 }
 
 func withTX(db *sql.DB, ctx context.Context, fn func(*sql.Tx) error) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	if err := fn(tx); err != nil {
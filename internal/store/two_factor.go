@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+var ErrCodeReused = errors.New("the code has already been used")
+
+type TwoFactor struct {
+	UserID       int64  `json:"user_id"`
+	Secret       string `json:"-"`
+	Enabled      bool   `json:"enabled"`
+	LastUsedStep *int64 `json:"-"`
+	CreatedAt    string `json:"created_at"`
+}
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+func (s *TwoFactorStore) GetByUserID(ctx context.Context, userID int64) (*TwoFactor, error) {
+	query := `SELECT user_id, secret, enabled, last_used_step, created_at FROM user_totp WHERE user_id = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var twoFactor TwoFactor
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(
+		&twoFactor.UserID,
+		&twoFactor.Secret,
+		&twoFactor.Enabled,
+		&twoFactor.LastUsedStep,
+		&twoFactor.CreatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &twoFactor, nil
+}
+
+// stores a new secret that stays disabled until it is confirmed with a code,
+// an unconfirmed enrollment is replaced
+func (s *TwoFactorStore) Enroll(ctx context.Context, userID int64, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = NULL, created_at = NOW()
+		WHERE user_totp.enabled = false
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+// turns 2fa on and stores the hashed recovery codes
+func (s *TwoFactorStore) Enable(ctx context.Context, userID int64, recoveryCodes []string) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `UPDATE user_totp SET enabled = true WHERE user_id = ($1)`, userID); err != nil {
+			return err
+		}
+		return s.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	})
+}
+
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int64) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = ($1)`, userID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = ($1)`, userID); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// remembers the time step of an accepted code so the same code can't be
+// replayed, ErrCodeReused is returned for a step that is not newer
+func (s *TwoFactorStore) RecordStep(ctx context.Context, userID int64, step int64) error {
+	query := `
+		UPDATE user_totp SET last_used_step = ($2)
+		WHERE user_id = ($1) AND (last_used_step IS NULL OR last_used_step < ($2))
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrCodeReused
+	}
+	return nil
+}
+
+// consumes a hashed recovery code, every code works only once
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int64, code string) error {
+	query := `
+		UPDATE totp_recovery_codes SET used_at = NOW()
+		WHERE user_id = ($1) AND code = ($2) AND used_at IS NULL
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, userID, code)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *TwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryCodes []string) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		return s.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	})
+}
+
+func (s *TwoFactorStore) replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM totp_recovery_codes WHERE user_id = ($1)`, userID); err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		query := `INSERT INTO totp_recovery_codes (user_id, code) VALUES ($1, $2)`
+		if _, err := tx.ExecContext(ctx, query, userID, code); err != nil {
+			return err
+		}
+	}
+	return nil
+}
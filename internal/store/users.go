@@ -8,7 +8,9 @@ import (
 	"errors"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/harshvse/go-api/internal/emailpolicy"
+	"github.com/harshvse/go-api/internal/hasher"
+	"github.com/lib/pq"
 )
 
 type User struct {
@@ -19,7 +21,25 @@ type User struct {
 	CreatedAt string   `json:"created_at"`
 	UpdatedAt string   `json:"updated_at"`
 	IsActive  bool     `json:"is_active"`
+	// tokens issued before this moment are rejected
+	TokensValidAfter *time.Time `json:"-"`
+	// only loaded by GetByID
+	Role *Role `json:"role,omitempty"`
+	// set on registration with an invite code of another user
+	ReferredBy   *int64 `json:"referred_by,omitempty"`
+	InviteCodeID *int64 `json:"-"`
+	// only loaded for provisioning, deactivated users were switched off by
+	// the identity provider instead of never being activated
+	ExternalID    *string    `json:"-"`
+	DeactivatedAt *time.Time `json:"-"`
 }
+type Invitation struct {
+	UserID   int64     `json:"user_id"`
+	Username string    `json:"username"`
+	Email    string    `json:"email"`
+	Expiry   time.Time `json:"expiry"`
+}
+
 type password struct {
 	text *string
 	hash []byte
@@ -28,10 +48,11 @@ type password struct {
 var (
 	ErrDuplicateEmail    = errors.New("a user already exists with that email")
 	ErrDuplicateUsername = errors.New("a user already exists with that username")
+	ErrDuplicateExternal = errors.New("a user already exists with that external id")
 )
 
 func (p *password) Set(text string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(text), bcrypt.DefaultCost)
+	hash, err := hasher.Default.Hash(text)
 	if err != nil {
 		return err
 	}
@@ -40,13 +61,23 @@ func (p *password) Set(text string) error {
 	return nil
 }
 
+func (p *password) Compare(text string) error {
+	return hasher.Default.Verify(text, p.hash)
+}
+
+// reports whether the hash was made with an older algorithm or parameters and
+// should be replaced once the plain password is known
+func (p *password) NeedsRehash() bool {
+	return hasher.Default.NeedsRehash(p.hash)
+}
+
 type UserStore struct {
 	db *sql.DB
 }
 
 func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
-	query := `INSERT INTO users (username,email,password)
-	VALUES($1,$2,$3) RETURNING id,created_at,updated_at
+	query := `INSERT INTO users (username,email,password,referred_by,invite_code_id,normalized_email)
+	VALUES($1,$2,$3,$4,$5,$6) RETURNING id,created_at,updated_at
 	`
 
 	err := tx.QueryRowContext(
@@ -55,6 +86,9 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 		user.Username,
 		user.Email,
 		user.Password.hash,
+		user.ReferredBy,
+		user.InviteCodeID,
+		emailpolicy.Normalize(user.Email),
 	).Scan(
 		&user.ID,
 		&user.CreatedAt,
@@ -64,6 +98,8 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
 			return ErrDuplicateEmail
+		case err.Error() == `pq: duplicate key value violates unique constraint "idx_users_normalized_email"`:
+			return ErrDuplicateEmail
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_username_key"`:
 			return ErrDuplicateUsername
 		default:
@@ -73,9 +109,35 @@ func (s *UserStore) Create(ctx context.Context, tx *sql.Tx, user *User) error {
 	return nil
 }
 
+// reports whether any user has an address that normalizes to the same inbox,
+// normalized comes from emailpolicy.Normalize
+func (s *UserStore) ExistsByNormalizedEmail(ctx context.Context, normalized string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE normalized_email = ($1))`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, query, normalized).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
 func (s *UserStore) GetByID(ctx context.Context, userId int64) (*User, error) {
-	query := `SELECT id,email,username,created_at,updated_at FROM users WHERE id=($1) AND is_active=true`
-	var user User
+	query := `
+		SELECT u.id, u.email, u.username, u.created_at, u.updated_at, u.tokens_valid_after,
+			r.id, r.name,
+			ARRAY(
+				SELECT p.code FROM permissions p
+				INNER JOIN role_permissions rp ON rp.permission_id = p.id
+				WHERE rp.role_id = r.id
+			)
+		FROM users u
+		INNER JOIN roles r ON r.id = u.role_id
+		WHERE u.id=($1) AND u.is_active=true
+	`
+	user := User{Role: &Role{}}
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
@@ -86,10 +148,14 @@ func (s *UserStore) GetByID(ctx context.Context, userId int64) (*User, error) {
 		&user.Username,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.TokensValidAfter,
+		&user.Role.ID,
+		&user.Role.Name,
+		pq.Array(&user.Role.Permissions),
 	)
 	if err != nil {
 		switch {
-		case errors.Is(err, ErrNotFound):
+		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrNotFound
 		default:
 			return nil, err
@@ -116,7 +182,38 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 	)
 	if err != nil {
 		switch {
-		case errors.Is(err, ErrNotFound):
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// returns a user that registered but never activated the account, users
+// deactivated by provisioning are left out
+func (s *UserStore) GetInactiveByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT id, email, username, created_at, updated_at FROM users
+		WHERE email=($1) AND is_active=false AND deactivated_at IS NULL
+	`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.Email,
+		&user.Username,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrNotFound
 		default:
 			return nil, err
@@ -125,8 +222,19 @@ func (s *UserStore) GetByEmail(ctx context.Context, email string) (*User, error)
 	return &user, nil
 }
 
-func (s *UserStore) CreateAndInviteUser(ctx context.Context, user *User, token string, invitationExp time.Duration) error {
+// inviteCode is the hash of the code the user registered with, empty when none
+// was given. The code is used up in the same transaction the user is created in
+func (s *UserStore) CreateAndInviteUser(ctx context.Context, user *User, token string, invitationExp time.Duration, inviteCode string) error {
 	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		if inviteCode != "" {
+			invite, err := useInviteCode(ctx, tx, inviteCode)
+			if err != nil {
+				return err
+			}
+			user.InviteCodeID = &invite.ID
+			user.ReferredBy = invite.CreatedByUserID
+		}
+
 		// Create the user
 		if err := s.Create(ctx, tx, user); err != nil {
 			return err
@@ -161,6 +269,171 @@ func (s *UserStore) ActivateUser(ctx context.Context, token string) error {
 	})
 }
 
+// swaps the pending invitation of the user for a new one, the old link stops working
+func (s *UserStore) ReplaceInvitation(ctx context.Context, userID int64, token string, invitationExp time.Duration) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deleteUserInvitation(ctx, tx, userID); err != nil {
+			return err
+		}
+		return s.createUserInvitation(ctx, tx, token, invitationExp, userID)
+	})
+}
+
+func (s *UserStore) ListExpiredInvitations(ctx context.Context) ([]Invitation, error) {
+	query := `
+		SELECT u.id, u.username, u.email, ui.expiry
+		FROM user_invitation ui
+		JOIN users u ON u.id = ui.user_id
+		WHERE ui.expiry <= ($1)
+		ORDER BY ui.expiry
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []Invitation{}
+	for rows.Next() {
+		var invitation Invitation
+		err := rows.Scan(
+			&invitation.UserID,
+			&invitation.Username,
+			&invitation.Email,
+			&invitation.Expiry,
+		)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+func (s *UserStore) PurgeExpiredInvitations(ctx context.Context) (int64, error) {
+	query := `DELETE FROM user_invitation WHERE expiry <= ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// lists accounts that registered more than olderThan ago and never activated,
+// accounts deactivated by provisioning are kept
+func (s *UserStore) ListUnactivated(ctx context.Context, olderThan time.Duration) ([]*User, error) {
+	query := `
+		SELECT id, email, username, created_at, updated_at, is_active
+		FROM users
+		WHERE is_active = false AND deactivated_at IS NULL AND created_at < ($1)
+		ORDER BY created_at
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, time.Now().Add(-olderThan))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user := &User{}
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.Username,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.IsActive,
+		)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// deletes accounts that registered more than olderThan ago and never activated
+// together with their invitations
+func (s *UserStore) PurgeUnactivated(ctx context.Context, olderThan time.Duration) (int64, error) {
+	var deleted int64
+	err := withTX(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		cutoff := time.Now().Add(-olderThan)
+
+		query := `
+			DELETE FROM user_invitation WHERE user_id IN (
+				SELECT id FROM users WHERE is_active = false AND deactivated_at IS NULL AND created_at < ($1)
+			)
+		`
+		if _, err := tx.ExecContext(ctx, query, cutoff); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE is_active = false AND deactivated_at IS NULL AND created_at < ($1)`, cutoff)
+		if err != nil {
+			return err
+		}
+		deleted, err = res.RowsAffected()
+		return err
+	})
+	return deleted, err
+}
+
+// creates an already active user for a verified identity from an external provider
+func (s *UserStore) CreateWithIdentity(ctx context.Context, user *User, identity *Identity) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.Create(ctx, tx, user); err != nil {
+			return err
+		}
+
+		user.IsActive = true
+		if err := s.updateUserAcitvation(ctx, tx, user); err != nil {
+			return err
+		}
+
+		identity.UserID = user.ID
+		return createIdentity(ctx, tx, identity)
+	})
+}
+
+// links the identity to an existing user. An account that was never activated
+// gets activated because the provider verified the email, its password is
+// replaced by the one in user so whoever registered it can't log in with theirs
+func (s *UserStore) LinkIdentityAndActivate(ctx context.Context, user *User, identity *Identity) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		if !user.IsActive {
+			if err := s.updatePassword(ctx, tx, user); err != nil {
+				return err
+			}
+
+			user.IsActive = true
+			if err := s.updateUserAcitvation(ctx, tx, user); err != nil {
+				return err
+			}
+
+			if err := s.deleteUserInvitation(ctx, tx, user.ID); err != nil {
+				return err
+			}
+		}
+
+		identity.UserID = user.ID
+		return createIdentity(ctx, tx, identity)
+	})
+}
+
 func (s *UserStore) Delete(ctx context.Context, userID int64) error {
 	return withTX(s.db, ctx, func(tx *sql.Tx) error {
 		if err := s.delete(ctx, tx, userID); err != nil {
@@ -174,6 +447,423 @@ func (s *UserStore) Delete(ctx context.Context, userID int64) error {
 	})
 }
 
+// removes an account the user asked to delete together with their posts and
+// comments, the rest of their data goes with the user row
+func (s *UserStore) DeleteAccount(ctx context.Context, userID int64) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		return s.deleteAccount(ctx, tx, userID)
+	})
+}
+
+func (s *UserStore) deleteAccount(ctx context.Context, tx *sql.Tx, userID int64) error {
+	if err := s.deleteContent(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	if err := s.deleteUserInvitation(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ($1)`, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// comments have no foreign keys and posts don't cascade, so both are removed by hand
+func (s *UserStore) deleteContent(ctx context.Context, tx *sql.Tx, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	query := `
+		DELETE FROM comments
+		WHERE user_id = $1 OR post_id IN (SELECT id FROM posts WHERE user_id = $1)
+	`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx, `DELETE FROM posts WHERE user_id = $1`, userID)
+	return err
+}
+
+// rejects every token issued so far, revokes the refresh tokens and deletes the
+// personal access tokens of the user, used to log out all sessions and after
+// the password was reset
+func (s *UserStore) InvalidateTokens(ctx context.Context, userID int64) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		return s.invalidateTokens(ctx, tx, userID)
+	})
+}
+
+func (s *UserStore) invalidateTokens(ctx context.Context, tx *sql.Tx, userID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET tokens_valid_after = NOW() WHERE id = ($1)`, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = ($1) AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+
+	query = `UPDATE sessions SET revoked_at = NOW() WHERE user_id = ($1) AND revoked_at IS NULL`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+
+	// personal access tokens aren't tied to a session and would outlive the revocation
+	query = `DELETE FROM personal_access_tokens WHERE user_id = ($1)`
+	if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// replaces any pending reset of the user with a new hashed token
+func (s *UserStore) CreatePasswordReset(ctx context.Context, userID int64, token string, resetExp time.Duration) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.deletePasswordResets(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO password_resets (token, user_id, expiry) VALUES ($1, $2, $3)`
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		_, err := tx.ExecContext(ctx, query, token, userID, time.Now().Add(resetExp))
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// remembers the id of a signed login link, a new link replaces the pending one
+func (s *UserStore) CreateMagicLink(ctx context.Context, userID int64, jti string, exp time.Duration) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM magic_links WHERE user_id = ($1)`, userID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO magic_links (jti, user_id, expiry) VALUES ($1, $2, $3)`
+		_, err := tx.ExecContext(ctx, query, jti, userID, time.Now().Add(exp))
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// deletes the link so it can only be used once, ErrNotFound when it was used,
+// replaced or has expired
+func (s *UserStore) ConsumeMagicLink(ctx context.Context, userID int64, jti string) error {
+	query := `DELETE FROM magic_links WHERE jti = ($1) AND user_id = ($2) AND expiry > ($3)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, jti, userID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// sets the password already hashed into user for the owner of the reset token,
+// the token is consumed and every issued token is invalidated
+func (s *UserStore) ResetPassword(ctx context.Context, token string, user *User) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		userID, err := s.getUserIDByPasswordResetToken(ctx, tx, token)
+		if err != nil {
+			return err
+		}
+		user.ID = userID
+
+		if err := s.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+
+		if err := s.deletePasswordResets(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		// a pending email change may have been started by whoever had the old password
+		if err := s.deleteEmailChanges(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		return s.invalidateTokens(ctx, tx, user.ID)
+	})
+}
+
+// returns the user an unexpired password reset token was made for, so the new
+// password can be checked against their name before the reset
+func (s *UserStore) GetByPasswordResetToken(ctx context.Context, token string) (*User, error) {
+	query := `
+		SELECT u.id, u.username, u.email
+		FROM users u
+		INNER JOIN password_resets pr ON pr.user_id = u.id
+		WHERE pr.token = ($1) AND pr.expiry > ($2)
+	`
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	var user User
+	err := s.db.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
+// stores the new password of the user and signs out every other session and
+// personal access token, the session with keepSessionID stays signed in
+func (s *UserStore) ChangePassword(ctx context.Context, user *User, keepSessionID string) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		if err := s.updatePassword(ctx, tx, user); err != nil {
+			return err
+		}
+
+		if err := s.deletePasswordResets(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		// a pending change could move the account to an address of whoever
+		// knew the old password
+		if err := s.deleteEmailChanges(ctx, tx, user.ID); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		query := `
+			UPDATE refresh_tokens SET revoked_at = NOW()
+			WHERE user_id = ($1) AND family_id <> ($2) AND revoked_at IS NULL
+		`
+		if _, err := tx.ExecContext(ctx, query, user.ID, keepSessionID); err != nil {
+			return err
+		}
+
+		query = `UPDATE sessions SET revoked_at = NOW() WHERE user_id = ($1) AND id <> ($2) AND revoked_at IS NULL`
+		if _, err := tx.ExecContext(ctx, query, user.ID, keepSessionID); err != nil {
+			return err
+		}
+
+		query = `DELETE FROM personal_access_tokens WHERE user_id = ($1)`
+		if _, err := tx.ExecContext(ctx, query, user.ID); err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *UserStore) getUserIDByPasswordResetToken(ctx context.Context, tx *sql.Tx, token string) (int64, error) {
+	query := `SELECT user_id FROM password_resets WHERE token = ($1) AND expiry > ($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	hash := sha256.Sum256([]byte(token))
+	hashToken := hex.EncodeToString(hash[:])
+
+	var userID int64
+	err := tx.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(&userID)
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+	return userID, nil
+}
+
+// replaces the stored hash of the verified plain password with one made by the
+// current algorithm. Nothing happens when the password was changed in the meantime
+func (s *UserStore) RehashPassword(ctx context.Context, user *User, text string) error {
+	query := `UPDATE users SET password = ($1) WHERE id = ($2) AND password = ($3)`
+
+	oldHash := user.Password.hash
+	if err := user.Password.Set(text); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, user.Password.hash, user.ID, oldHash)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *UserStore) updatePassword(ctx context.Context, tx *sql.Tx, user *User) error {
+	query := `UPDATE users SET password = ($1), updated_at = NOW() WHERE id = ($2)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, user.Password.hash, user.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *UserStore) deletePasswordResets(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM password_resets WHERE user_id = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// stores a pending change to newEmail, replacing an earlier pending change
+func (s *UserStore) CreateEmailChange(ctx context.Context, userID int64, newEmail, token string, exp time.Duration) error {
+	return withTX(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		// fail early, the address can still be claimed before the confirmation
+		var taken bool
+		if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = ($1))`, newEmail).Scan(&taken); err != nil {
+			return err
+		}
+		if taken {
+			return ErrDuplicateEmail
+		}
+
+		if err := s.deleteEmailChanges(ctx, tx, userID); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO email_changes (token, user_id, new_email, expiry) VALUES ($1, $2, $3, $4)`
+		_, err := tx.ExecContext(ctx, query, token, userID, newEmail, time.Now().Add(exp))
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+// applies the pending email change belonging to the token and returns the updated user
+func (s *UserStore) ConfirmEmailChange(ctx context.Context, token string) (*User, error) {
+	user := &User{}
+	err := withTX(s.db, ctx, func(tx *sql.Tx) error {
+		ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+		defer cancel()
+
+		hash := sha256.Sum256([]byte(token))
+		hashToken := hex.EncodeToString(hash[:])
+
+		query := `SELECT user_id, new_email FROM email_changes WHERE token = ($1) AND expiry > ($2)`
+		err := tx.QueryRowContext(ctx, query, hashToken, time.Now()).Scan(&user.ID, &user.Email)
+		if err != nil {
+			switch err {
+			case sql.ErrNoRows:
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		query = `
+			UPDATE users SET email = ($1), normalized_email = ($2), updated_at = NOW()
+			WHERE id = ($3)
+			RETURNING username, created_at, updated_at, is_active
+		`
+		err = tx.QueryRowContext(ctx, query, user.Email, emailpolicy.Normalize(user.Email), user.ID).Scan(
+			&user.Username,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.IsActive,
+		)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			case err.Error() == `pq: duplicate key value violates unique constraint "idx_users_normalized_email"`:
+				return ErrDuplicateEmail
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrNotFound
+			default:
+				return err
+			}
+		}
+
+		return s.deleteEmailChanges(ctx, tx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserStore) deleteEmailChanges(ctx context.Context, tx *sql.Tx, userID int64) error {
+	query := `DELETE FROM email_changes WHERE user_id = ($1)`
+
+	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *UserStore) createUserInvitation(ctx context.Context, tx *sql.Tx, token string, invitationExp time.Duration, userID int64) error {
 	query := `INSERT INTO user_invitation (token, user_id, expiry) VALUES ($1, $2, $3)`
 
@@ -221,12 +911,12 @@ func (s *UserStore) getUserByInvitationToken(ctx context.Context, tx *sql.Tx, to
 }
 
 func (s *UserStore) updateUserAcitvation(ctx context.Context, tx *sql.Tx, user *User) error {
-	query := `UPDATE users SET username = ($1), email = ($2), is_active = ($3) WHERE id = ($4)`
+	query := `UPDATE users SET username = ($1), email = ($2), normalized_email = ($3), is_active = ($4) WHERE id = ($5)`
 
 	ctx, cancel := context.WithTimeout(ctx, QueryTimeoutDuration)
 	defer cancel()
 
-	_, err := tx.ExecContext(ctx, query, user.Username, user.Email, user.IsActive, user.ID)
+	_, err := tx.ExecContext(ctx, query, user.Username, user.Email, emailpolicy.Normalize(user.Email), user.IsActive, user.ID)
 	if err != nil {
 		return err
 	}
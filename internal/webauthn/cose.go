@@ -0,0 +1,114 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers we accept for credentials
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+const (
+	coseKtyOKP int64 = 1
+	coseKtyEC2 int64 = 2
+	coseKtyRSA int64 = 3
+
+	coseCrvP256    int64 = 1
+	coseCrvEd25519 int64 = 6
+)
+
+var ErrInvalidSignature = errors.New("webauthn: invalid signature")
+
+// parses a COSE_Key as stored in the attested credential data
+func parsePublicKey(coseKey []byte) (int64, crypto.PublicKey, error) {
+	decoded, rest, err := decodeCBOR(coseKey)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(rest) != 0 {
+		return 0, nil, errors.New("webauthn: trailing data after public key")
+	}
+
+	key, ok := decoded.(map[any]any)
+	if !ok {
+		return 0, nil, errors.New("webauthn: public key is not a map")
+	}
+
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, errors.New("webauthn: invalid P-256 public key")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return 0, nil, errors.New("webauthn: public key is not on the curve")
+		}
+		return alg, pub, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, errors.New("webauthn: invalid Ed25519 public key")
+		}
+		return alg, ed25519.PublicKey(x), nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return 0, nil, errors.New("webauthn: invalid RSA public key")
+		}
+		return alg, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	default:
+		return 0, nil, fmt.Errorf("webauthn: unsupported key type %d with algorithm %d", kty, alg)
+	}
+}
+
+// verifies a signature made by the credential over data
+func verifySignature(coseKey, data, signature []byte) error {
+	_, pub, err := parsePublicKey(coseKey)
+	if err != nil {
+		return err
+	}
+
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(data)
+		if !ecdsa.VerifyASN1(key, hash[:], signature) {
+			return ErrInvalidSignature
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, data, signature) {
+			return ErrInvalidSignature
+		}
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(data)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+			return ErrInvalidSignature
+		}
+	}
+	return nil
+}
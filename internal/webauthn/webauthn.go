@@ -0,0 +1,227 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var (
+	ErrChallengeMismatch = errors.New("webauthn: challenge does not match")
+	ErrOriginMismatch    = errors.New("webauthn: origin does not match")
+	ErrRPIDMismatch      = errors.New("webauthn: relying party id does not match")
+	ErrUserNotPresent    = errors.New("webauthn: user presence flag not set")
+	ErrUserNotVerified   = errors.New("webauthn: user verification flag not set")
+	ErrSignCount         = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+)
+
+// RelyingParty verifies the registration and authentication ceremonies for a
+// single site. ID is the domain the credentials are scoped to and Origin the
+// exact origin the browser reports
+type RelyingParty struct {
+	ID                      string
+	Name                    string
+	Origin                  string
+	RequireUserVerification bool
+}
+
+// Credential is what a registration produces and what has to be stored to
+// verify later assertions
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	Algorithm int64
+	AAGUID    []byte
+	SignCount uint32
+}
+
+// returns a random challenge encoded the way it comes back in clientDataJSON
+func NewChallenge() (string, error) {
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(challenge), nil
+}
+
+// decodes base64url with or without padding as sent by browsers
+func DecodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// reads the challenge out of clientDataJSON so the caller can look up the
+// ceremony it belongs to before verifying anything else
+func ChallengeFromClientData(clientDataJSON []byte) (string, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return "", err
+	}
+	if data.Challenge == "" {
+		return "", errors.New("webauthn: client data has no challenge")
+	}
+	return data.Challenge, nil
+}
+
+func (rp *RelyingParty) verifyClientData(clientDataJSON []byte, ceremony, challenge string) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return err
+	}
+	if data.Type != ceremony {
+		return fmt.Errorf("webauthn: unexpected client data type %q", data.Type)
+	}
+	if data.Challenge != challenge {
+		return ErrChallengeMismatch
+	}
+	if data.Origin != rp.Origin {
+		return ErrOriginMismatch
+	}
+	return nil
+}
+
+type authenticatorData struct {
+	flags     byte
+	signCount uint32
+	// only present for registrations
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func (rp *RelyingParty) parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data too short")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data[:32], rpIDHash[:]) {
+		return nil, ErrRPIDMismatch
+	}
+
+	parsed := &authenticatorData{
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if parsed.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+	if rp.RequireUserVerification && parsed.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+
+	if parsed.flags&flagAttestedData == 0 {
+		return parsed, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, errors.New("webauthn: attested credential data too short")
+	}
+	parsed.aaguid = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, errors.New("webauthn: credential id truncated")
+	}
+	parsed.credentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// the key is followed by the extensions, decode it to find where it ends
+	_, afterKey, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, err
+	}
+	parsed.publicKey = rest[:len(rest)-len(afterKey)]
+	return parsed, nil
+}
+
+// verifies the response of navigator.credentials.create(). Only the "none"
+// attestation format is accepted since we never ask for attestation
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	attestation, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object is not a map")
+	}
+
+	format, _ := attestation["fmt"].(string)
+	if format != "none" {
+		return nil, fmt.Errorf("webauthn: unsupported attestation format %q", format)
+	}
+
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if authData.credentialID == nil {
+		return nil, errors.New("webauthn: registration has no attested credential")
+	}
+
+	alg, _, err := parsePublicKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        authData.credentialID,
+		PublicKey: authData.publicKey,
+		Algorithm: alg,
+		AAGUID:    authData.aaguid,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// verifies the response of navigator.credentials.get() against a stored
+// credential and returns the new signature counter
+func (rp *RelyingParty) VerifyAssertion(challenge string, credential *Credential, clientDataJSON, rawAuthData, signature []byte) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+	if err := verifySignature(credential.PublicKey, signed, signature); err != nil {
+		return 0, err
+	}
+
+	// authenticators that don't count always send 0
+	if (authData.signCount != 0 || credential.SignCount != 0) && authData.signCount <= credential.SignCount {
+		return 0, ErrSignCount
+	}
+	return authData.signCount, nil
+}
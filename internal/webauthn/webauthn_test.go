@@ -0,0 +1,385 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// encodes the values the software authenticator needs in the canonical CTAP2 form
+func encodeCBOR(value any) []byte {
+	var buf bytes.Buffer
+	writeCBOR(&buf, value)
+	return buf.Bytes()
+}
+
+func writeHead(buf *bytes.Buffer, major byte, arg uint64) {
+	switch {
+	case arg < 24:
+		buf.WriteByte(major<<5 | byte(arg))
+	case arg <= 0xff:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(arg))
+	case arg <= 0xffff:
+		buf.WriteByte(major<<5 | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(arg)))
+	case arg <= 0xffffffff:
+		buf.WriteByte(major<<5 | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(arg)))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, arg))
+	}
+}
+
+func writeCBOR(buf *bytes.Buffer, value any) {
+	switch v := value.(type) {
+	case int64:
+		if v >= 0 {
+			writeHead(buf, 0, uint64(v))
+		} else {
+			writeHead(buf, 1, uint64(-1-v))
+		}
+	case int:
+		writeCBOR(buf, int64(v))
+	case []byte:
+		writeHead(buf, 2, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		writeHead(buf, 3, uint64(len(v)))
+		buf.WriteString(v)
+	case []any:
+		writeHead(buf, 4, uint64(len(v)))
+		for _, item := range v {
+			writeCBOR(buf, item)
+		}
+	case map[any]any:
+		// canonical order sorts the encoded keys
+		keys := make([][]byte, 0, len(v))
+		values := make(map[string]any, len(v))
+		for key, item := range v {
+			encoded := encodeCBOR(key)
+			keys = append(keys, encoded)
+			values[string(encoded)] = item
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+		writeHead(buf, 5, uint64(len(v)))
+		for _, key := range keys {
+			buf.Write(key)
+			writeCBOR(buf, values[string(key)])
+		}
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	default:
+		panic(fmt.Sprintf("cbor: can't encode %T", value))
+	}
+}
+
+// softAuthenticator is an ES256 authenticator living in memory, the fields
+// can be changed between ceremonies to make it misbehave
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+	rpID         string
+	origin       string
+	userVerified bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	rand.Read(credentialID)
+	return &softAuthenticator{
+		key:          key,
+		credentialID: credentialID,
+		rpID:         testRPID,
+		origin:       testOrigin,
+		userVerified: true,
+	}
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	flags := byte(flagUserPresent)
+	if a.userVerified {
+		flags |= flagUserVerified
+	}
+	if attested {
+		flags |= flagAttestedData
+	}
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if !attested {
+		return data
+	}
+
+	data = append(data, make([]byte, 16)...)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, a.coseKey()...)
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	x := a.key.X.FillBytes(make([]byte, 32))
+	y := a.key.Y.FillBytes(make([]byte, 32))
+	return encodeCBOR(map[any]any{
+		int64(1):  coseKtyEC2,
+		int64(3):  AlgES256,
+		int64(-1): coseCrvP256,
+		int64(-2): x,
+		int64(-3): y,
+	})
+}
+
+// answers navigator.credentials.create()
+func (a *softAuthenticator) register(challenge string) (clientDataJSON, attestationObject []byte) {
+	clientDataJSON = a.clientData("webauthn.create", challenge)
+	attestationObject = encodeCBOR(map[any]any{
+		"fmt":      "none",
+		"attStmt":  map[any]any{},
+		"authData": a.authData(true),
+	})
+	return clientDataJSON, attestationObject
+}
+
+// answers navigator.credentials.get(), counting the use first like hardware does
+func (a *softAuthenticator) assert(t *testing.T, challenge string) (clientDataJSON, authData, signature []byte) {
+	t.Helper()
+	a.signCount++
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authData = a.authData(false)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	hash := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientDataJSON, authData, signature
+}
+
+func newTestRelyingParty() *RelyingParty {
+	return &RelyingParty{
+		ID:                      testRPID,
+		Name:                    "Example",
+		Origin:                  testOrigin,
+		RequireUserVerification: true,
+	}
+}
+
+func registerSoftAuthenticator(t *testing.T, rp *RelyingParty, authenticator *softAuthenticator) *Credential {
+	t.Helper()
+	challenge, err := NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientDataJSON, attestationObject := authenticator.register(challenge)
+	credential, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatalf("registration: %v", err)
+	}
+	return credential
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newSoftAuthenticator(t)
+
+	credential := registerSoftAuthenticator(t, rp, authenticator)
+	if !bytes.Equal(credential.ID, authenticator.credentialID) {
+		t.Fatalf("credential id = %x, want %x", credential.ID, authenticator.credentialID)
+	}
+	if credential.Algorithm != AlgES256 {
+		t.Fatalf("algorithm = %d, want %d", credential.Algorithm, AlgES256)
+	}
+
+	for i := 1; i <= 2; i++ {
+		challenge, _ := NewChallenge()
+		clientDataJSON, authData, signature := authenticator.assert(t, challenge)
+
+		got, err := ChallengeFromClientData(clientDataJSON)
+		if err != nil || got != challenge {
+			t.Fatalf("challenge from client data = %q, %v", got, err)
+		}
+
+		signCount, err := rp.VerifyAssertion(challenge, credential, clientDataJSON, authData, signature)
+		if err != nil {
+			t.Fatalf("assertion %d: %v", i, err)
+		}
+		if signCount != uint32(i) {
+			t.Fatalf("sign count = %d, want %d", signCount, i)
+		}
+		credential.SignCount = signCount
+	}
+}
+
+func TestRegistrationRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*softAuthenticator)
+		want   error
+	}{
+		{"bad origin", func(a *softAuthenticator) { a.origin = "https://evil.example" }, ErrOriginMismatch},
+		{"bad rp id hash", func(a *softAuthenticator) { a.rpID = "evil.example" }, ErrRPIDMismatch},
+		{"user not verified", func(a *softAuthenticator) { a.userVerified = false }, ErrUserNotVerified},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newTestRelyingParty()
+			authenticator := newSoftAuthenticator(t)
+			tt.change(authenticator)
+
+			challenge, _ := NewChallenge()
+			clientDataJSON, attestationObject := authenticator.register(challenge)
+			if _, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject); !errors.Is(err, tt.want) {
+				t.Fatalf("registration = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistrationRejectsOtherChallenge(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newSoftAuthenticator(t)
+
+	challenge, _ := NewChallenge()
+	other, _ := NewChallenge()
+	clientDataJSON, attestationObject := authenticator.register(other)
+	if _, err := rp.VerifyRegistration(challenge, clientDataJSON, attestationObject); !errors.Is(err, ErrChallengeMismatch) {
+		t.Fatalf("registration = %v, want %v", err, ErrChallengeMismatch)
+	}
+}
+
+func TestAssertionRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*softAuthenticator)
+		want   error
+	}{
+		{"bad origin", func(a *softAuthenticator) { a.origin = "https://evil.example" }, ErrOriginMismatch},
+		{"bad rp id hash", func(a *softAuthenticator) { a.rpID = "evil.example" }, ErrRPIDMismatch},
+		{"user not verified", func(a *softAuthenticator) { a.userVerified = false }, ErrUserNotVerified},
+		// a clone still at the counter of the copied key
+		{"sign counter regression", func(a *softAuthenticator) { a.signCount = 3 }, ErrSignCount},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := newTestRelyingParty()
+			authenticator := newSoftAuthenticator(t)
+			credential := registerSoftAuthenticator(t, rp, authenticator)
+			credential.SignCount = 5
+
+			tt.change(authenticator)
+			challenge, _ := NewChallenge()
+			clientDataJSON, authData, signature := authenticator.assert(t, challenge)
+			if _, err := rp.VerifyAssertion(challenge, credential, clientDataJSON, authData, signature); !errors.Is(err, tt.want) {
+				t.Fatalf("assertion = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssertionWithoutUserVerificationWhenNotRequired(t *testing.T) {
+	rp := newTestRelyingParty()
+	rp.RequireUserVerification = false
+	authenticator := newSoftAuthenticator(t)
+	authenticator.userVerified = false
+	credential := registerSoftAuthenticator(t, rp, authenticator)
+
+	challenge, _ := NewChallenge()
+	clientDataJSON, authData, signature := authenticator.assert(t, challenge)
+	if _, err := rp.VerifyAssertion(challenge, credential, clientDataJSON, authData, signature); err != nil {
+		t.Fatalf("assertion: %v", err)
+	}
+}
+
+func TestAssertionRejectsTampering(t *testing.T) {
+	rp := newTestRelyingParty()
+	authenticator := newSoftAuthenticator(t)
+	credential := registerSoftAuthenticator(t, rp, authenticator)
+
+	challenge, _ := NewChallenge()
+	clientDataJSON, authData, signature := authenticator.assert(t, challenge)
+
+	// raising the counter by hand breaks the signature over the authenticator data
+	tampered := append([]byte{}, authData...)
+	binary.BigEndian.PutUint32(tampered[33:37], 100)
+	if _, err := rp.VerifyAssertion(challenge, credential, clientDataJSON, tampered, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("tampered authenticator data = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	// a signature by another key
+	other := newSoftAuthenticator(t)
+	other.signCount = authenticator.signCount - 1
+	_, _, otherSignature := other.assert(t, challenge)
+	if _, err := rp.VerifyAssertion(challenge, credential, clientDataJSON, authData, otherSignature); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("signature of another key = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	// the response to another challenge
+	otherChallenge, _ := NewChallenge()
+	if _, err := rp.VerifyAssertion(otherChallenge, credential, clientDataJSON, authData, signature); !errors.Is(err, ErrChallengeMismatch) {
+		t.Fatalf("other challenge = %v, want %v", err, ErrChallengeMismatch)
+	}
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+	for name, input := range map[string][]byte{
+		"empty":              {},
+		"truncated string":   {0x45, 0x01, 0x02},
+		"indefinite length":  {0x5f},
+		"truncated map":      {0xa2, 0x01},
+		"unsupported key":    {0xa1, 0x40, 0x01},
+		"truncated argument": {0x19, 0x01},
+	} {
+		if _, _, err := decodeCBOR(input); err == nil {
+			t.Errorf("%s: decoded without error", name)
+		}
+	}
+
+	nested := bytes.Repeat([]byte{0x81}, maxCBORDepth+2)
+	if _, _, err := decodeCBOR(append(nested, 0x00)); err == nil {
+		t.Error("nesting past the limit decoded without error")
+	}
+}